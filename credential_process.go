@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const credentialProcessUsage = `usage: liquidswards [-name <name>] credential-process <principal>
+
+Print the credentials of a principal in the graph saved by a previous scan in the format expected by the
+credential_process setting in ~/.aws/config. Expired credentials are refreshed by assuming the role again through the
+graph. <principal> is an ARN, profile name or role/user name.
+
+`
+
+// CredentialProcess runs the credential-process command, the credentials are written to w as JSON.
+func CredentialProcess(g *graph.Graph[*creds.Config], args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("credential-process", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		utils.Must(fmt.Fprint(os.Stderr, credentialProcessUsage))
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("CredentialProcess(): %w", err)
+	} else if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("CredentialProcess(): expected one principal, got %d", fs.NArg())
+	}
+
+	arn, err := resolvePrincipal(g, fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("CredentialProcess(): %w", err)
+	}
+
+	c, err := creds.Fresh(ctx, g, arn)
+	if err != nil {
+		return fmt.Errorf("CredentialProcess(): %w", err)
+	}
+	return writeJson(w, creds.NewProcessCredentials(c))
+}
+
+const awsConfigUsage = `usage: liquidswards [-name <name>] aws-config [-prefix <prefix>] [-o <path>]
+
+Generate a profile for each principal in the graph saved by a previous scan that uses the credential-process command.
+The profiles are named <prefix><role name>, with the account ID appended when the name is used in more than one
+account. When -o is an existing file, like ~/.aws/config, the profiles from the last run with the same -name are
+replaced and the rest of the file is left as is.
+
+`
+
+// AwsConfig runs the aws-config command, the profiles are written to stdout unless -o is used.
+func AwsConfig(g *graph.Graph[*creds.Config], args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("aws-config", flag.ContinueOnError)
+	fs.SetOutput(w)
+	prefix := fs.String("prefix", *name+"-", "Prefix of the generated profile names.")
+	out := fs.String("o", "", "Config file to update, defaults to stdout.")
+	fs.Usage = func() {
+		utils.Must(fmt.Fprint(w, awsConfigUsage))
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("AwsConfig(): %w", err)
+	} else if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("AwsConfig(): unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("AwsConfig(): %w", err)
+	}
+
+	command := []string{exe, "-name", *name}
+	if *ageIdentity != "" {
+		identity, err := filepath.Abs(*ageIdentity)
+		if err != nil {
+			return fmt.Errorf("AwsConfig(): %w", err)
+		}
+		command = append(command, "-age-identity", identity)
+	}
+
+	block := AwsConfigProfiles(g, *prefix, command)
+	if *out == "" {
+		_, err := io.WriteString(os.Stdout, block)
+		return err
+	}
+
+	path := utils.Must(utils.ExpandPath(*out))
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("AwsConfig(): %w", err)
+	}
+	if err := os.WriteFile(path, replaceBlock(current, *name, block), 0600); err != nil {
+		return fmt.Errorf("AwsConfig(): %w", err)
+	}
+	ctx.Info.Printf("wrote %d profiles to %s\n", strings.Count(block, "[profile "), path)
+	return nil
+}
+
+// AwsConfigProfiles returns a profile for each principal in g that gets its credentials by running command with
+// credential-process. Users discovered with -users are skipped since we don't have credentials for them.
+func AwsConfigProfiles(g *graph.Graph[*creds.Config], prefix string, command []string) string {
+	accounts := map[string][]string{}
+	for _, node := range g.Nodes() {
+		cfg := node.Value()
+		accounts[cfg.Name()] = append(accounts[cfg.Name()], cfg.Account())
+	}
+
+	ids := utils.Keys(g.Nodes())
+	sort.Strings(ids)
+
+	var b strings.Builder
+	for _, id := range ids {
+		cfg, _ := g.GetNode(id)
+		if cfg.Value().Identity.Type == creds.SourceUser {
+			continue
+		}
+		profile := prefix + cfg.Value().Name()
+		if len(utils.FilterDuplicates(accounts[cfg.Value().Name()])) > 1 {
+			profile += "-" + cfg.Value().Account()
+		}
+
+		utils.Must(fmt.Fprintf(&b, "\n[profile %s]\n", profileName(profile)))
+		utils.Must(fmt.Fprintf(&b, "credential_process = %s\n", strings.Join(append(quoteArgs(command), "credential-process", id), " ")))
+		if cfg.Value().Region != "" {
+			utils.Must(fmt.Fprintf(&b, "region = %s\n", cfg.Value().Region))
+		}
+	}
+	return b.String()
+}
+
+var unsafeProfileChars = regexp.MustCompile(`[^A-Za-z0-9_.@+=,-]`)
+
+func profileName(s string) string {
+	return unsafeProfileChars.ReplaceAllString(s, "_")
+}
+
+// quoteArgs quotes arguments with spaces, the AWS CLI and SDKs split credential_process on whitespace but respect
+// double quotes.
+func quoteArgs(args []string) []string {
+	var resp []string
+	for _, arg := range args {
+		if strings.ContainsAny(arg, " \t") {
+			arg = `"` + arg + `"`
+		}
+		resp = append(resp, arg)
+	}
+	return resp
+}
+
+// replaceBlock replaces the profiles generated for the named environment in a config file, or appends them if they
+// aren't there yet.
+func replaceBlock(current []byte, name, block string) []byte {
+	begin := fmt.Sprintf("# BEGIN liquidswards %s\n", name)
+	end := fmt.Sprintf("# END liquidswards %s\n", name)
+	block = begin + block + end
+
+	if i := bytes.Index(current, []byte(begin)); i != -1 {
+		if j := bytes.Index(current[i:], []byte(end)); j != -1 {
+			return append(append(current[:i:i], block...), current[i+j+len(end):]...)
+		}
+	}
+	if len(current) != 0 && !bytes.HasSuffix(current, []byte("\n")) {
+		current = append(current, '\n')
+	}
+	return append(current, block...)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/snapshot"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"io"
+	"path/filepath"
+)
+
+const diffUsage = `usage: liquidswards [-name <name>] diff [-account <id>] [-json] [<old> [<new>]]
+       liquidswards [-name <name>] diff -list
+
+Show the access gained and lost between two scans. Every scan saves a snapshot of the graph to
+~/.liquidswards/<name>/snapshots, <old> and <new> are snapshot names, a unique prefix of one like the date, or the path
+to a saved graph. By default the last two scans are compared, if only <old> is given it is compared to the last scan.
+
+`
+
+// Diff runs the diff command against the snapshots in programDir, the output is written to w.
+func Diff(programDir string, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(w)
+	account := fs.String("account", "", "Only show principals in this account and edges into or out of it.")
+	list := fs.Bool("list", false, "List the saved snapshots, oldest first.")
+	asJson := fs.Bool("json", false, "Print the result as JSON.")
+	fs.Usage = func() {
+		utils.Must(fmt.Fprint(w, diffUsage))
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("Diff(): %w", err)
+	} else if fs.NArg() > 2 {
+		fs.Usage()
+		return fmt.Errorf("Diff(): expected at most two snapshots, got %d", fs.NArg())
+	}
+
+	names, err := snapshot.List(programDir)
+	if err != nil {
+		return fmt.Errorf("Diff(): %w", err)
+	}
+
+	if *list {
+		if *asJson {
+			if names == nil {
+				names = []string{}
+			}
+			return writeJson(w, names)
+		}
+		for _, name := range names {
+			utils.Must(fmt.Fprintln(w, name))
+		}
+		return nil
+	}
+
+	refs := fs.Args()
+	if len(refs) < 2 {
+		if len(names) < 2-len(refs) {
+			return fmt.Errorf("Diff(): not enough snapshots to compare, found %d", len(names))
+		}
+		refs = append(refs, names[len(names)-2+len(refs):]...)
+	}
+
+	var graphs []*graph.Graph[*creds.Config]
+	for i, ref := range refs {
+		path, err := snapshot.Resolve(programDir, ref)
+		if err != nil {
+			return fmt.Errorf("Diff(): %w", err)
+		}
+
+		g := graph.NewDirectedGraph[*creds.Config]()
+		if err := g.Load(path); err != nil {
+			return fmt.Errorf("Diff(): %w", err)
+		}
+		graphs = append(graphs, g)
+
+		if !*asJson {
+			utils.Must(fmt.Fprintf(w, "%s %s\n", []string{"old:", "new:"}[i], filepath.Base(path)))
+		}
+	}
+
+	d := snapshot.Compare(graphs[0], graphs[1])
+	if *account != "" {
+		d = d.Filter(*account)
+	}
+
+	if *asJson {
+		return writeJson(w, d)
+	}
+	d.Print(w)
+	return nil
+}
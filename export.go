@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/export"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const exportUsage = `usage: liquidswards [-name <name>] export [-format <format>] [-o <path>]
+
+Export the graph saved by a previous scan for use in other tools.
+
+`
+
+// Export runs the export command against the saved graph, usage errors are written to w.
+func Export(g *graph.Graph[*creds.Config], programDir string, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(w)
+	format := fs.String("format", export.FormatCypher, fmt.Sprintf(`
+Output format, one of: %s. The cypher script can be loaded with cypher-shell, the neo4j-csv files with
+neo4j-admin import, graphml with tools like Gephi, yEd or Cytoscape and html is a self-contained report that can be
+opened in a browser.
+`, strings.Join(export.Formats, ", ")))
+	out := fs.String("o", "", `
+Output path, defaults to stdout. For neo4j-csv this is a directory which defaults to ~/.liquidswards/<name>/neo4j.
+`)
+	fs.Usage = func() {
+		utils.Must(fmt.Fprint(w, exportUsage))
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("Export(): %w", err)
+	} else if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("Export(): unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	path := *out
+	if path == "" && *format == export.FormatNeo4jCSV {
+		path = filepath.Join(programDir, "neo4j")
+	}
+
+	if err := export.Write(*format, path, export.FromGraph(g)); err != nil {
+		return fmt.Errorf("Export(): %w", err)
+	}
+	if path != "" {
+		ctx.Info.Printf("exported %s to %s\n", *format, path)
+	}
+	return nil
+}
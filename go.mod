@@ -3,12 +3,20 @@ module github.com/RyanJarv/liquidswards
 go 1.22
 
 require (
+	filippo.io/age v1.0.0
 	github.com/alitto/pond v1.7.0
 	github.com/aws/aws-sdk-go-v2 v1.13.0
 	github.com/aws/aws-sdk-go-v2/config v1.13.1
 	github.com/aws/aws-sdk-go-v2/credentials v1.8.0
+	github.com/aws/aws-sdk-go-v2/feature/ec2/imds v1.10.0
+	github.com/aws/aws-sdk-go-v2/service/cloudformation v1.18.0
 	github.com/aws/aws-sdk-go-v2/service/cloudtrail v1.13.1
+	github.com/aws/aws-sdk-go-v2/service/codebuild v1.17.0
+	github.com/aws/aws-sdk-go-v2/service/ecs v1.16.0
 	github.com/aws/aws-sdk-go-v2/service/iam v1.16.0
+	github.com/aws/aws-sdk-go-v2/service/lambda v1.17.0
+	github.com/aws/aws-sdk-go-v2/service/organizations v1.12.0
+	github.com/aws/aws-sdk-go-v2/service/s3 v1.24.1
 	github.com/aws/aws-sdk-go-v2/service/sqs v1.16.0
 	github.com/aws/aws-sdk-go-v2/service/sts v1.14.0
 	github.com/aws/smithy-go v1.10.0
@@ -17,18 +25,24 @@ require (
 	github.com/goccy/go-graphviz v0.1.3
 	github.com/golang/mock v1.6.0
 	github.com/google/go-cmp v0.5.7
+	golang.org/x/term v0.6.0
 )
 
 require (
-	github.com/aws/aws-sdk-go-v2/feature/ec2/imds v1.10.0 // indirect
+	github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream v1.2.0 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/configsources v1.1.4 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/endpoints/v2 v2.2.0 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/ini v1.3.5 // indirect
+	github.com/aws/aws-sdk-go-v2/service/internal/accept-encoding v1.7.0 // indirect
 	github.com/aws/aws-sdk-go-v2/service/internal/presigned-url v1.7.0 // indirect
+	github.com/aws/aws-sdk-go-v2/service/internal/s3shared v1.11.0 // indirect
 	github.com/aws/aws-sdk-go-v2/service/sso v1.9.0 // indirect
 	github.com/fogleman/gg v1.3.0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/crypto v0.7.0 // indirect
 	golang.org/x/image v0.14.0 // indirect
+	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
@@ -0,0 +1,202 @@
+// Package checkpoint records scan progress to disk as it happens so interrupted scans can be resumed with -resume.
+//
+// The checkpoint file is a list of JSON objects, one per line, that is only ever appended to. Each discovered role
+// and each tested (source, target) pair is written as soon as it is known, so a crash or SIGINT loses at most the
+// record being written.
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+type Kind string
+
+const (
+	// RoleKind entries record a role ARN that was added to FoundRoles.
+	RoleKind Kind = "role"
+	// TestedKind entries record a (source, target) pair that sts:AssumeRole was attempted on.
+	TestedKind Kind = "tested"
+)
+
+type Entry struct {
+	Kind    Kind          `json:"Kind"`
+	Arn     string        `json:"Arn,omitempty"`
+	Source  string        `json:"Source,omitempty"`
+	Target  string        `json:"Target,omitempty"`
+	Allowed bool          `json:"Allowed,omitempty"`
+	Denial  *graph.Denial `json:"Denial,omitempty"`
+}
+
+// Open opens the checkpoint at path. If resume is true existing entries are loaded and new entries are appended,
+// otherwise the file is truncated.
+func Open(path string, resume bool) (*Checkpoint, error) {
+	c := &Checkpoint{
+		m:      &sync.Mutex{},
+		tested: map[string]Entry{},
+		roles:  map[string]bool{},
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		if err := c.load(path); err != nil {
+			return nil, fmt.Errorf("Open(): %w", err)
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, fs.FileMode(0o600))
+	if err != nil {
+		return nil, fmt.Errorf("Open(): %w", err)
+	}
+	c.file = f
+	c.enc = json.NewEncoder(f)
+
+	return c, nil
+}
+
+// Checkpoint tracks the roles and tested pairs of the current scan. All methods are safe to call on a nil
+// *Checkpoint, in which case nothing is recorded and nothing is reported as previously tested.
+type Checkpoint struct {
+	m         *sync.Mutex
+	file      *os.File
+	enc       *json.Encoder
+	tested    map[string]Entry
+	roles     map[string]bool
+	roleOrder []string
+}
+
+func (c *Checkpoint) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("load(): %w", err)
+	}
+	defer f.Close()
+
+	// offset is the end of the last complete entry.
+	var offset int64
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// The last line may be partially written if we were killed, anything after it can't be trusted.
+			break
+		}
+		c.apply(entry)
+		offset += int64(len(scanner.Bytes())) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("load(): %w", err)
+	}
+
+	// Drop any partially written entry so new entries start on a line of their own.
+	if err := os.Truncate(path, offset); err != nil {
+		return fmt.Errorf("load(): %w", err)
+	}
+	return nil
+}
+
+func (c *Checkpoint) apply(entry Entry) {
+	switch entry.Kind {
+	case RoleKind:
+		if !c.roles[entry.Arn] {
+			c.roles[entry.Arn] = true
+			c.roleOrder = append(c.roleOrder, entry.Arn)
+		}
+	case TestedKind:
+		c.tested[pairKey(entry.Source, entry.Target)] = entry
+	}
+}
+
+func (c *Checkpoint) write(entry Entry) error {
+	if c == nil {
+		return nil
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.apply(entry)
+	if err := c.enc.Encode(entry); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// AddRole records that arn was discovered.
+func (c *Checkpoint) AddRole(arn string) error {
+	if c != nil {
+		c.m.Lock()
+		seen := c.roles[arn]
+		c.m.Unlock()
+		if seen {
+			return nil
+		}
+	}
+	return c.write(Entry{Kind: RoleKind, Arn: arn})
+}
+
+// AddTested records that sts:AssumeRole was attempted from source to target. The denial should be nil if the attempt
+// succeeded.
+func (c *Checkpoint) AddTested(source, target string, denial *graph.Denial) error {
+	return c.write(Entry{
+		Kind:    TestedKind,
+		Source:  source,
+		Target:  target,
+		Allowed: denial == nil,
+		Denial:  denial,
+	})
+}
+
+// Roles returns the role ARNs recorded in the checkpoint in the order they were discovered.
+func (c *Checkpoint) Roles() []string {
+	if c == nil {
+		return nil
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	roles := make([]string, len(c.roleOrder))
+	copy(roles, c.roleOrder)
+	return roles
+}
+
+// Tested returns the entry for the (source, target) pair if it was tested previously.
+func (c *Checkpoint) Tested(source, target string) (Entry, bool) {
+	if c == nil {
+		return Entry{}, false
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	entry, ok := c.tested[pairKey(source, target)]
+	return entry, ok
+}
+
+// Close closes the underlying checkpoint file.
+func (c *Checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.file.Close()
+}
+
+func pairKey(source, target string) string {
+	return source + " " + target
+}
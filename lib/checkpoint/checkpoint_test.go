@@ -0,0 +1,104 @@
+package checkpoint
+
+import (
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint_Resume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+
+	c, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	denial := &graph.Denial{Code: "AccessDenied", Reason: "AccessDenied", Time: time.Unix(0, 0).UTC()}
+	for _, err := range []error{
+		c.AddRole("arn:aws:iam::123456789012:role/a"),
+		c.AddRole("arn:aws:iam::123456789012:role/b"),
+		c.AddRole("arn:aws:iam::123456789012:role/a"),
+		c.AddTested("arn:aws:iam::123456789012:user/source", "arn:aws:iam::123456789012:role/a", nil),
+		c.AddTested("arn:aws:iam::123456789012:user/source", "arn:aws:iam::123456789012:role/b", denial),
+		c.Close(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Simulate a partial write from being killed mid-record.
+	f := openAppend(t, path)
+	if _, err := f.WriteString(`{"Kind":"role","Arn":"arn:aws:iam::1234`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	resumed, err := Open(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+
+	want := []string{"arn:aws:iam::123456789012:role/a", "arn:aws:iam::123456789012:role/b"}
+	if diff := cmp.Diff(resumed.Roles(), want); diff != "" {
+		t.Errorf("Roles() (-got +want):\n%s", diff)
+	}
+
+	entry, ok := resumed.Tested("arn:aws:iam::123456789012:user/source", "arn:aws:iam::123456789012:role/a")
+	if !ok || !entry.Allowed {
+		t.Errorf("Tested(role/a): got %+v, %t, want allowed", entry, ok)
+	}
+
+	entry, ok = resumed.Tested("arn:aws:iam::123456789012:user/source", "arn:aws:iam::123456789012:role/b")
+	if !ok || entry.Allowed {
+		t.Errorf("Tested(role/b): got %+v, %t, want denied", entry, ok)
+	}
+	if diff := cmp.Diff(entry.Denial, denial); diff != "" {
+		t.Errorf("Tested(role/b).Denial (-got +want):\n%s", diff)
+	}
+
+	if _, ok := resumed.Tested("arn:aws:iam::123456789012:role/a", "arn:aws:iam::123456789012:role/b"); ok {
+		t.Error("Tested() returned true for an untested pair")
+	}
+
+	// Entries written after resuming should not be corrupted by the partial write.
+	if err := resumed.AddRole("arn:aws:iam::123456789012:role/c"); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Open(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+
+	want = append(want, "arn:aws:iam::123456789012:role/c")
+	if diff := cmp.Diff(again.Roles(), want); diff != "" {
+		t.Errorf("Roles() after second resume (-got +want):\n%s", diff)
+	}
+}
+
+func TestCheckpoint_Nil(t *testing.T) {
+	var c *Checkpoint
+	if err := c.AddRole("arn:aws:iam::123456789012:role/a"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := c.Tested("a", "b"); ok {
+		t.Error("Tested() on nil checkpoint returned true")
+	}
+	if roles := c.Roles(); roles != nil {
+		t.Errorf("Roles() on nil checkpoint: got %v, want nil", roles)
+	}
+}
+
+func openAppend(t *testing.T, path string) *os.File {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
@@ -3,8 +3,11 @@ package creds
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/ratelimit"
+	"github.com/RyanJarv/liquidswards/lib/secret"
 	"github.com/RyanJarv/liquidswards/lib/utils"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -12,18 +15,39 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
 	"strings"
 	"time"
 )
 
+// State is the health of a config's credentials, see Health.
 type State int
 
 const (
+	// ActiveState means the credentials were retrieved successfully or haven't been tried yet.
 	ActiveState State = iota
+
+	// RefreshingState means the node the credentials came from failed. The current credentials may still work, but
+	// they'll need to be assumed from another inbound node when refreshed.
 	RefreshingState
+
+	// FailedState means the credentials couldn't be retrieved from any inbound node.
 	FailedState
 )
 
+func (s State) String() string {
+	switch s {
+	case ActiveState:
+		return "active"
+	case RefreshingState:
+		return "refreshing"
+	case FailedState:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
 type IConfig interface {
 	MarshalJSON() ([]byte, error)
 	UnmarshalJSON([]byte) error
@@ -32,7 +56,7 @@ type IConfig interface {
 	Name() string
 	Account() string
 	Config() aws.Config
-	Assume(ctx utils.Context, arn string) (*Config, error)
+	Assume(ctx utils.Context, arn string, externalIds []string) (*Config, error)
 	Refresh(utils.Context) (*Config, error)
 	SetGraph(graph interface{})
 }
@@ -42,6 +66,9 @@ type SourceType int
 const (
 	SourceProfile SourceType = iota
 	SourceAssumeRole
+
+	// SourceUser is an IAM user that was discovered but that we don't have credentials for.
+	SourceUser
 )
 
 type Identity struct {
@@ -101,14 +128,31 @@ func (i Identity) IsRecursive() bool {
 	return utils.SliceRepeats(i.IdentityPath())
 }
 
+// EndpointResolver is used by every aws.Config created in this package when it is set. This allows pointing the SDK
+// somewhere other than AWS, for example the simulator in lib/sim.
+var EndpointResolver aws.EndpointResolverWithOptions
+
+// Keyring encrypts the credentials when the graph is saved and decrypts them when it is loaded. When nil credentials
+// are saved in plaintext.
+var Keyring *secret.Keyring
+
+// Redact drops the credentials when the graph is saved, only the topology is kept.
+var Redact bool
+
 func NewConfig(ctx utils.Context, region string, src Identity) (*Config, error) {
-	awsCfg := aws.Config{Region: region}
+	awsCfg := aws.Config{Region: region, EndpointResolverWithOptions: EndpointResolver}
+
+	// Requests made with the config, including the ones from service clients created in plugins, are paced and
+	// retried per account.
+	account, _ := utils.AccountIdFromArn(src.Arn)
+	ratelimit.Default.Apply(&awsCfg, account)
 
 	return &Config{
 		Identity: src,
 		Config:   awsCfg,
 		ctx:      ctx,
 		Sts:      sts.NewFromConfig(awsCfg),
+		health:   &health{},
 	}, nil
 }
 
@@ -120,8 +164,12 @@ func (c *Config) SetGraph(g interface{}) {
 	//c.cfg.Credentials = c.CredProvider(c.InitialCreds)
 }
 
+// SetProvider sets the credential provider for this config, the STS client is recreated so it uses it as well.
 func (c *Config) SetProvider(p *aws.CredentialsCache) {
 	c.Credentials = p
+	c.sealed = ""
+	c.redacted = false
+	c.Sts = sts.NewFromConfig(c.Config)
 }
 
 type Config struct {
@@ -130,14 +178,43 @@ type Config struct {
 	ctx   utils.Context
 	Sts   stscreds.AssumeRoleAPIClient
 	graph *graph.Graph[*Config]
+
+	// sealed and redacted are set when the credentials couldn't be loaded, so they are saved again as they were.
+	sealed   string
+	redacted bool
+
+	health *health
 }
 
-func (c *Config) Assume(ctx utils.Context, arn string) (*Config, error) {
-	_, err := c.Sts.AssumeRole(ctx.Context, &sts.AssumeRoleInput{
-		RoleArn:         aws.String(arn),
-		RoleSessionName: aws.String("liquidswards"),
-	})
+// Assume attempts to assume arn from this config. If externalIds is not empty each one is tried in turn and the one
+// that worked is saved on the edge so refreshes through GraphProvider can use it. Failed attempts are recorded in the
+// graph as a denial if the error is definitive, see Definitive.
+func (c *Config) Assume(ctx utils.Context, arn string, externalIds []string) (*Config, error) {
+	candidates := []*string{nil}
+	if len(externalIds) != 0 {
+		candidates = nil
+		for _, id := range externalIds {
+			candidates = append(candidates, aws.String(id))
+		}
+	}
+
+	var in *sts.AssumeRoleInput
+	var err error
+	for _, externalId := range candidates {
+		in = &sts.AssumeRoleInput{
+			RoleArn:         aws.String(arn),
+			RoleSessionName: aws.String("liquidswards"),
+			ExternalId:      externalId,
+		}
+		if _, err = c.Sts.AssumeRole(ctx.Context, in); err == nil {
+			break
+		}
+	}
 	if err != nil {
+		// Errors caused by the scan being cancelled don't tell us anything about the edge.
+		if denial := NewDenial(err, in); ctx.Err() == nil && Definitive(denial) {
+			c.graph.AddDenial(c, arn, denial)
+		}
 		return nil, fmt.Errorf("Assume(): %w", err)
 	}
 
@@ -152,12 +229,34 @@ func (c *Config) Assume(ctx utils.Context, arn string) (*Config, error) {
 	}
 
 	c.graph.AddEdge(c, newCfg)
+
+	edge, _ := c.graph.GetEdge(c.Id(), newCfg.Id())
+	edge.ExternalId = in.ExternalId
+	if edge.DiscoveredAt == nil {
+		edge.DiscoveredAt = aws.Time(time.Now())
+	}
+	c.graph.SetEdge(c.Id(), newCfg.Id(), edge)
+
 	newCfg.SetProvider(NewGraphProvider(ctx, c.graph, arn))
 	newCfg.SetGraph(c.graph)
 
 	return newCfg, err
 }
 
+// NewUserConfig returns the config for an IAM user that was discovered, we don't have credentials for it so they
+// can't be retrieved.
+func NewUserConfig(ctx utils.Context, region, arn string, g *graph.Graph[*Config]) (*Config, error) {
+	cfg, err := NewConfig(ctx, region, Identity{Type: SourceUser, Name: arn, Arn: arn})
+	if err != nil {
+		return nil, fmt.Errorf("NewUserConfig(): %w", err)
+	}
+	cfg.SetProvider(aws.NewCredentialsCache(UnavailableProvider{Reason: userUnavailable}))
+	cfg.SetGraph(g)
+	return cfg, nil
+}
+
+const userUnavailable = "no access key is known for the user"
+
 // Name returns the role/user name without the path.
 func (c *Config) Name() string {
 	p := strings.Split(c.Arn(), "/")
@@ -178,7 +277,13 @@ func (c *Config) Refresh(ctx utils.Context) (aws.Credentials, error) {
 		ctx.Info.Printf("got provider type: %T", p)
 	}
 
-	return p.Retrieve(ctx.Context)
+	creds, err := p.Retrieve(ctx.Context)
+	if err != nil {
+		c.SetState(FailedState)
+		return creds, err
+	}
+	c.refreshed(creds, "")
+	return creds, nil
 }
 
 type JsonConfig struct {
@@ -187,25 +292,63 @@ type JsonConfig struct {
 	Region      string
 	Credentials aws.Credentials
 	Identity    Identity
+
+	// RefreshedAt, Expires and Via are saved from Health along with State.
+	RefreshedAt *time.Time `json:",omitempty"`
+	Expires     *time.Time `json:",omitempty"`
+	Via         string     `json:",omitempty"`
+
+	// SealedCredentials holds the credentials encrypted with Keyring, Credentials is empty when this is set.
+	SealedCredentials string `json:",omitempty"`
+
+	// Redacted is true if the credentials were dropped when saving.
+	Redacted bool `json:",omitempty"`
 }
 
 func (c *Config) MarshalJSON() ([]byte, error) {
-	var creds aws.Credentials
-	var err error
+	obj := JsonConfig{
+		Arn:      c.Arn(),
+		Region:   c.Region,
+		Identity: c.Identity,
+	}
+
+	switch {
+	case c.Identity.Type == SourceUser:
+		// There are no credentials to save.
+	case Redact || c.redacted:
+		obj.Redacted = true
+	case c.sealed != "":
+		obj.SealedCredentials = c.sealed
+	case c.Credentials != nil:
+		creds, err := c.Credentials.Retrieve(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("Save(): %w", err)
+		}
+
+		if Keyring == nil {
+			obj.Credentials = creds
+			break
+		}
 
-	if c.Credentials != nil {
-		creds, err = c.Credentials.Retrieve(context.Background())
+		b, err := json.Marshal(creds)
 		if err != nil {
 			return nil, fmt.Errorf("Save(): %w", err)
 		}
+		if obj.SealedCredentials, err = Keyring.Seal(b); err != nil {
+			return nil, fmt.Errorf("Save(): %w", err)
+		}
 	}
 
-	obj := JsonConfig{
-		Arn:         c.Arn(),
-		Region:      c.Region,
-		Credentials: creds,
-		Identity:    c.Identity,
+	// Retrieving the credentials above may have refreshed them, so the health is read afterwards.
+	h := c.Health()
+	obj.State, obj.Via = h.State, h.Via
+	if !h.RefreshedAt.IsZero() {
+		obj.RefreshedAt = aws.Time(h.RefreshedAt)
+	}
+	if !h.Expires.IsZero() {
+		obj.Expires = aws.Time(h.Expires)
 	}
+
 	r, err := json.Marshal(obj)
 	return r, err
 }
@@ -223,17 +366,64 @@ func (c *Config) UnmarshalJSON(bytes []byte) error {
 		return fmt.Errorf("UnmarshalJSON(): %w", err)
 	}
 
-	cfg.Config.Credentials = aws.NewCredentialsCache(credentials.StaticCredentialsProvider{Value: obj.Credentials})
+	switch {
+	case obj.Identity.Type == SourceUser:
+		cfg.SetProvider(aws.NewCredentialsCache(UnavailableProvider{Reason: userUnavailable}))
+	case obj.Redacted:
+		cfg.SetProvider(aws.NewCredentialsCache(UnavailableProvider{Reason: "the credentials were redacted when saved"}))
+		cfg.redacted = true
+	case obj.SealedCredentials != "" && Keyring == nil:
+		cfg.SetProvider(aws.NewCredentialsCache(UnavailableProvider{Reason: "the credentials are encrypted"}))
+		cfg.sealed = obj.SealedCredentials
+	case obj.SealedCredentials != "":
+		b, err := Keyring.Open(obj.SealedCredentials)
+		if errors.Is(err, secret.ErrNoIdentity) {
+			cfg.SetProvider(aws.NewCredentialsCache(UnavailableProvider{Reason: "no identity to decrypt the credentials"}))
+			cfg.sealed = obj.SealedCredentials
+			break
+		} else if err != nil {
+			return fmt.Errorf("UnmarshalJSON(): decrypting credentials for %s: %w", obj.Arn, err)
+		}
+
+		var creds aws.Credentials
+		if err := json.Unmarshal(b, &creds); err != nil {
+			return fmt.Errorf("UnmarshalJSON(): %w", err)
+		}
+		cfg.SetProvider(aws.NewCredentialsCache(credentials.StaticCredentialsProvider{Value: creds}))
+	default:
+		cfg.SetProvider(aws.NewCredentialsCache(credentials.StaticCredentialsProvider{Value: obj.Credentials}))
+	}
+
+	cfg.health.Health = Health{
+		State:       obj.State,
+		RefreshedAt: aws.ToTime(obj.RefreshedAt),
+		Expires:     aws.ToTime(obj.Expires),
+		Via:         obj.Via,
+	}
 
 	*c = *cfg
 	return nil
 }
 
+// LoadProfile loads the named profile from the shared AWS config.
+func LoadProfile(ctx utils.Context, profile string, region string) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{config.WithRegion(region), config.WithSharedConfigProfile(profile)}
+	if EndpointResolver != nil {
+		opts = append(opts, config.WithEndpointResolverWithOptions(EndpointResolver))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("loading profile %s using region %s: %w", profile, region, err)
+	}
+	return awsCfg, nil
+}
+
 func ParseProfiles(ctx utils.Context, profiles string, region string, g *graph.Graph[*Config]) (configs []*Config, err error) {
 	for _, p := range utils.SplitCommas(profiles) {
-		awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region), config.WithSharedConfigProfile(p))
+		awsCfg, err := LoadProfile(ctx, p, region)
 		if err != nil {
-			return nil, fmt.Errorf("loading profile %s using region %s: %w", p, region, err)
+			return nil, fmt.Errorf("ParseProfiles(): %w", err)
 		}
 
 		arn, err := utils.GetCallerArn(ctx, awsCfg)
@@ -267,11 +457,24 @@ func ParseScope(scopeStr string, cfgs []*Config) []string {
 
 type MockSts struct {
 	Calls []sts.AssumeRoleInput
+
+	// Errors maps role ARNs to the error AssumeRole should return for them.
+	Errors map[string]error
+
+	// ExternalIds maps role ARNs to the external ID they require, AssumeRole is denied when any other value is used.
+	ExternalIds map[string]string
 }
 
 func (s *MockSts) AssumeRole(ctx context.Context, in *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
 	s.Calls = append(s.Calls, *in)
 
+	if err, ok := s.Errors[*in.RoleArn]; ok {
+		return nil, err
+	}
+	if id, ok := s.ExternalIds[*in.RoleArn]; ok && aws.ToString(in.ExternalId) != id {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: sts:AssumeRole"}
+	}
+
 	return &sts.AssumeRoleOutput{
 		AssumedRoleUser: &types.AssumedRoleUser{
 			Arn:           aws.String(fmt.Sprintf("%s-Arn", *in.RoleArn)),
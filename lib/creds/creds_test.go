@@ -4,12 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"filippo.io/age"
+	"fmt"
 	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/secret"
 	"github.com/RyanJarv/liquidswards/lib/utils"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -112,3 +119,264 @@ func TestConfig_Unmarshal(t *testing.T) {
 		t.Errorf("cfg mismatch (-got +want):\n%s", diff)
 	}
 }
+
+func TestClassifyAssumeRoleError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		in         *sts.AssumeRoleInput
+		wantCode   string
+		wantReason string
+
+		// wantDefinitive is whether the edge shouldn't be tested again.
+		wantDefinitive bool
+	}{
+		{
+			name:           "access denied",
+			err:            &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: sts:AssumeRole"},
+			in:             &sts.AssumeRoleInput{},
+			wantCode:       "AccessDenied",
+			wantReason:     ReasonAccessDenied,
+			wantDefinitive: true,
+		},
+		{
+			name:           "access denied with external id",
+			err:            &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: sts:AssumeRole"},
+			in:             &sts.AssumeRoleInput{ExternalId: aws.String("test")},
+			wantCode:       "AccessDenied",
+			wantReason:     ReasonExternalIdMismatch,
+			wantDefinitive: true,
+		},
+		{
+			name:           "mfa required",
+			err:            &smithy.GenericAPIError{Code: "AccessDenied", Message: "MultiFactorAuthentication failed"},
+			wantCode:       "AccessDenied",
+			wantReason:     ReasonMFARequired,
+			wantDefinitive: true,
+		},
+		{
+			name:       "throttled",
+			err:        fmt.Errorf("wrapped: %w", &smithy.GenericAPIError{Code: "Throttling"}),
+			wantCode:   "Throttling",
+			wantReason: ReasonThrottled,
+		},
+		{
+			name:       "region disabled",
+			err:        &smithy.GenericAPIError{Code: "RegionDisabledException"},
+			wantCode:   "RegionDisabledException",
+			wantReason: ReasonRegionDisabled,
+		},
+		{
+			name:       "other api error",
+			err:        &smithy.GenericAPIError{Code: "ValidationError"},
+			wantCode:   "ValidationError",
+			wantReason: ReasonUnknown,
+		},
+		{
+			name:       "not an api error",
+			err:        fmt.Errorf("test"),
+			wantReason: ReasonUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason := ClassifyAssumeRoleError(tt.err, tt.in)
+			if code != tt.wantCode {
+				t.Errorf("code: got %s, want %s", code, tt.wantCode)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("reason: got %s, want %s", reason, tt.wantReason)
+			}
+			if got := Definitive(NewDenial(tt.err, tt.in)); got != tt.wantDefinitive {
+				t.Errorf("definitive: got %v, want %v", got, tt.wantDefinitive)
+			}
+		})
+	}
+}
+
+// TestConfig_AssumeDenied ensures failed AssumeRole calls are recorded as denied edges in the graph.
+func TestConfig_AssumeDenied(t *testing.T) {
+	target := "arn:aws:iam::123456789012:role/target"
+
+	g := graph.NewDirectedGraph[*Config]()
+	source, client := utils.Must2(NewTestAssumesAllConfig(SourceProfile, "user/source", g))
+	client.Errors = map[string]error{
+		target: &smithy.GenericAPIError{Code: "AccessDenied"},
+	}
+	g.AddNode(source)
+
+	if status := g.EdgeStatus(source.Id(), target); status != graph.EdgeUntested {
+		t.Errorf("EdgeStatus() before Assume: got %d, want %d", status, graph.EdgeUntested)
+	}
+
+	if _, err := source.Assume(ctx, target, nil); err == nil {
+		t.Fatal("expected Assume() to return an error")
+	}
+
+	if status := g.EdgeStatus(source.Id(), target); status != graph.EdgeDenied {
+		t.Errorf("EdgeStatus() after Assume: got %d, want %d", status, graph.EdgeDenied)
+	}
+
+	node, _ := g.GetNode(source.Id())
+	denial, ok := node.Denied()[target]
+	if !ok {
+		t.Fatalf("no denial found for %s", target)
+	}
+	if denial.Code != "AccessDenied" || denial.Reason != ReasonAccessDenied || denial.Time.IsZero() {
+		t.Errorf("unexpected denial: %+v", denial)
+	}
+}
+
+// TestConfig_AssumeThrottled ensures errors that don't tell us anything about the edge aren't recorded as denials.
+func TestConfig_AssumeThrottled(t *testing.T) {
+	throttled := "arn:aws:iam::123456789012:role/throttled"
+	network := "arn:aws:iam::123456789012:role/network"
+
+	g := graph.NewDirectedGraph[*Config]()
+	source, client := utils.Must2(NewTestAssumesAllConfig(SourceProfile, "user/source", g))
+	client.Errors = map[string]error{
+		throttled: &smithy.GenericAPIError{Code: "Throttling"},
+		network:   fmt.Errorf("connection reset"),
+	}
+	g.AddNode(source)
+
+	for _, target := range []string{throttled, network} {
+		if _, err := source.Assume(ctx, target, nil); err == nil {
+			t.Fatalf("expected Assume() of %s to return an error", target)
+		}
+		if status := g.EdgeStatus(source.Id(), target); status != graph.EdgeUntested {
+			t.Errorf("EdgeStatus() of %s: got %d, want %d", target, status, graph.EdgeUntested)
+		}
+	}
+}
+
+// TestConfig_AssumeExternalId ensures each candidate external ID is tried and the one that worked is saved on the edge.
+func TestConfig_AssumeExternalId(t *testing.T) {
+	target := "arn:aws:iam::123456789012:role/vendor"
+
+	g := graph.NewDirectedGraph[*Config]()
+	source, client := utils.Must2(NewTestAssumesAllConfig(SourceProfile, "user/source", g))
+	client.ExternalIds = map[string]string{target: "right"}
+	g.AddNode(source)
+
+	if _, err := source.Assume(ctx, target, []string{"wrong"}); err == nil {
+		t.Fatal("expected Assume() with the wrong external id to return an error")
+	}
+	if denial, _ := g.GetDenial(source.Id(), target); denial.Reason != ReasonExternalIdMismatch {
+		t.Errorf("denial reason: got %s, want %s", denial.Reason, ReasonExternalIdMismatch)
+	}
+
+	newCfg, err := source.Assume(ctx, target, []string{"wrong", "right"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tried []string
+	for _, call := range client.Calls {
+		tried = append(tried, aws.ToString(call.ExternalId))
+	}
+	if diff := cmp.Diff(tried, []string{"wrong", "wrong", "right"}); diff != "" {
+		t.Errorf("external ids tried (-got +want):\n%s", diff)
+	}
+
+	edge, ok := g.GetEdge(source.Id(), newCfg.Id())
+	if !ok || aws.ToString(edge.ExternalId) != "right" {
+		t.Errorf("GetEdge(): got %+v, want ExternalId right", edge)
+	}
+	if status := g.EdgeStatus(source.Id(), newCfg.Id()); status != graph.EdgeAllowed {
+		t.Errorf("EdgeStatus(): got %d, want %d", status, graph.EdgeAllowed)
+	}
+}
+
+func TestConfig_MarshalEncrypted(t *testing.T) {
+	id := utils.Must(age.GenerateX25519Identity())
+	dir := t.TempDir()
+	recipients, identity := filepath.Join(dir, "recipients.txt"), filepath.Join(dir, "key.txt")
+	utils.Must0(os.WriteFile(recipients, []byte(id.Recipient().String()), 0600))
+	utils.Must0(os.WriteFile(identity, []byte(id.String()), 0600))
+
+	cfg := utils.Must(NewConfig(ctx, "us-east-1", Identity{Type: SourceProfile, Name: "test", Arn: "arn:aws:iam::123456789012:user/test"}))
+	want := aws.Credentials{AccessKeyID: "AKIATEST", SecretAccessKey: "secret-key", SessionToken: "session-token"}
+	cfg.SetProvider(aws.NewCredentialsCache(credentials.StaticCredentialsProvider{Value: want}))
+
+	t.Cleanup(func() { Keyring = nil; Redact = false })
+	Keyring = utils.Must(secret.NewAgeKeyring(recipients, ""))
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{want.AccessKeyID, want.SecretAccessKey, want.SessionToken} {
+		if bytes.Contains(b, []byte(s)) {
+			t.Errorf("saved config contains %s in plaintext: %s", s, b)
+		}
+	}
+
+	// Without the identity the config still loads, but the credentials can't be used. Saving it again keeps them.
+	Keyring = nil
+	var locked Config
+	if err := json.Unmarshal(b, &locked); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locked.Credentials.Retrieve(ctx); err == nil {
+		t.Error("expected an error retrieving credentials that couldn't be decrypted")
+	}
+	resaved, err := json.Marshal(&locked)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Keyring = utils.Must(secret.NewAgeKeyring("", identity))
+	var loaded Config
+	if err := json.Unmarshal(resaved, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loaded.Credentials.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want, cmpopts.IgnoreFields(aws.Credentials{}, "Source")); diff != "" {
+		t.Errorf("decrypted credentials (-got +want):\n%s", diff)
+	}
+
+	Keyring = nil
+	Redact = true
+	b, err = json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(want.SecretAccessKey)) {
+		t.Errorf("redacted config contains the secret key: %s", b)
+	}
+	var redacted Config
+	if err := json.Unmarshal(b, &redacted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := redacted.Credentials.Retrieve(ctx); err == nil {
+		t.Error("expected an error retrieving redacted credentials")
+	}
+}
+
+func TestNewUserConfig(t *testing.T) {
+	g := graph.NewDirectedGraph[*Config]()
+	cfg := utils.Must(NewUserConfig(ctx, "us-east-1", "arn:aws:iam::123456789012:user/path/alice", g))
+	if cfg.Id() != "arn:aws:iam::123456789012:user/path/alice" || cfg.Name() != "alice" {
+		t.Errorf("unexpected user config: %s, %s", cfg.Id(), cfg.Name())
+	}
+
+	// Saving doesn't need the credentials, and the loaded config still can't retrieve any.
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded Config
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Identity.Type != SourceUser {
+		t.Errorf("expected SourceUser, got %d", loaded.Identity.Type)
+	}
+	if _, err := loaded.Credentials.Retrieve(ctx); err == nil {
+		t.Error("expected an error retrieving credentials for a user")
+	}
+}
@@ -0,0 +1,68 @@
+package creds
+
+import (
+	"errors"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"strings"
+	"time"
+)
+
+// Classifications of failed sts:AssumeRole calls stored in graph.Denial.Reason.
+const (
+	ReasonAccessDenied       = "AccessDenied"
+	ReasonExternalIdMismatch = "ExternalIdMismatch"
+	ReasonMFARequired        = "MFARequired"
+	ReasonThrottled          = "Throttled"
+	ReasonRegionDisabled     = "RegionDisabled"
+	ReasonUnknown            = "Unknown"
+)
+
+// ClassifyAssumeRoleError returns the AWS error code of err along with our classification of it. The input is used
+// to tell the difference between a generic AccessDenied and one that was likely caused by the external ID.
+func ClassifyAssumeRoleError(err error, in *sts.AssumeRoleInput) (code string, reason string) {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return "", ReasonUnknown
+	}
+
+	code = apiErr.ErrorCode()
+	switch code {
+	case "AccessDenied", "AccessDeniedException":
+		if strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "multifactorauth") {
+			return code, ReasonMFARequired
+		} else if in != nil && in.ExternalId != nil {
+			return code, ReasonExternalIdMismatch
+		}
+		return code, ReasonAccessDenied
+	case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+		return code, ReasonThrottled
+	case "RegionDisabledException":
+		return code, ReasonRegionDisabled
+	default:
+		return code, ReasonUnknown
+	}
+}
+
+// Definitive returns true if the denial means the edge isn't allowed. Other failures, like throttling that continued
+// after retrying, disabled regions and network errors, don't tell us anything about the edge so they are tested again
+// on the next scan.
+func Definitive(d graph.Denial) bool {
+	switch d.Reason {
+	case ReasonAccessDenied, ReasonExternalIdMismatch, ReasonMFARequired:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewDenial returns a graph.Denial describing the failed sts:AssumeRole call.
+func NewDenial(err error, in *sts.AssumeRoleInput) graph.Denial {
+	code, reason := ClassifyAssumeRoleError(err, in)
+	return graph.Denial{
+		Code:   code,
+		Reason: reason,
+		Time:   time.Now().UTC(),
+	}
+}
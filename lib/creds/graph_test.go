@@ -289,7 +289,7 @@ func NewFilledGraph(t *testing.T) (*graph.Graph[*Config], error) {
 	g := graph.NewDirectedGraph[*Config]()
 	source, _ := utils.Must2(NewTestAssumesAllConfig(SourceAssumeRole, "user/test", g))
 
-	target, err := source.Assume(ctx, "arn:aws:iam::123456789012:role/test")
+	target, err := source.Assume(ctx, "arn:aws:iam::123456789012:role/test", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -313,7 +313,7 @@ func TestGraph_SaveCyclic(t *testing.T) {
 	if g.AddNode(source) == nil {
 		t.Error("g.AddNode returned false, expected true")
 	}
-	target, err := source.Assume(ctx, "arn:aws:iam::123456789012:test/test")
+	target, err := source.Assume(ctx, "arn:aws:iam::123456789012:test/test", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,100 @@
+package creds
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"sync"
+	"time"
+)
+
+// Health is the state of a config's credentials along with where and when they were last retrieved.
+type Health struct {
+	State State
+
+	// RefreshedAt is when the credentials were last retrieved and Expires is when they expire, these are zero when
+	// not known.
+	RefreshedAt time.Time
+	Expires     time.Time
+
+	// Via is the ID of the inbound node the current credentials were assumed from, this is empty for profiles.
+	Via string
+}
+
+// health is kept behind a pointer so it is shared by copies of the config.
+type health struct {
+	m sync.Mutex
+	Health
+}
+
+// Health returns a copy of the health of the config, this is the zero value for configs not created with NewConfig.
+func (c *Config) Health() Health {
+	if c.health == nil {
+		return Health{}
+	}
+	c.health.m.Lock()
+	defer c.health.m.Unlock()
+	return c.health.Health
+}
+
+// State returns whether the credentials of the config are working, see SetState.
+func (c *Config) State() State {
+	return c.Health().State
+}
+
+// SetState records whether the credentials of the config are working. When s is RefreshingState or FailedState the
+// nodes that got their credentials through this one are marked as RefreshingState, see Health.Via.
+func (c *Config) SetState(s State) {
+	c.health.m.Lock()
+	changed := c.health.State != s
+	c.health.State = s
+	c.health.m.Unlock()
+
+	if changed && s != ActiveState {
+		c.propagate()
+	}
+}
+
+// refreshed records that creds were retrieved, via is the ID of the inbound node they were assumed from.
+func (c *Config) refreshed(creds aws.Credentials, via string) {
+	c.health.m.Lock()
+	defer c.health.m.Unlock()
+
+	c.health.State = ActiveState
+	c.health.RefreshedAt = time.Now()
+	c.health.Expires = time.Time{}
+	if creds.CanExpire {
+		c.health.Expires = creds.Expires
+	}
+	if via != "" {
+		c.health.Via = via
+	}
+}
+
+// propagate marks the active outbound nodes that got their credentials through c as RefreshingState, which in turn
+// marks the nodes downstream of them.
+func (c *Config) propagate() {
+	if c.graph == nil {
+		return
+	}
+	node, ok := c.graph.GetNode(c.Id())
+	if !ok {
+		return
+	}
+
+	for _, dst := range node.Outbound() {
+		cfg := dst.Value()
+		if h := cfg.Health(); h.Via == c.Id() && h.State == ActiveState {
+			cfg.SetState(RefreshingState)
+		}
+	}
+}
+
+// Parent returns the ID of the node the credentials were last assumed from, or the one they were first assumed from if
+// they haven't been refreshed yet. This is empty for profiles.
+func (c *Config) Parent() string {
+	if via := c.Health().Via; via != "" {
+		return via
+	} else if c.Source != nil {
+		return c.Source.Id()
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package creds
+
+import (
+	"encoding/json"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+// newFailoverGraph returns a graph where target can be assumed from a and b, which are both assumed from profile.
+func newFailoverGraph() (g *graph.Graph[*Config], profile, a, b, target *Config) {
+	g = graph.NewDirectedGraph[*Config]()
+	profile, _ = utils.Must2(NewTestAssumesAllConfig(SourceProfile, "user/profile", g))
+	a, _ = utils.Must2(NewTestAssumesAllConfig(SourceAssumeRole, "role/a", g))
+	b, _ = utils.Must2(NewTestAssumesAllConfig(SourceAssumeRole, "role/b", g))
+	target, _ = utils.Must2(NewTestAssumesAllConfig(SourceAssumeRole, "role/target", g))
+
+	g.AddEdge(profile, a)
+	g.AddEdge(profile, b)
+	g.AddEdge(a, target)
+	g.AddEdge(b, target)
+	return g, profile, a, b, target
+}
+
+// TestGraphProvider_Failover ensures the target is assumed from the next inbound node when the first one fails.
+func TestGraphProvider_Failover(t *testing.T) {
+	_, _, a, b, target := newFailoverGraph()
+
+	if _, err := target.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if h := target.Health(); h.Via != a.Id() || h.State != ActiveState || h.RefreshedAt.IsZero() {
+		t.Errorf("got %+v, want active via %s", h, a.Id())
+	}
+
+	// The target got its credentials through a, so it needs to fail over when a fails.
+	a.SetState(FailedState)
+	if got := target.State(); got != RefreshingState {
+		t.Errorf("got %s, want %s", got, RefreshingState)
+	}
+
+	if _, err := target.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if h := target.Health(); h.Via != b.Id() || h.State != ActiveState {
+		t.Errorf("got %+v, want active via %s", h, b.Id())
+	}
+}
+
+// TestGraphProvider_AllFailed ensures the target and the nodes that got their credentials through it are marked when
+// it can't be assumed from any inbound node.
+func TestGraphProvider_AllFailed(t *testing.T) {
+	g, _, a, b, target := newFailoverGraph()
+	child, _ := utils.Must2(NewTestAssumesAllConfig(SourceAssumeRole, "role/child", g))
+	g.AddEdge(target, child)
+
+	if _, err := child.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	denied := &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: sts:AssumeRole"}
+	for _, cfg := range []*Config{a, b} {
+		cfg.Sts = &MockSts{Errors: map[string]error{target.Id(): denied}}
+	}
+
+	if _, err := target.Refresh(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := target.State(); got != FailedState {
+		t.Errorf("target: got %s, want %s", got, FailedState)
+	}
+	if got := child.State(); got != RefreshingState {
+		t.Errorf("child: got %s, want %s", got, RefreshingState)
+	}
+}
+
+// TestConfig_MarshalHealth ensures the health of a config is kept when it is saved and loaded.
+func TestConfig_MarshalHealth(t *testing.T) {
+	_, _, a, b, target := newFailoverGraph()
+	a.SetState(FailedState)
+
+	data, err := json.Marshal(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &Config{ctx: ctx}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+
+	// Marshalling retrieves the credentials, which refreshes the target through b.
+	want := target.Health()
+	want.RefreshedAt = want.RefreshedAt.Round(0)
+	if want.Via != b.Id() {
+		t.Errorf("got via %s, want %s", want.Via, b.Id())
+	}
+
+	if diff := cmp.Diff(got.Health(), want); diff != "" {
+		t.Errorf("Health() mismatch (-got +want):\n%s", diff)
+	}
+}
+
+// TestSources ensures inbound nodes are ordered by state, then distance from a profile and then ID.
+func TestSources(t *testing.T) {
+	g, profile, a, b, target := newFailoverGraph()
+
+	// far is inbound to target but two edges away from a profile, it sorts before a and b by ID.
+	far, _ := utils.Must2(NewTestAssumesAllConfig(SourceAssumeRole, "role/0-far", g))
+	g.AddEdge(a, far)
+	g.AddEdge(far, target)
+
+	node, _ := g.GetNode(target.Id())
+	if diff := cmp.Diff(ids(Sources(node)), []string{a.Id(), b.Id(), far.Id()}); diff != "" {
+		t.Errorf("Sources() mismatch (-got +want):\n%s", diff)
+	}
+
+	a.SetState(FailedState)
+	if diff := cmp.Diff(ids(Sources(node)), []string{b.Id(), far.Id(), a.Id()}); diff != "" {
+		t.Errorf("Sources() mismatch (-got +want):\n%s", diff)
+	}
+
+	if got := profileDistance(node); got != 2 {
+		t.Errorf("got distance %d, want 2", got)
+	}
+	if p, _ := g.GetNode(profile.Id()); profileDistance(p) != 0 {
+		t.Errorf("got distance %d for the profile, want 0", profileDistance(p))
+	}
+}
+
+func ids(nodes []graph.Node[*Config]) (resp []string) {
+	for _, n := range nodes {
+		resp = append(resp, n.Value().Id())
+	}
+	return resp
+}
@@ -0,0 +1,120 @@
+package creds
+
+import (
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"sort"
+	"time"
+)
+
+// ExpiryWindow is how long before they expire saved credentials are treated as expired, so the caller doesn't get
+// credentials that stop working right away.
+const ExpiryWindow = 5 * time.Minute
+
+// ProcessCredentials is the JSON format expected from a credential_process command, see
+// https://docs.aws.amazon.com/cli/latest/userguide/cli-configure-sourcing-external.html
+type ProcessCredentials struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string     `json:",omitempty"`
+	Expiration      *time.Time `json:",omitempty"`
+}
+
+func NewProcessCredentials(creds aws.Credentials) ProcessCredentials {
+	resp := ProcessCredentials{
+		Version:         1,
+		AccessKeyId:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}
+	if creds.CanExpire {
+		resp.Expiration = aws.Time(creds.Expires.UTC())
+	}
+	return resp
+}
+
+// Fresh returns the credentials of arn from the graph. If the saved credentials are expired, or not available because
+// they were redacted, they are refreshed with GraphProvider. Principals on the shortest path from a profile are
+// refreshed first in order so each sts:AssumeRole call has a working source. Profiles are loaded from the shared AWS
+// config again.
+func Fresh(ctx utils.Context, g *graph.Graph[*Config], arn string) (aws.Credentials, error) {
+	node, ok := g.GetNode(arn)
+	if !ok {
+		return aws.Credentials{}, fmt.Errorf("Fresh(): %s not found in graph", arn)
+	}
+	if creds, ok := valid(ctx, node.Value()); ok {
+		return creds, nil
+	}
+
+	path, err := profilePath(g, arn)
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("Fresh(): %w", err)
+	}
+
+	var creds aws.Credentials
+	for _, id := range path {
+		n, _ := g.GetNode(id)
+		cfg := n.Value()
+
+		var ok bool
+		if creds, ok = valid(ctx, cfg); ok {
+			continue
+		}
+
+		if cfg.Identity.Type == SourceProfile {
+			awsCfg, err := LoadProfile(ctx, cfg.Identity.Name, cfg.Region)
+			if err != nil {
+				return creds, fmt.Errorf("Fresh(): %w", err)
+			}
+			cache, ok := awsCfg.Credentials.(*aws.CredentialsCache)
+			if !ok {
+				return creds, fmt.Errorf("Fresh(): profile %s has no credentials", cfg.Identity.Name)
+			}
+			cfg.SetProvider(cache)
+		} else {
+			cfg.SetProvider(NewGraphProvider(ctx, g, id))
+		}
+
+		if creds, err = cfg.Credentials.Retrieve(ctx); err != nil {
+			return creds, fmt.Errorf("Fresh(): refreshing %s: %w", id, err)
+		}
+	}
+	return creds, nil
+}
+
+// valid returns the current credentials of cfg and whether they can still be used.
+func valid(ctx utils.Context, cfg *Config) (aws.Credentials, bool) {
+	if cfg.Credentials == nil {
+		return aws.Credentials{}, false
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil || !creds.HasKeys() {
+		return creds, false
+	}
+	return creds, !creds.CanExpire || time.Until(creds.Expires) > ExpiryWindow
+}
+
+// profilePath returns the shortest path to arn from any of the profiles in the graph.
+func profilePath(g *graph.Graph[*Config], arn string) ([]string, error) {
+	var profiles []string
+	for id, n := range g.Nodes() {
+		if n.Value().Identity.Type == SourceProfile {
+			profiles = append(profiles, id)
+		}
+	}
+	sort.Strings(profiles)
+
+	var shortest []string
+	for _, profile := range profiles {
+		if path, ok := g.ShortestPath(profile, arn); ok && (shortest == nil || len(path) < len(shortest)) {
+			shortest = path
+		}
+	}
+	if shortest == nil {
+		return nil, fmt.Errorf("no path to %s from a profile", arn)
+	}
+	return shortest, nil
+}
@@ -106,6 +106,8 @@ import (
 	"github.com/RyanJarv/liquidswards/lib/utils"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"math"
+	"sort"
 )
 
 type GraphProvider struct {
@@ -123,23 +125,90 @@ func NewGraphProvider(ctx utils.Context, graph *graph.Graph[*Config], arn string
 	})
 }
 
+// Retrieve assumes the role from the first inbound node that succeeds, see Sources for the order they're tried in. The
+// health of the role's config is updated with the result.
 func (p *GraphProvider) Retrieve(ctx context.Context) (creds aws.Credentials, err error) {
 	node, ok := p.Graph.GetNode(p.Arn)
 	if !ok {
 		return creds, fmt.Errorf("unable to find node for %s", p.Arn)
 	}
 
-	for _, src := range node.Inbound() {
+	err = fmt.Errorf("no inbound nodes to assume %s from", p.Arn)
+	for _, src := range Sources(node) {
+		edge, _ := p.Graph.GetEdge(src.Value().Id(), p.Arn)
+
 		provider := stscreds.NewAssumeRoleProvider(src.Value().Sts, p.Arn, func(o *stscreds.AssumeRoleOptions) {
 			o.RoleSessionName = "liquidswards"
+			o.ExternalID = edge.ExternalId
 		})
 		if creds, err = provider.Retrieve(ctx); err != nil {
-			p.Info.Printf("failed to assume role %s: %s", p.Arn, err)
+			p.Info.Printf("failed to assume role %s from %s: %s", p.Arn, src.Value().Id(), err)
 			continue
-		} else {
-			break
 		}
+
+		node.Value().refreshed(creds, src.Value().Id())
+		return creds, nil
 	}
 
+	node.Value().SetState(FailedState)
 	return creds, err
 }
+
+// Sources returns the inbound nodes of node in the order GraphProvider tries them. Nodes are ordered by their State,
+// so healthy ones are tried first, then by the length of the shortest chain back to a profile and then by ID. Users
+// are skipped since we don't have credentials for them.
+func Sources(node graph.Node[*Config]) []graph.Node[*Config] {
+	var srcs []graph.Node[*Config]
+	dist := map[string]int{}
+	for id, src := range node.Inbound() {
+		if src.Value().Type == SourceUser {
+			continue
+		}
+		srcs = append(srcs, src)
+		dist[id] = profileDistance(src)
+	}
+
+	sort.Slice(srcs, func(i, j int) bool {
+		a, b := srcs[i].Value(), srcs[j].Value()
+		if a.State() != b.State() {
+			return a.State() < b.State()
+		} else if dist[a.Id()] != dist[b.Id()] {
+			return dist[a.Id()] < dist[b.Id()]
+		}
+		return a.Id() < b.Id()
+	})
+	return srcs
+}
+
+// profileDistance returns the number of edges on the shortest inbound chain from a profile to node, or math.MaxInt if
+// there isn't one.
+func profileDistance(node graph.Node[*Config]) int {
+	seen := map[string]bool{node.Value().Id(): true}
+	queue := []graph.Node[*Config]{node}
+	for depth := 0; len(queue) != 0; depth++ {
+		var next []graph.Node[*Config]
+		for _, n := range queue {
+			if n.Value().Type == SourceProfile {
+				return depth
+			}
+			for id, src := range n.Inbound() {
+				if !seen[id] {
+					seen[id] = true
+					next = append(next, src)
+				}
+			}
+		}
+		queue = next
+	}
+	return math.MaxInt
+}
+
+// UnavailableProvider is used for credentials that were loaded without their secrets, either because they were
+// redacted or because there is no key to decrypt them.
+type UnavailableProvider struct {
+	Reason string
+}
+
+func (p UnavailableProvider) Retrieve(context.Context) (aws.Credentials, error) {
+	return aws.Credentials{}, fmt.Errorf("credentials are not available: %s", p.Reason)
+}
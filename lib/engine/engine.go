@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// New returns an Engine running jobs on workers goroutines, at most perKey jobs with the same key run at once. A
+// perKey of zero or less means only workers limits the jobs of each key.
+func New(ctx utils.Context, workers, perKey int) *Engine {
+	if perKey <= 0 || perKey > workers {
+		perKey = workers
+	}
+
+	e := &Engine{
+		ctx:     ctx,
+		perKey:  perKey,
+		queues:  map[string][]func(){},
+		running: map[string]int{},
+	}
+	e.cond = sync.NewCond(&e.m)
+
+	for i := 0; i < workers; i++ {
+		go e.work()
+	}
+	return e
+}
+
+// Engine runs jobs on a bounded number of workers. Unlike a plain worker pool, jobs can submit more jobs without
+// blocking, and Wait returns once every job and the jobs they submitted have finished, which is how the scan knows
+// discovery has converged.
+//
+// Jobs are grouped by a key, for sts:AssumeRole tests this is the account of the principal making the call since STS
+// throttles per account. Keys are served round-robin so one large account doesn't hold up the others.
+type Engine struct {
+	ctx    utils.Context
+	perKey int
+
+	m    sync.Mutex
+	cond *sync.Cond
+
+	// queues holds the jobs waiting to run for each key, keys are the keys in queues in the order they're served.
+	queues map[string][]func()
+	keys   []string
+
+	// running is the number of jobs running for each key.
+	running map[string]int
+
+	// pending is the number of jobs that are queued or running.
+	pending int
+	stopped bool
+
+	// panicked is the number of completed jobs that panicked, jobs don't report other failures.
+	completed, panicked int
+}
+
+// Submit queues f to run with the jobs of key. If e is nil f is run before returning.
+func (e *Engine) Submit(key string, f func()) {
+	if e == nil {
+		f()
+		return
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if len(e.queues[key]) == 0 {
+		e.keys = append(e.keys, key)
+	}
+	e.queues[key] = append(e.queues[key], f)
+	e.pending++
+	e.cond.Broadcast()
+}
+
+// Wait blocks until there are no queued or running jobs.
+func (e *Engine) Wait() {
+	if e == nil {
+		return
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+	for e.pending != 0 {
+		e.cond.Wait()
+	}
+}
+
+// Stop exits the workers once they finish their current job, jobs that are still queued are not run.
+func (e *Engine) Stop() {
+	if e == nil {
+		return
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.stopped = true
+	e.cond.Broadcast()
+}
+
+// Stats returns the number of queued, running, completed and panicked jobs. A nil Engine runs jobs as they're
+// submitted, so there is nothing to report.
+func (e *Engine) Stats() (queued, running, completed, panicked int) {
+	if e == nil {
+		return 0, 0, 0, 0
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	for _, n := range e.running {
+		running += n
+	}
+	return e.pending - running, running, e.completed, e.panicked
+}
+
+// Monitor logs the stats of e every five seconds until ctx is done, similar to utils.MonitorPoolStats.
+func (e *Engine) Monitor(ctx utils.Context, msg string) {
+	go func() {
+		for ctx.IsRunning("exiting monitor engine:", msg) {
+			ctx.Sleep(time.Second * 5)
+			queued, running, completed, panicked := e.Stats()
+			ctx.Debug.Println(msg, "wait:", queued, "run:", running, "done:", completed, "panic:", panicked)
+		}
+	}()
+}
+
+func (e *Engine) work() {
+	for {
+		key, f, ok := e.next()
+		if !ok {
+			return
+		}
+
+		panicked := e.run(f)
+
+		e.m.Lock()
+		e.running[key]--
+		e.pending--
+		e.completed++
+		if panicked {
+			e.panicked++
+		}
+		e.cond.Broadcast()
+		e.m.Unlock()
+	}
+}
+
+// next blocks until there is a job for a key that is below its limit and returns it, false is returned once the
+// engine is stopped.
+func (e *Engine) next() (string, func(), bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	for !e.stopped {
+		for i, key := range e.keys {
+			if e.running[key] >= e.perKey {
+				continue
+			}
+
+			f := e.queues[key][0]
+			e.queues[key] = e.queues[key][1:]
+			e.running[key]++
+
+			// Move the key to the back so the other keys get a turn, or drop it if it has no jobs left.
+			e.keys = append(e.keys[:i:i], e.keys[i+1:]...)
+			if len(e.queues[key]) != 0 {
+				e.keys = append(e.keys, key)
+			} else {
+				delete(e.queues, key)
+			}
+			return key, f, true
+		}
+		e.cond.Wait()
+	}
+	return "", nil, false
+}
+
+// run calls f, a panic is logged and reported rather than taking down the worker.
+func (e *Engine) run(f func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.ctx.Error.Println("engine:", r, "\n", string(debug.Stack()))
+			panicked = true
+		}
+	}()
+	f()
+	return false
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var ctx = utils.NewContext(context.Background())
+
+// TestEngine_Wait ensures Wait returns only after jobs submitted by other jobs have finished.
+func TestEngine_Wait(t *testing.T) {
+	e := New(ctx, 4, 0)
+	defer e.Stop()
+
+	var count atomic.Int32
+	var submit func(depth int)
+	submit = func(depth int) {
+		e.Submit("a", func() {
+			count.Add(1)
+			if depth < 3 {
+				submit(depth + 1)
+				submit(depth + 1)
+			}
+		})
+	}
+	submit(0)
+	e.Wait()
+
+	if got := count.Load(); got != 15 {
+		t.Errorf("expected 15 jobs to run before Wait returned, got %d", got)
+	}
+	if _, _, completed, panicked := e.Stats(); completed != 15 || panicked != 0 {
+		t.Errorf("Stats(): got %d completed and %d panicked, want 15 and 0", completed, panicked)
+	}
+}
+
+// TestEngine_PerKey ensures no more than perKey jobs with the same key run at once while other keys still run.
+func TestEngine_PerKey(t *testing.T) {
+	e := New(ctx, 8, 2)
+	defer e.Stop()
+
+	var m sync.Mutex
+	running, peak := map[string]int{}, map[string]int{}
+	for i := 0; i < 20; i++ {
+		for _, key := range []string{"a", "b"} {
+			key := key
+			e.Submit(key, func() {
+				m.Lock()
+				running[key]++
+				if running[key] > peak[key] {
+					peak[key] = running[key]
+				}
+				m.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				m.Lock()
+				running[key]--
+				m.Unlock()
+			})
+		}
+	}
+	e.Wait()
+
+	for _, key := range []string{"a", "b"} {
+		if peak[key] != 2 {
+			t.Errorf("expected at most 2 %s jobs to run at once, got %d", key, peak[key])
+		}
+	}
+}
+
+func TestEngine_Panic(t *testing.T) {
+	e := New(ctx, 1, 0)
+	defer e.Stop()
+
+	e.Submit("a", func() { panic("test") })
+	e.Submit("a", func() {})
+	e.Wait()
+
+	if _, _, completed, panicked := e.Stats(); completed != 2 || panicked != 1 {
+		t.Errorf("Stats(): got %d completed and %d panicked, want 2 and 1", completed, panicked)
+	}
+}
+
+func TestEngine_Nil(t *testing.T) {
+	var e *Engine
+	ran := false
+	e.Submit("a", func() { ran = true })
+	e.Wait()
+	if !ran {
+		t.Error("expected a nil engine to run the job inline")
+	}
+	if queued, running, completed, panicked := e.Stats(); queued+running+completed+panicked != 0 {
+		t.Errorf("Stats(): got %d, %d, %d and %d, want zeros", queued, running, completed, panicked)
+	}
+	e.Stop()
+}
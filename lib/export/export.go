@@ -0,0 +1,321 @@
+package export
+
+import (
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCypher   = "cypher"
+	FormatNeo4jCSV = "neo4j-csv"
+	FormatGraphML  = "graphml"
+	FormatHTML     = "html"
+
+	// EdgeLabel is the relationship type used for edges in the Neo4j formats.
+	EdgeLabel = "CAN_ASSUME"
+
+	// ObservedEdgeLabel is the relationship type used for edges that were seen in CloudTrail.
+	ObservedEdgeLabel = "OBSERVED_ASSUME"
+
+	// CredentialsEdgeLabel is the relationship type used for principals that can create credentials for a user.
+	CredentialsEdgeLabel = "CAN_CREATE_CREDENTIALS"
+
+	// PassRoleEdgeLabel is the relationship type used for principals that can pass a role to a compute service.
+	PassRoleEdgeLabel = "COULD_PASS_ROLE"
+
+	// PotentialEdgeLabel is the relationship type used for other potential edges found by policy simulation.
+	PotentialEdgeLabel = "COULD_ASSUME"
+)
+
+var Formats = []string{FormatCypher, FormatNeo4jCSV, FormatGraphML, FormatHTML}
+
+// Node is a principal in the graph with the properties that are exported.
+type Node struct {
+	Arn     string
+	Name    string
+	Account string
+
+	// Type is the resource type from the ARN, role or user.
+	Type string
+
+	// SourceProfile is the profile passed with -profiles that access to this principal was first gained from.
+	SourceProfile string
+
+	// Profile is true if this principal is the identity of a profile rather than an assumed role.
+	Profile bool
+
+	// Color is the color of the account, the same as the one used in the Graphviz diagram.
+	Color string
+
+	// Observed is true if the principal is only known from edges seen in CloudTrail or found by policy simulation.
+	Observed bool
+
+	// OU is the path of the organizational unit the account is in, if the organization was discovered with -org.
+	OU string
+
+	// References are the places the role was found outside of iam:ListRoles, if it was found by -references.
+	References []graph.Reference
+}
+
+// Edge is a successful sts:AssumeRole call from Source to Target.
+type Edge struct {
+	Source       string
+	Target       string
+	ExternalId   string
+	DiscoveredAt *time.Time
+
+	// Observed is true if the edge was seen in CloudTrail rather than traversed by liquidswards. Observed edges have
+	// the first and last time the call was seen and the number of calls, ExternalId is the first external ID used.
+	Observed  bool
+	FirstSeen *time.Time
+	LastSeen  *time.Time
+	Count     int
+
+	// Potential is the kind of potential edge, see graph.Potential, if the edge was found by policy simulation rather
+	// than traversed. Actions are the actions that the simulation allowed.
+	Potential string
+	Actions   []string
+}
+
+// Data is the graph converted to the types above, sorted so the output is stable.
+type Data struct {
+	Nodes []Node
+	Edges []Edge
+}
+
+// FromGraph walks Graph.Nodes() and returns the nodes and edges to export.
+func FromGraph(g *graph.Graph[*creds.Config]) Data {
+	var d Data
+	for id, n := range g.Nodes() {
+		cfg := n.Value()
+		d.Nodes = append(d.Nodes, Node{
+			Arn:           id,
+			Name:          cfg.Name(),
+			Account:       account(id),
+			Type:          resourceType(id),
+			SourceProfile: sourceProfile(cfg.Identity),
+			Profile:       cfg.Identity.Type == creds.SourceProfile,
+		})
+
+		for target := range n.Outbound() {
+			e := Edge{Source: id, Target: target}
+			if meta, ok := n.Edges()[target]; ok {
+				if meta.ExternalId != nil {
+					e.ExternalId = *meta.ExternalId
+				}
+				e.DiscoveredAt = meta.DiscoveredAt
+			}
+			d.Edges = append(d.Edges, e)
+		}
+	}
+
+	// Principals that were only seen in CloudTrail are added as observed nodes.
+	seen := map[string]bool{}
+	for _, n := range d.Nodes {
+		seen[n.Arn] = true
+	}
+	for src, targets := range g.Observed() {
+		for target, o := range targets {
+			for _, arn := range []string{src, target} {
+				if !seen[arn] {
+					seen[arn] = true
+					d.Nodes = append(d.Nodes, observedNode(arn))
+				}
+			}
+
+			e := Edge{Source: src, Target: target, Observed: true, Count: o.Count}
+			e.FirstSeen, e.LastSeen = &o.FirstSeen, &o.LastSeen
+			if len(o.ExternalIds) != 0 {
+				e.ExternalId = o.ExternalIds[0]
+			}
+			d.Edges = append(d.Edges, e)
+		}
+	}
+
+	for src, targets := range g.PotentialEdges() {
+		for target, edges := range targets {
+			for _, arn := range []string{src, target} {
+				if !seen[arn] {
+					seen[arn] = true
+					d.Nodes = append(d.Nodes, observedNode(arn))
+				}
+			}
+
+			for _, p := range edges {
+				at := p.DiscoveredAt
+				d.Edges = append(d.Edges, Edge{Source: src, Target: target, DiscoveredAt: &at, Potential: p.Kind, Actions: p.Actions})
+			}
+		}
+	}
+
+	accounts, references := g.Accounts(), g.References()
+	for i, n := range d.Nodes {
+		d.Nodes[i].OU = accounts[n.Account].OU
+		d.Nodes[i].References = references[n.Arn]
+	}
+
+	sort.Slice(d.Nodes, func(i, j int) bool { return d.Nodes[i].Arn < d.Nodes[j].Arn })
+
+	// Colors are shared with the Graphviz diagram, accounts only known from observed nodes are numbered after the rest.
+	var profiles []string
+	for _, n := range d.Nodes {
+		if n.Profile {
+			profiles = append(profiles, n.Arn)
+		}
+	}
+	color := g.AccountColors(profiles)
+	for i, n := range d.Nodes {
+		d.Nodes[i].Color = color.Hex(n.Arn)
+	}
+
+	sort.Slice(d.Edges, func(i, j int) bool {
+		if d.Edges[i].Source != d.Edges[j].Source {
+			return d.Edges[i].Source < d.Edges[j].Source
+		} else if d.Edges[i].Target != d.Edges[j].Target {
+			return d.Edges[i].Target < d.Edges[j].Target
+		} else if d.Edges[i].order() != d.Edges[j].order() {
+			return d.Edges[i].order() < d.Edges[j].order()
+		}
+		return d.Edges[i].Potential < d.Edges[j].Potential
+	})
+	return d
+}
+
+// Write exports d in the given format. The Neo4j CSV format writes a directory of files to path, the other formats
+// write a single file, or stdout if path is empty.
+func Write(format, path string, d Data) error {
+	switch format {
+	case FormatNeo4jCSV:
+		if path == "" {
+			return fmt.Errorf("Write(): an output directory is required for %s", format)
+		}
+		return Neo4jCSV(path, d)
+	case FormatCypher, FormatGraphML, FormatHTML:
+		var w io.Writer = os.Stdout
+		if path != "" {
+			f, err := os.Create(path)
+			if err != nil {
+				return fmt.Errorf("Write(): %w", err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		switch format {
+		case FormatCypher:
+			return Cypher(w, d)
+		case FormatHTML:
+			return HTML(w, d)
+		default:
+			return GraphML(w, d)
+		}
+	default:
+		return fmt.Errorf("Write(): unknown format %s, expected one of: %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// label returns the Neo4j label for the resource type.
+func (n Node) label() string {
+	switch n.Type {
+	case "role":
+		return "Role"
+	case "user":
+		return "User"
+	default:
+		return "Principal"
+	}
+}
+
+// label returns the Neo4j relationship type for the edge.
+func (e Edge) label() string {
+	switch {
+	case e.Observed:
+		return ObservedEdgeLabel
+	case e.Potential == graph.PotentialCredentials:
+		return CredentialsEdgeLabel
+	case e.Potential == graph.PotentialPassRole:
+		return PassRoleEdgeLabel
+	case e.Potential != "":
+		return PotentialEdgeLabel
+	default:
+		return EdgeLabel
+	}
+}
+
+// order sorts edges between the same principals, traversed edges are first followed by observed and potential edges.
+func (e Edge) order() int {
+	switch {
+	case e.Observed:
+		return 1
+	case e.Potential != "":
+		return 2
+	default:
+		return 0
+	}
+}
+
+func (e Edge) discoveredAt() string {
+	return formatTime(e.DiscoveredAt)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// count returns the number of calls seen for observed edges, or an empty string for other edges.
+func (e Edge) count() string {
+	if !e.Observed {
+		return ""
+	}
+	return strconv.Itoa(e.Count)
+}
+
+// observedNode returns the Node for a principal that isn't in the graph. The source of an observed edge may also be
+// an AWS service principal, like ec2.amazonaws.com, rather than an ARN.
+func observedNode(arn string) Node {
+	p := strings.Split(arn, "/")
+	return Node{
+		Arn:      arn,
+		Name:     p[len(p)-1],
+		Account:  account(arn),
+		Type:     resourceType(arn),
+		Observed: true,
+	}
+}
+
+func account(arn string) string {
+	p := strings.Split(arn, ":")
+	if len(p) < 5 {
+		return ""
+	}
+	return p[4]
+}
+
+func resourceType(arn string) string {
+	p := strings.SplitN(arn, ":", 6)
+	if len(p) < 6 {
+		return ""
+	}
+	return strings.Split(p[5], "/")[0]
+}
+
+// sourceProfile follows the identity back to the profile it was assumed from.
+func sourceProfile(i creds.Identity) string {
+	for i.Source != nil {
+		i = *i.Source
+	}
+	if i.Type != creds.SourceProfile {
+		return ""
+	}
+	return i.Name
+}
@@ -0,0 +1,221 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ctx = utils.NewContext(context.Background())
+
+const (
+	profileArn = "arn:aws:iam::123456789012:user/source"
+	roleA      = "arn:aws:iam::123456789012:role/a"
+	roleB      = "arn:aws:iam::210987654321:role/it's-b"
+)
+
+// NewTestGraph returns the graph source -> a -> b where b is assumed with an external ID.
+func NewTestGraph(t *testing.T) *graph.Graph[*creds.Config] {
+	g := graph.NewDirectedGraph[*creds.Config]()
+	source, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/source", g))
+	source.Identity.Name = "dev"
+	g.AddNode(source)
+
+	a, err := source.Assume(ctx, roleA, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Sts = source.Sts
+	if _, err := a.Assume(ctx, roleB, []string{"vendor-id"}); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestFromGraph(t *testing.T) {
+	d := FromGraph(NewTestGraph(t))
+
+	wantNodes := []Node{
+		{Arn: roleA, Name: "a", Account: "123456789012", Type: "role", SourceProfile: "dev", Color: "#b3e2cd"},
+		{Arn: profileArn, Name: "source", Account: "123456789012", Type: "user", SourceProfile: "dev", Profile: true, Color: "#b3e2cd"},
+		{Arn: roleB, Name: "it's-b", Account: "210987654321", Type: "role", SourceProfile: "dev", Color: "#fdcdac"},
+	}
+	if diff := cmp.Diff(d.Nodes, wantNodes); diff != "" {
+		t.Errorf("Nodes (-got +want):\n%s", diff)
+	}
+
+	wantEdges := []Edge{
+		{Source: roleA, Target: roleB, ExternalId: "vendor-id"},
+		{Source: profileArn, Target: roleA},
+	}
+	if diff := cmp.Diff(d.Edges, wantEdges, cmpopts.IgnoreFields(Edge{}, "DiscoveredAt")); diff != "" {
+		t.Errorf("Edges (-got +want):\n%s", diff)
+	}
+	for _, e := range d.Edges {
+		if e.DiscoveredAt == nil {
+			t.Errorf("edge %s -> %s is missing DiscoveredAt", e.Source, e.Target)
+		}
+	}
+}
+
+func TestCypher(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Cypher(&buf, FromGraph(NewTestGraph(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`MERGE (n:Principal {arn: 'arn:aws:iam::123456789012:user/source'}) SET n:User, n.name = 'source', n.account = '123456789012', n.type = 'user', n.source_profile = 'dev', n.ou = '';`,
+		`MERGE (n:Principal {arn: 'arn:aws:iam::210987654321:role/it\'s-b'}) SET n:Role,`,
+		`MATCH (a:Principal {arn: 'arn:aws:iam::123456789012:role/a'}), (b:Principal {arn: 'arn:aws:iam::210987654321:role/it\'s-b'}) MERGE (a)-[r:CAN_ASSUME]->(b) SET r.external_id = 'vendor-id', r.discovered_at = datetime(`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Cypher() output is missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestNeo4jCSV(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "neo4j")
+	if err := Write(FormatNeo4jCSV, dir, FromGraph(NewTestGraph(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := readCSV(t, filepath.Join(dir, "nodes.csv"))
+	if diff := cmp.Diff(nodes[:2], [][]string{
+		{"arn:ID", "name", "account", "type", "source_profile", "ou", ":LABEL"},
+		{roleA, "a", "123456789012", "role", "dev", "", "Principal;Role"},
+	}); diff != "" {
+		t.Errorf("nodes.csv (-got +want):\n%s", diff)
+	}
+
+	edges := readCSV(t, filepath.Join(dir, "relationships.csv"))
+	if len(edges) != 3 || edges[1][0] != roleA || edges[1][2] != EdgeLabel || edges[1][3] != "vendor-id" {
+		t.Errorf("unexpected relationships.csv: %v", edges)
+	}
+}
+
+func TestGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GraphML(&buf, FromGraph(NewTestGraph(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	var got graphML
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Graph.Nodes) != 3 || len(got.Graph.Edges) != 2 || got.Graph.EdgeDefault != "directed" {
+		t.Fatalf("unexpected graph: %+v", got.Graph)
+	}
+
+	e := got.Graph.Edges[0]
+	if e.Source != roleA || e.Target != roleB || e.Data[0] != (graphMLData{Key: "external_id", Value: "vendor-id"}) {
+		t.Errorf("unexpected edge: %+v", e)
+	}
+}
+
+func TestWrite_UnknownFormat(t *testing.T) {
+	if err := Write("dot", "", Data{}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestFromGraph_Observed(t *testing.T) {
+	g := NewTestGraph(t)
+	first := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	g.Observe("event-1", "ec2.amazonaws.com", roleA, graph.Observation{FirstSeen: first, LastSeen: first, Count: 1})
+	g.Observe("event-2", "ec2.amazonaws.com", roleA, graph.Observation{FirstSeen: last, LastSeen: last, Count: 1})
+	g.Observe("event-3", roleA, roleB, graph.Observation{FirstSeen: first, LastSeen: first, Count: 1})
+
+	d := FromGraph(g)
+	if len(d.Nodes) != 4 {
+		t.Fatalf("expected 4 nodes, got %d", len(d.Nodes))
+	}
+	if n := d.Nodes[3]; n.Arn != "ec2.amazonaws.com" || !n.Observed || n.Color == "" {
+		t.Errorf("unexpected observed node: %+v", n)
+	}
+
+	wantEdges := []Edge{
+		{Source: roleA, Target: roleB, ExternalId: "vendor-id"},
+		{Source: roleA, Target: roleB, Observed: true, FirstSeen: &first, LastSeen: &first, Count: 1},
+		{Source: profileArn, Target: roleA},
+		{Source: "ec2.amazonaws.com", Target: roleA, Observed: true, FirstSeen: &first, LastSeen: &last, Count: 2},
+	}
+	if diff := cmp.Diff(d.Edges, wantEdges, cmpopts.IgnoreFields(Edge{}, "DiscoveredAt")); diff != "" {
+		t.Errorf("Edges (-got +want):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	if err := Cypher(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	want := `MATCH (a:Principal {arn: 'ec2.amazonaws.com'}), (b:Principal {arn: 'arn:aws:iam::123456789012:role/a'}) MERGE (a)-[r:OBSERVED_ASSUME]->(b) SET r.external_id = '', r.first_seen = datetime('2021-03-04T00:00:00Z'), r.last_seen = datetime('2021-03-04T01:00:00Z'), r.count = 2;`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("Cypher() output is missing %q:\n%s", want, buf.String())
+	}
+}
+
+func TestFromGraph_Potential(t *testing.T) {
+	g := NewTestGraph(t)
+	at := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	user := "arn:aws:iam::123456789012:user/deploy"
+	g.AddPotential(roleA, user, graph.Potential{Kind: graph.PotentialCredentials, Actions: []string{"iam:CreateAccessKey"}, DiscoveredAt: at})
+	g.AddPotential(user, roleB, graph.Potential{Kind: graph.PotentialAssumeRole, Actions: []string{"sts:AssumeRole"}, DiscoveredAt: at})
+
+	d := FromGraph(g)
+	if len(d.Nodes) != 4 {
+		t.Fatalf("expected 4 nodes, got %d", len(d.Nodes))
+	}
+	if n := d.Nodes[1]; n.Arn != user || n.Type != "user" || !n.Observed {
+		t.Errorf("unexpected user node: %+v", n)
+	}
+
+	wantEdges := []Edge{
+		{Source: roleA, Target: user, DiscoveredAt: &at, Potential: graph.PotentialCredentials, Actions: []string{"iam:CreateAccessKey"}},
+		{Source: roleA, Target: roleB, ExternalId: "vendor-id"},
+		{Source: user, Target: roleB, DiscoveredAt: &at, Potential: graph.PotentialAssumeRole, Actions: []string{"sts:AssumeRole"}},
+		{Source: profileArn, Target: roleA},
+	}
+	if diff := cmp.Diff(d.Edges, wantEdges, cmpopts.IgnoreFields(Edge{}, "DiscoveredAt")); diff != "" {
+		t.Errorf("Edges (-got +want):\n%s", diff)
+	}
+	if d.Edges[0].label() != CredentialsEdgeLabel || d.Edges[2].label() != PotentialEdgeLabel {
+		t.Errorf("unexpected labels: %s, %s", d.Edges[0].label(), d.Edges[2].label())
+	}
+
+	var buf bytes.Buffer
+	if err := Cypher(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	want := `MERGE (a)-[r:CAN_CREATE_CREDENTIALS]->(b) SET r.external_id = '', r.discovered_at = datetime('2021-03-04T00:00:00Z'), r.actions = ['iam:CreateAccessKey'];`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("Cypher() output is missing %q:\n%s", want, buf.String())
+	}
+}
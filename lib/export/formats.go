@@ -0,0 +1,216 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Cypher writes a script that can be run with cypher-shell to load the graph. Nodes and relationships are merged so
+// the script can be run again after another scan.
+func Cypher(w io.Writer, d Data) error {
+	lines := []string{
+		"// Generated by liquidswards",
+		"CREATE CONSTRAINT principal_arn IF NOT EXISTS FOR (p:Principal) REQUIRE p.arn IS UNIQUE;",
+	}
+
+	for _, n := range d.Nodes {
+		lines = append(lines, fmt.Sprintf(
+			"MERGE (n:Principal {arn: %s}) SET n:%s, n.name = %s, n.account = %s, n.type = %s, n.source_profile = %s, n.ou = %s;",
+			quote(n.Arn), n.label(), quote(n.Name), quote(n.Account), quote(n.Type), quote(n.SourceProfile), quote(n.OU),
+		))
+	}
+
+	for _, e := range d.Edges {
+		props := []string{fmt.Sprintf("r.external_id = %s", quote(e.ExternalId))}
+		if at := e.discoveredAt(); at != "" {
+			props = append(props, fmt.Sprintf("r.discovered_at = datetime(%s)", quote(at)))
+		}
+		if e.Observed {
+			props = append(props,
+				fmt.Sprintf("r.first_seen = datetime(%s)", quote(formatTime(e.FirstSeen))),
+				fmt.Sprintf("r.last_seen = datetime(%s)", quote(formatTime(e.LastSeen))),
+				fmt.Sprintf("r.count = %d", e.Count),
+			)
+		}
+		if e.Potential != "" {
+			props = append(props, fmt.Sprintf("r.actions = [%s]", strings.Join(quoteAll(e.Actions), ", ")))
+		}
+		lines = append(lines, fmt.Sprintf(
+			"MATCH (a:Principal {arn: %s}), (b:Principal {arn: %s}) MERGE (a)-[r:%s]->(b) SET %s;",
+			quote(e.Source), quote(e.Target), e.label(), strings.Join(props, ", "),
+		))
+	}
+
+	if _, err := fmt.Fprintln(w, strings.Join(lines, "\n")); err != nil {
+		return fmt.Errorf("Cypher(): %w", err)
+	}
+	return nil
+}
+
+func quoteAll(s []string) []string {
+	var resp []string
+	for _, v := range s {
+		resp = append(resp, quote(v))
+	}
+	return resp
+}
+
+// quote returns s as a Cypher string literal.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
+
+// Neo4jCSV writes nodes.csv and relationships.csv to dir in the format expected by neo4j-admin import:
+//
+//	neo4j-admin database import full --nodes=nodes.csv --relationships=relationships.csv
+func Neo4jCSV(dir string, d Data) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("Neo4jCSV(): %w", err)
+	}
+
+	nodes := [][]string{{"arn:ID", "name", "account", "type", "source_profile", "ou", ":LABEL"}}
+	for _, n := range d.Nodes {
+		nodes = append(nodes, []string{n.Arn, n.Name, n.Account, n.Type, n.SourceProfile, n.OU, "Principal;" + n.label()})
+	}
+
+	edges := [][]string{{
+		":START_ID", ":END_ID", ":TYPE", "external_id", "discovered_at:datetime", "first_seen:datetime",
+		"last_seen:datetime", "count:int", "actions:string[]",
+	}}
+	for _, e := range d.Edges {
+		edges = append(edges, []string{
+			e.Source, e.Target, e.label(), e.ExternalId, e.discoveredAt(), formatTime(e.FirstSeen),
+			formatTime(e.LastSeen), e.count(), strings.Join(e.Actions, ";"),
+		})
+	}
+
+	if err := writeCSV(filepath.Join(dir, "nodes.csv"), nodes); err != nil {
+		return fmt.Errorf("Neo4jCSV(): %w", err)
+	}
+	if err := writeCSV(filepath.Join(dir, "relationships.csv"), edges); err != nil {
+		return fmt.Errorf("Neo4jCSV(): %w", err)
+	}
+	return nil
+}
+
+func writeCSV(path string, records [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(records); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		Id          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	Id       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	Id   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Id     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// GraphML writes the graph in the GraphML format, which can be opened with tools like Gephi, yEd or Cytoscape.
+func GraphML(w io.Writer, d Data) error {
+	doc := graphML{Xmlns: "http://graphml.graphdrawing.org/xmlns"}
+	for _, k := range []string{"name", "account", "type", "source_profile", "ou"} {
+		doc.Keys = append(doc.Keys, graphMLKey{Id: k, For: "node", AttrName: k, AttrType: "string"})
+	}
+	for _, k := range []string{"external_id", "discovered_at", "first_seen", "last_seen", "count", "actions", "label"} {
+		doc.Keys = append(doc.Keys, graphMLKey{Id: k, For: "edge", AttrName: k, AttrType: "string"})
+	}
+
+	doc.Graph.Id = "liquidswards"
+	doc.Graph.EdgeDefault = "directed"
+	for _, n := range d.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			Id: n.Arn,
+			Data: nonEmpty(
+				graphMLData{Key: "name", Value: n.Name},
+				graphMLData{Key: "account", Value: n.Account},
+				graphMLData{Key: "type", Value: n.Type},
+				graphMLData{Key: "source_profile", Value: n.SourceProfile},
+				graphMLData{Key: "ou", Value: n.OU},
+			),
+		})
+	}
+	for i, e := range d.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Id:     fmt.Sprintf("e%d", i),
+			Source: e.Source,
+			Target: e.Target,
+			Data: nonEmpty(
+				graphMLData{Key: "external_id", Value: e.ExternalId},
+				graphMLData{Key: "discovered_at", Value: e.discoveredAt()},
+				graphMLData{Key: "first_seen", Value: formatTime(e.FirstSeen)},
+				graphMLData{Key: "last_seen", Value: formatTime(e.LastSeen)},
+				graphMLData{Key: "count", Value: e.count()},
+				graphMLData{Key: "actions", Value: strings.Join(e.Actions, " ")},
+				graphMLData{Key: "label", Value: e.label()},
+			),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("GraphML(): %w", err)
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("GraphML(): %w", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("GraphML(): %w", err)
+	}
+	return nil
+}
+
+func nonEmpty(data ...graphMLData) []graphMLData {
+	var resp []graphMLData
+	for _, d := range data {
+		if d.Value != "" {
+			resp = append(resp, d)
+		}
+	}
+	return resp
+}
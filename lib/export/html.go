@@ -0,0 +1,33 @@
+package export
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+//go:embed report.html
+var reportTemplate string
+
+// HTML writes a self-contained interactive report, all the scripts and styles are embedded so it works offline.
+func HTML(w io.Writer, d Data) error {
+	// json.Marshal escapes <, > and & so the data can't close the script tag it's embedded in.
+	b, err := json.Marshal(struct {
+		Generated string
+		Data
+	}{
+		Generated: time.Now().UTC().Format(time.RFC3339),
+		Data:      d,
+	})
+	if err != nil {
+		return fmt.Errorf("HTML(): %w", err)
+	}
+
+	if _, err := io.WriteString(w, strings.Replace(reportTemplate, "/*DATA*/", string(b), 1)); err != nil {
+		return fmt.Errorf("HTML(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	d := FromGraph(NewTestGraph(t))
+	d.Nodes[0].Name = "</script><script>alert(1)</script>"
+
+	var buf bytes.Buffer
+	if err := HTML(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	// The report has to work offline so nothing can be loaded from elsewhere.
+	if m := regexp.MustCompile(`(src|href)=["']?(https?:)?//`).FindString(out); m != "" {
+		t.Errorf("HTML() output references an external resource: %s", m)
+	}
+
+	start := `<script id="data" type="application/json">`
+	i := strings.Index(out, start)
+	if i == -1 {
+		t.Fatal("HTML() output is missing the data script")
+	}
+	raw := out[i+len(start):]
+	raw = raw[:strings.Index(raw, "</script>")]
+
+	var got struct {
+		Generated string
+		Data
+	}
+	if err := json.Unmarshal([]byte(raw), &got); err != nil {
+		t.Fatalf("embedded data doesn't parse: %s\n%s", err, raw)
+	}
+	if got.Generated == "" {
+		t.Error("embedded data is missing the generated time")
+	}
+	if diff := cmp.Diff(got.Data, d); diff != "" {
+		t.Errorf("embedded data (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"fmt"
+)
+
+// Account is what is known about an AWS account from AWS Organizations.
+type Account struct {
+	Name  string `json:"Name"`
+	Email string `json:"Email,omitempty"`
+
+	// OU is the path of the organizational unit the account is in starting from the root, for example Root/Prod/Web.
+	OU string `json:"OU"`
+
+	Tags map[string]string `json:"Tags,omitempty"`
+}
+
+// SetAccount stores the organization details of the account, nodes in the account are tagged with them when exported.
+func (g *Graph[T]) SetAccount(id string, account Account) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if g.accounts == nil {
+		g.accounts = map[string]Account{}
+	}
+	g.accounts[id] = account
+}
+
+// GetAccount returns the organization details of the account if they are known.
+func (g *Graph[T]) GetAccount(id string) (Account, bool) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	account, ok := g.accounts[id]
+	return account, ok
+}
+
+// Accounts returns a copy of the organization details of every known account keyed by the account ID.
+func (g *Graph[T]) Accounts() map[string]Account {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	resp := map[string]Account{}
+	for id, account := range g.accounts {
+		resp[id] = account
+	}
+	return resp
+}
+
+// LoadAccounts reads accounts saved with SaveAccounts.
+func (g *Graph[T]) LoadAccounts(path string) error {
+	var accounts map[string]Account
+	if err := readJSON(path, &accounts); err != nil {
+		return fmt.Errorf("LoadAccounts(): %w", err)
+	}
+
+	for id, account := range accounts {
+		g.SetAccount(id, account)
+	}
+	return nil
+}
+
+// SaveAccounts writes the organization details of the known accounts to path.
+func (g *Graph[T]) SaveAccounts(path string) error {
+	if err := writeJSON(path, g.Accounts()); err != nil {
+		return fmt.Errorf("SaveAccounts(): %w", err)
+	}
+	return nil
+}
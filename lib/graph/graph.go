@@ -10,6 +10,8 @@ import (
 	"io/fs"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -25,6 +27,21 @@ type Graph[T Value] struct {
 	// vertice with the value being the pointer to it
 	nodes map[string]Node[T]
 	m     *sync.Mutex
+
+	// observed holds the edges seen in CloudTrail keyed by the source and then target ARN, see Observe.
+	observed map[string]map[string]Observation
+
+	// observedEvents is the set of CloudTrail event IDs that have been recorded in observed.
+	observedEvents map[string]bool
+
+	// accounts holds the AWS Organizations details of accounts keyed by the account ID, see SetAccount.
+	accounts map[string]Account
+
+	// references holds where roles were found keyed by the role ARN, see AddReference.
+	references map[string][]Reference
+
+	// potential holds the edges found with policy simulation keyed by the source and then target ARN, see AddPotential.
+	potential map[string]map[string][]Potential
 }
 
 // The AddEdge method adds an edge between two vertices in the graph
@@ -43,12 +60,142 @@ func (g *Graph[T]) AddEdge(k1, k2 T) {
 	n1.assumes[n2.value.Id()] = n2
 	n2.assumedBy[n1.value.Id()] = n1
 
+	// The edge may have been denied in a previous attempt, it's allowed now so remove the old denial.
+	delete(n1.denied, n2.value.Id())
+
 	// Add the vertices to the graph's node map
 	g.nodes[n1.value.Id()] = n1
 	g.nodes[n2.value.Id()] = n2
 	g.m.Unlock()
 }
 
+// RemoveEdge removes the edge from src to target along with its metadata, for example after it stopped working.
+func (g *Graph[T]) RemoveEdge(src, target string) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if n1, ok := g.nodes[src].(*node[T]); ok {
+		delete(n1.assumes, target)
+		delete(n1.edges, target)
+	}
+	if n2, ok := g.nodes[target].(*node[T]); ok {
+		delete(n2.assumedBy, src)
+	}
+}
+
+// RemoveNode removes the node with the given key along with every edge and denial to or from it. False is returned if
+// it didn't exist.
+func (g *Graph[T]) RemoveNode(k string) bool {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	n, ok := g.nodes[k].(*node[T])
+	if !ok {
+		return false
+	}
+	for id := range n.assumes {
+		if other, ok := g.nodes[id].(*node[T]); ok {
+			delete(other.assumedBy, k)
+		}
+	}
+	for id := range n.assumedBy {
+		if other, ok := g.nodes[id].(*node[T]); ok {
+			delete(other.assumes, k)
+			delete(other.edges, k)
+		}
+	}
+
+	// Denials aren't indexed by their target, so every node is checked for ones to the removed node.
+	for _, v := range g.nodes {
+		if other, ok := v.(*node[T]); ok {
+			delete(other.denied, k)
+		}
+	}
+	delete(g.nodes, k)
+	return true
+}
+
+// SetEdge stores metadata for the edge from src to target, the edge itself should be added with AddEdge.
+func (g *Graph[T]) SetEdge(src, target string, edge Edge) {
+	n1, ok := g.getNode(src)
+	if !ok {
+		return
+	}
+
+	g.m.Lock()
+	n1.edges[target] = edge
+	g.m.Unlock()
+}
+
+// GetEdge returns the metadata stored for the edge from src to target.
+func (g *Graph[T]) GetEdge(src, target string) (Edge, bool) {
+	n1, ok := g.getNode(src)
+	if !ok {
+		return Edge{}, false
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	edge, ok := n1.edges[target]
+	return edge, ok
+}
+
+// AddDenial records that traversing the edge from k1 to target was attempted and failed.
+func (g *Graph[T]) AddDenial(k1 T, target string, denial Denial) {
+	n1, ok := g.getNode(k1.Id())
+	if !ok {
+		n1 = g.AddNode(k1)
+	}
+
+	g.m.Lock()
+	n1.denied[target] = denial
+	g.m.Unlock()
+}
+
+// GetDenial returns the denial recorded for the edge from src to target if there is one.
+func (g *Graph[T]) GetDenial(src, target string) (Denial, bool) {
+	n1, ok := g.getNode(src)
+	if !ok {
+		return Denial{}, false
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	denial, ok := n1.denied[target]
+	return denial, ok
+}
+
+type EdgeStatus int
+
+const (
+	// EdgeUntested means no attempt to traverse the edge has been recorded.
+	EdgeUntested EdgeStatus = iota
+	// EdgeAllowed means the edge was successfully traversed.
+	EdgeAllowed
+	// EdgeDenied means the edge was attempted and failed.
+	EdgeDenied
+)
+
+// EdgeStatus returns whether the edge from src to target is untested, allowed or denied.
+func (g *Graph[T]) EdgeStatus(src, target string) EdgeStatus {
+	n1, ok := g.getNode(src)
+	if !ok {
+		return EdgeUntested
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if _, ok := n1.assumes[target]; ok {
+		return EdgeAllowed
+	} else if _, ok := n1.denied[target]; ok {
+		return EdgeDenied
+	}
+	return EdgeUntested
+}
+
 // DFS runs a depth first search on the graph
 func (g *Graph[T]) DFS(ctx utils.Context, start string, visited map[string]bool, path []Node[T], visitCb func(Node[T], []Node[T]), last bool) {
 	startNode, ok := g.getNode(start)
@@ -182,6 +329,82 @@ func (g *Graph[T]) PrintGraph(ctx utils.Context, nodes []T) error {
 	return nil
 }
 
+// PrintDenied prints the recorded denials grouped by the target that blocked them.
+func (g *Graph[T]) PrintDenied() {
+	byTarget := map[string][]string{}
+	for _, n := range g.Nodes() {
+		for target, denial := range n.Denied() {
+			line := fmt.Sprintf("%s (%s: %s)", n.Value().Id(), denial.Reason, denial.Code)
+			byTarget[target] = append(byTarget[target], line)
+		}
+	}
+
+	if len(byTarget) == 0 {
+		return
+	}
+
+	fmt.Println(utils.Red.Color("\nDenied:"))
+	targets := utils.Keys(byTarget)
+	sort.Strings(targets)
+	for _, target := range targets {
+		fmt.Printf("\n %s %s", utils.Red.Color("*"), target)
+		sources := byTarget[target]
+		sort.Strings(sources)
+		for _, src := range sources {
+			fmt.Printf("\n\t%s %s", utils.Red.Color("<-"), src)
+		}
+	}
+	fmt.Printf("\n")
+}
+
+// PrintPotential prints the potential edges grouped by their target, they weren't traversed so are listed separately
+// from the accessed principals.
+func (g *Graph[T]) PrintPotential() {
+	byTarget := map[string][]string{}
+	for src, targets := range g.PotentialEdges() {
+		for target, edges := range targets {
+			for _, p := range edges {
+				line := fmt.Sprintf("%s %s (%s)", potentialArrow(p.Kind), src, strings.Join(p.Actions, ", "))
+				byTarget[target] = append(byTarget[target], line)
+			}
+		}
+	}
+
+	if len(byTarget) == 0 {
+		return
+	}
+
+	fmt.Println(utils.Yellow.Color("\nPotential:"))
+	targets := utils.Keys(byTarget)
+	sort.Strings(targets)
+	for _, target := range targets {
+		fmt.Printf("\n %s %s", utils.Yellow.Color("*"), target)
+		sources := byTarget[target]
+		sort.Strings(sources)
+		for _, src := range sources {
+			fmt.Printf("\n\t%s", src)
+		}
+	}
+	fmt.Printf("\n")
+}
+
+// potentialArrow returns the arrow used for the kind of potential edge in the text report, pass-role edges are
+// highlighted since they run code as the role rather than assuming it.
+func potentialArrow(kind string) string {
+	if kind == PotentialPassRole {
+		return utils.Cyan.Color("<-" + kind + "-")
+	}
+	return utils.Yellow.Color("<-" + kind + "-")
+}
+
+// AccountColors returns the colors of the accounts in the graph. This is shared by SaveDiagram and the exported report
+// so both color accounts the same way, the accounts of profiles are numbered first and then the rest, each in ID order.
+func (g *Graph[T]) AccountColors(profiles []string) utils.Colors {
+	profiles = append([]string{}, profiles...)
+	sort.Strings(profiles)
+	return utils.ColorFromArns(append(profiles, sortedIds(g.Nodes())...))
+}
+
 func (g *Graph[T]) SaveDiagram(ctx utils.Context, nodes []T, path string) error {
 	graph := graphviz.New()
 	gviz, err := graph.Graph()
@@ -196,7 +419,11 @@ func (g *Graph[T]) SaveDiagram(ctx utils.Context, nodes []T, path string) error
 	}()
 	gviz.SetRankDir("LR")
 
-	color := utils.ColorFromArn()
+	var profiles []string
+	for _, cfg := range nodes {
+		profiles = append(profiles, cfg.Id())
+	}
+	color := g.AccountColors(profiles)
 
 	fmt.Println(utils.Green.Color("\nGraphViz:"))
 	for _, cfg := range nodes {
@@ -205,7 +432,7 @@ func (g *Graph[T]) SaveDiagram(ctx utils.Context, nodes []T, path string) error
 		g.DFS(ctx, cfg.Id(), nil, []Node[T]{}, func(node Node[T], path []Node[T]) {
 			n1, ok := g.GetNode(node.Value().Id())
 			if !ok {
-				ctx.Error.Println("SaveDiagram(): the graph node with key '%v' does not exist\n", node.Value().Id())
+				ctx.Error.Printf("SaveDiagram(): the graph node with key '%v' does not exist\n", node.Value().Id())
 				return
 			}
 
@@ -244,6 +471,38 @@ func (g *Graph[T]) SaveDiagram(ctx utils.Context, nodes []T, path string) error
 		}, false)
 	}
 
+	// Potential edges are dashed and labeled with their kind to tell them apart from edges that were traversed,
+	// pass-role edges are dotted.
+	for src, targets := range g.PotentialEdges() {
+		for target, edges := range targets {
+			var ends []*cgraph.Node
+			for _, id := range []string{src, target} {
+				n, err := gviz.CreateNode(id)
+				if err != nil {
+					log.Fatal(err)
+				}
+				n.SetColor(color.Get(id))
+				n.SetStyle("filled")
+				ends = append(ends, n)
+			}
+
+			for _, p := range edges {
+				e, err := gviz.CreateEdge(fmt.Sprintf("%s-%s-%s", src, target, p.Kind), ends[0], ends[1])
+				if err != nil {
+					log.Fatal(err)
+				}
+				e.SetDir("forward")
+				e.SetLabel(p.Kind)
+				if p.Kind == PotentialPassRole {
+					e.SetStyle(cgraph.DottedEdgeStyle)
+					e.SetColor("purple")
+				} else {
+					e.SetStyle(cgraph.DashedEdgeStyle)
+				}
+			}
+		}
+	}
+
 	var buf bytes.Buffer
 	if err := graph.Render(gviz, "dot", &buf); err != nil {
 		log.Fatal(err)
@@ -261,6 +520,7 @@ func (g *Graph[T]) Report(ctx utils.Context, nodes []T, path string) error {
 	if err != nil {
 		return fmt.Errorf("printing results: %w", err)
 	}
+	g.PrintPotential()
 
 	err = g.SaveDiagram(ctx, nodes, path)
 	if err != nil {
@@ -2,6 +2,7 @@
 package graph
 
 import (
+	"github.com/google/go-cmp/cmp"
 	"sync"
 	"testing"
 )
@@ -26,3 +27,51 @@ func TestNewDirectedGraph(t *testing.T) {
 		t.Error("graph.nodes is nil")
 	}
 }
+
+func TestGraph_RemoveEdge(t *testing.T) {
+	g := NewTestPathGraph()
+	g.RemoveEdge("a", "c")
+
+	if got, _ := g.ShortestPath("a", "d"); cmp.Diff(got, []string{"a", "b", "c", "d"}) != "" {
+		t.Errorf("ShortestPath() after RemoveEdge(): got %v", got)
+	}
+	if status := g.EdgeStatus("a", "c"); status != EdgeUntested {
+		t.Errorf("EdgeStatus() after RemoveEdge(): got %d, want %d", status, EdgeUntested)
+	}
+}
+
+func TestGraph_RemoveNode(t *testing.T) {
+	g := NewTestPathGraph()
+	g.AddDenial("z", "c", Denial{Code: "AccessDenied"})
+	g.AddDenial("z", "e", Denial{Code: "AccessDenied"})
+	if !g.RemoveNode("c") {
+		t.Fatal("expected RemoveNode() to return true")
+	}
+	if g.RemoveNode("c") {
+		t.Error("expected RemoveNode() of a missing node to return false")
+	}
+
+	if _, ok := g.GetNode("c"); ok {
+		t.Error("expected c to be removed")
+	}
+	if got, _ := g.ShortestPath("a", "d"); cmp.Diff(got, []string{"a", "b", "e", "d"}) != "" {
+		t.Errorf("ShortestPath() after RemoveNode(): got %v", got)
+	}
+	for _, id := range []string{"a", "b", "d"} {
+		n, _ := g.GetNode(id)
+		if _, ok := n.Outbound()["c"]; ok {
+			t.Errorf("%s still assumes c", id)
+		}
+		if _, ok := n.Inbound()["c"]; ok {
+			t.Errorf("%s is still assumed by c", id)
+		}
+	}
+
+	// A role created later with the same name is a different role, so the denials to the old one don't apply.
+	if _, ok := g.GetDenial("z", "c"); ok {
+		t.Error("expected the denial from z to c to be removed")
+	}
+	if _, ok := g.GetDenial("z", "e"); !ok {
+		t.Error("expected the denial from z to e to be kept")
+	}
+}
@@ -2,6 +2,7 @@ package graph
 
 import (
 	"encoding/json"
+	"time"
 )
 
 type Value interface {
@@ -16,6 +17,30 @@ type Node[T Value] interface {
 	Value() T
 	Outbound() map[string]Node[T]
 	Inbound() map[string]Node[T]
+	Denied() map[string]Denial
+	Edges() map[string]Edge
+}
+
+// Edge holds metadata about an outbound edge, it is stored on the source node keyed by the target's ID.
+type Edge struct {
+	// ExternalId is the sts:ExternalId that was used to traverse the edge, if any.
+	ExternalId *string `json:"ExternalId,omitempty"`
+
+	// DiscoveredAt is when the edge was first traversed.
+	DiscoveredAt *time.Time `json:"DiscoveredAt,omitempty"`
+}
+
+// Denial records a failed attempt to traverse the edge from a node to the target identified by the key it is stored
+// under. The target doesn't need to exist in the graph since we never had access to it.
+type Denial struct {
+	// Code is the error code returned by the API, for example AccessDenied.
+	Code string `json:"Code"`
+
+	// Reason is the classification of Code, see creds.ClassifyAssumeRoleError.
+	Reason string `json:"Reason"`
+
+	// Time is when the attempt was made.
+	Time time.Time `json:"Time"`
 }
 
 type node[T Value] struct {
@@ -29,6 +54,13 @@ type node[T Value] struct {
 	// assumedBy stores references to other roles that can assume this role. This is useful if you want to determine
 	// the path needed to access a specific role.
 	assumedBy map[string]Node[T] `json:"AssumedBy"`
+
+	// denied stores failed attempts to assume other roles from this one, keyed by the target ARN. Along with assumes
+	// this lets us tell the difference between an edge that was never tested and one that was tested and denied.
+	denied map[string]Denial
+
+	// edges stores metadata for the edges in assumes, keyed by the target ARN.
+	edges map[string]Edge
 }
 
 type NewNodeInput[T Value] struct {
@@ -43,6 +75,8 @@ func NewNode[T Value](in NewNodeInput[T]) Node[T] {
 		value:     in.Value,
 		assumes:   map[string]Node[T]{},
 		assumedBy: map[string]Node[T]{},
+		denied:    map[string]Denial{},
+		edges:     map[string]Edge{},
 	}
 	for _, n := range in.Assumes {
 		node.assumes[n.Value().Id()] = n
@@ -63,6 +97,16 @@ func (n *node[T]) Inbound() map[string]Node[T] {
 	return n.assumedBy
 }
 
+// Denied returns a map of failed outbound attempts keyed by the target ARN.
+func (n *node[T]) Denied() map[string]Denial {
+	return n.denied
+}
+
+// Edges returns the metadata of outbound edges keyed by the target ARN.
+func (n *node[T]) Edges() map[string]Edge {
+	return n.edges
+}
+
 // Value returns the original value passed to Graph.AddNode()
 func (n *node[T]) Value() T { return n.value }
 
@@ -85,6 +129,8 @@ func (g *Graph[T]) AddNode(n T) *node[T] {
 		value:     n,
 		assumes:   map[string]Node[T]{},
 		assumedBy: map[string]Node[T]{},
+		denied:    map[string]Denial{},
+		edges:     map[string]Edge{},
 	}
 
 	g.m.Lock()
@@ -117,9 +163,11 @@ func (g *Graph[T]) getNode(k string) (*node[T], bool) {
 }
 
 type JsonNode[T Value] struct {
-	Value     T        `json:"Value"`
-	Assumes   []string `json:"Assumes"`
-	AssumedBy []string `json:"AssumedBy"`
+	Value     T                 `json:"Value"`
+	Assumes   []string          `json:"Assumes"`
+	AssumedBy []string          `json:"AssumedBy"`
+	Denied    map[string]Denial `json:"Denied,omitempty"`
+	Edges     map[string]Edge   `json:"Edges,omitempty"`
 }
 
 func (n *node[T]) MarshalJSON() ([]byte, error) {
@@ -127,7 +175,13 @@ func (n *node[T]) MarshalJSON() ([]byte, error) {
 		Value: n.value,
 	}
 	for k, _ := range n.assumes {
-		obj.Assumes = append(obj.AssumedBy, k)
+		obj.Assumes = append(obj.Assumes, k)
+	}
+	if len(n.denied) != 0 {
+		obj.Denied = n.denied
+	}
+	if len(n.edges) != 0 {
+		obj.Edges = n.edges
 	}
 	for k, _ := range n.assumedBy {
 		obj.AssumedBy = append(obj.AssumedBy, k)
@@ -151,6 +205,16 @@ func (n *node[T]) UnmarshalJSON(bytes []byte) error {
 	n.assumes = initMap[T](obj.Assumes)
 	n.assumedBy = initMap[T](obj.AssumedBy)
 
+	n.denied = obj.Denied
+	if n.denied == nil {
+		n.denied = map[string]Denial{}
+	}
+
+	n.edges = obj.Edges
+	if n.edges == nil {
+		n.edges = map[string]Edge{}
+	}
+
 	return nil
 }
 
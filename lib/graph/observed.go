@@ -0,0 +1,167 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"io/fs"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Observation is an edge that was seen being used in CloudTrail rather than traversed by liquidswards. Unlike the
+// edges added with AddEdge neither principal needs to be in the graph, we usually don't have access to either.
+type Observation struct {
+	// FirstSeen and LastSeen are the times of the earliest and latest successful calls.
+	FirstSeen time.Time `json:"FirstSeen"`
+	LastSeen  time.Time `json:"LastSeen"`
+
+	// Count is the number of successful calls seen.
+	Count int `json:"Count"`
+
+	// ExternalIds and SourceIdentities are the distinct values passed in the calls.
+	ExternalIds      []string `json:"ExternalIds,omitempty"`
+	SourceIdentities []string `json:"SourceIdentities,omitempty"`
+}
+
+// merge adds the calls in other to the observation.
+func (o Observation) merge(other Observation) Observation {
+	if o.Count == 0 || other.FirstSeen.Before(o.FirstSeen) {
+		o.FirstSeen = other.FirstSeen
+	}
+	if other.LastSeen.After(o.LastSeen) {
+		o.LastSeen = other.LastSeen
+	}
+	o.Count += other.Count
+	o.ExternalIds = union(o.ExternalIds, other.ExternalIds)
+	o.SourceIdentities = union(o.SourceIdentities, other.SourceIdentities)
+	return o
+}
+
+func union(a, b []string) []string {
+	a = slices.Clone(a)
+	for _, v := range b {
+		if v != "" && !slices.Contains(a, v) {
+			a = append(a, v)
+		}
+	}
+	sort.Strings(a)
+	return a
+}
+
+// Observe records a call from src to target seen in CloudTrail. The id is the CloudTrail event ID, it's used to avoid
+// counting the same event twice when it's found by more than one source or by a later scan over the same time. False
+// is returned if the event was already recorded.
+func (g *Graph[T]) Observe(id, src, target string, o Observation) bool {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if g.observedEvents == nil {
+		g.observedEvents = map[string]bool{}
+	}
+	if id != "" {
+		if g.observedEvents[id] {
+			return false
+		}
+		g.observedEvents[id] = true
+	}
+
+	g.merge(src, target, o)
+	return true
+}
+
+// merge adds o to the observed edge from src to target, g.m must be held.
+func (g *Graph[T]) merge(src, target string, o Observation) {
+	if g.observed == nil {
+		g.observed = map[string]map[string]Observation{}
+	}
+	if g.observed[src] == nil {
+		g.observed[src] = map[string]Observation{}
+	}
+	g.observed[src][target] = g.observed[src][target].merge(o)
+}
+
+// Observed returns a copy of the observed edges, keyed by the source and then target ARN.
+func (g *Graph[T]) Observed() map[string]map[string]Observation {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	resp := map[string]map[string]Observation{}
+	for src, targets := range g.observed {
+		resp[src] = map[string]Observation{}
+		for target, o := range targets {
+			resp[src][target] = o
+		}
+	}
+	return resp
+}
+
+// observedFile is the format of the file written by SaveObserved.
+type observedFile struct {
+	Edges map[string]map[string]Observation `json:"Edges"`
+
+	// Events are the IDs of the events counted in Edges, so they aren't counted again when a later scan searches the
+	// same time.
+	Events []string `json:"Events"`
+}
+
+// LoadObserved reads observed edges saved with SaveObserved, they are merged with any already in the graph. Events
+// that were already recorded in the graph are not counted twice.
+func (g *Graph[T]) LoadObserved(path string) error {
+	var file observedFile
+	if err := readJSON(path, &file); err != nil {
+		return fmt.Errorf("LoadObserved(): %w", err)
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if g.observedEvents == nil {
+		g.observedEvents = map[string]bool{}
+	}
+	for _, id := range file.Events {
+		g.observedEvents[id] = true
+	}
+	for src, targets := range file.Edges {
+		for target, o := range targets {
+			g.merge(src, target, o)
+		}
+	}
+	return nil
+}
+
+// SaveObserved writes the observed edges and the IDs of the events they were found in to path. They are kept separate
+// from the nodes since the principals they connect usually aren't in the graph.
+func (g *Graph[T]) SaveObserved(path string) error {
+	file := observedFile{Edges: g.Observed()}
+
+	g.m.Lock()
+	for id := range g.observedEvents {
+		file.Events = append(file.Events, id)
+	}
+	g.m.Unlock()
+	sort.Strings(file.Events)
+
+	if err := writeJSON(path, file); err != nil {
+		return fmt.Errorf("SaveObserved(): %w", err)
+	}
+	return nil
+}
+
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(utils.Must(utils.ExpandPath(path)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func writeJSON(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, fs.FileMode(0o600))
+}
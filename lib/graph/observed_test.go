@@ -0,0 +1,59 @@
+package graph
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGraph_Observe(t *testing.T) {
+	g := NewDirectedGraph[V]()
+	day := func(d int) time.Time { return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC) }
+	call := func(d int, externalId string) Observation {
+		return Observation{FirstSeen: day(d), LastSeen: day(d), Count: 1, ExternalIds: []string{externalId}}
+	}
+
+	if !g.Observe("event-1", "a", "b", call(2, "")) {
+		t.Error("expected the first event to be recorded")
+	}
+	if g.Observe("event-1", "a", "b", call(2, "")) {
+		t.Error("expected a duplicate event to be ignored")
+	}
+	g.Observe("event-2", "a", "b", call(1, "id-2"))
+	g.Observe("event-3", "a", "b", call(3, "id-1"))
+	g.Observe("event-4", "a", "c", call(4, ""))
+
+	want := map[string]map[string]Observation{
+		"a": {
+			"b": {FirstSeen: day(1), LastSeen: day(3), Count: 3, ExternalIds: []string{"id-1", "id-2"}},
+			"c": {FirstSeen: day(4), LastSeen: day(4), Count: 1},
+		},
+	}
+	if diff := cmp.Diff(g.Observed(), want); diff != "" {
+		t.Errorf("Observed() (-got +want):\n%s", diff)
+	}
+
+	path := filepath.Join(t.TempDir(), "observed.json")
+	if err := g.SaveObserved(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewDirectedGraph[V]()
+	if err := loaded.LoadObserved(path); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(loaded.Observed(), want); diff != "" {
+		t.Errorf("LoadObserved() (-got +want):\n%s", diff)
+	}
+
+	// A later scan over the same time finds the events again, only the new one is counted.
+	if loaded.Observe("event-3", "a", "b", call(3, "id-1")) {
+		t.Error("expected an event from the loaded scan to be ignored")
+	}
+	if !loaded.Observe("event-5", "a", "c", call(5, "")) {
+		t.Error("expected a new event to be recorded")
+	}
+	if got := loaded.Observed()["a"]["c"]; got.Count != 2 || !got.LastSeen.Equal(day(5)) {
+		t.Errorf("got %+v, want two calls last seen on day 5", got)
+	}
+}
@@ -0,0 +1,124 @@
+package graph
+
+import (
+	"sort"
+)
+
+// ShortestPath returns the IDs of the nodes on the shortest path from src to dst, including both ends. The search is
+// breadth first over Outbound(), when there is more than one shortest path the lexically smallest one is returned.
+func (g *Graph[T]) ShortestPath(src, dst string) ([]string, bool) {
+	start, ok := g.GetNode(src)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := g.GetNode(dst); !ok {
+		return nil, false
+	}
+
+	prev := map[string]string{src: ""}
+	queue := []Node[T]{start}
+	for len(queue) != 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		id := n.Value().Id()
+		if id == dst {
+			var path []string
+			for cur := dst; cur != src; cur = prev[cur] {
+				path = append([]string{cur}, path...)
+			}
+			return append([]string{src}, path...), true
+		}
+
+		outbound := n.Outbound()
+		for _, k := range sortedIds(outbound) {
+			if _, seen := prev[k]; seen {
+				continue
+			}
+			prev[k] = id
+			queue = append(queue, outbound[k])
+		}
+	}
+	return nil, false
+}
+
+// AllPaths returns every simple path from src to dst with at most maxDepth edges, a maxDepth of zero or less means
+// no limit. Paths are ordered by length and then lexically.
+func (g *Graph[T]) AllPaths(src, dst string, maxDepth int) [][]string {
+	start, ok := g.GetNode(src)
+	if !ok {
+		return nil
+	}
+
+	var paths [][]string
+	visited := map[string]bool{}
+
+	var walk func(n Node[T], path []string)
+	walk = func(n Node[T], path []string) {
+		id := n.Value().Id()
+		path = append(path, id)
+
+		if id == dst {
+			paths = append(paths, append([]string{}, path...))
+			return
+		}
+		if maxDepth > 0 && len(path) > maxDepth {
+			return
+		}
+
+		visited[id] = true
+		defer delete(visited, id)
+
+		outbound := n.Outbound()
+		for _, k := range sortedIds(outbound) {
+			if !visited[k] {
+				walk(outbound[k], path)
+			}
+		}
+	}
+	walk(start, nil)
+
+	sort.SliceStable(paths, func(i, j int) bool { return len(paths[i]) < len(paths[j]) })
+	return paths
+}
+
+// Reachers returns the IDs of every node that can reach target through one or more edges, found by walking
+// Inbound(). The result is sorted and doesn't include target unless it is part of a cycle.
+func (g *Graph[T]) Reachers(target string) []string {
+	start, ok := g.GetNode(target)
+	if !ok {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	queue := []Node[T]{start}
+	for len(queue) != 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		inbound := n.Inbound()
+		for _, k := range sortedIds(inbound) {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			queue = append(queue, inbound[k])
+		}
+	}
+
+	var resp []string
+	for k := range seen {
+		resp = append(resp, k)
+	}
+	sort.Strings(resp)
+	return resp
+}
+
+func sortedIds[T Value](nodes map[string]Node[T]) []string {
+	var ids []string
+	for k := range nodes {
+		ids = append(ids, k)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,118 @@
+package graph
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+// S is a Value identified by the string itself.
+type S string
+
+func (s S) Id() string                   { return string(s) }
+func (s S) SetGraph(_ interface{})       {}
+func (s S) MarshalJSON() ([]byte, error) { return []byte(`"` + s + `"`), nil }
+
+// NewTestPathGraph returns the graph:
+//
+//	a -> b -> c -> d
+//	a -> c
+//	b -> e -> d
+//	d -> b
+func NewTestPathGraph() *Graph[S] {
+	g := NewDirectedGraph[S]()
+	for _, n := range []S{"a", "b", "c", "d", "e", "z"} {
+		g.AddNode(n)
+	}
+	for _, e := range [][2]S{{"a", "b"}, {"b", "c"}, {"c", "d"}, {"a", "c"}, {"b", "e"}, {"e", "d"}, {"d", "b"}} {
+		g.AddEdge(e[0], e[1])
+	}
+	return g
+}
+
+func TestGraph_ShortestPath(t *testing.T) {
+	g := NewTestPathGraph()
+
+	for _, tt := range []struct {
+		src, dst string
+		want     []string
+		wantOk   bool
+	}{
+		{src: "a", dst: "d", want: []string{"a", "c", "d"}, wantOk: true},
+		{src: "a", dst: "e", want: []string{"a", "b", "e"}, wantOk: true},
+		{src: "d", dst: "e", want: []string{"d", "b", "e"}, wantOk: true},
+		{src: "a", dst: "a", want: []string{"a"}, wantOk: true},
+		{src: "d", dst: "a"},
+		{src: "a", dst: "z"},
+		{src: "a", dst: "missing"},
+	} {
+		got, ok := g.ShortestPath(tt.src, tt.dst)
+		if ok != tt.wantOk {
+			t.Errorf("ShortestPath(%s, %s) ok: got %t, want %t", tt.src, tt.dst, ok, tt.wantOk)
+		}
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("ShortestPath(%s, %s) (-got +want):\n%s", tt.src, tt.dst, diff)
+		}
+	}
+}
+
+func TestGraph_AllPaths(t *testing.T) {
+	g := NewTestPathGraph()
+
+	want := [][]string{
+		{"a", "c", "d"},
+		{"a", "b", "c", "d"},
+		{"a", "b", "e", "d"},
+	}
+	if diff := cmp.Diff(g.AllPaths("a", "d", 0), want); diff != "" {
+		t.Errorf("AllPaths(a, d, 0) (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(g.AllPaths("a", "d", 2), want[:1]); diff != "" {
+		t.Errorf("AllPaths(a, d, 2) (-got +want):\n%s", diff)
+	}
+
+	// The cycle d -> b -> c -> d is never followed back to d.
+	if diff := cmp.Diff(g.AllPaths("d", "c", 0), [][]string{{"d", "b", "c"}}); diff != "" {
+		t.Errorf("AllPaths(d, c, 0) (-got +want):\n%s", diff)
+	}
+	if got := g.AllPaths("d", "a", 0); len(got) != 0 {
+		t.Errorf("AllPaths(d, a, 0): got %v, want no paths", got)
+	}
+}
+
+func TestGraph_Reachers(t *testing.T) {
+	g := NewTestPathGraph()
+
+	// b is part of the b -> c -> d -> b cycle so it can reach itself.
+	if diff := cmp.Diff(g.Reachers("b"), []string{"a", "b", "c", "d", "e"}); diff != "" {
+		t.Errorf("Reachers(b) (-got +want):\n%s", diff)
+	}
+	if got := g.Reachers("a"); len(got) != 0 {
+		t.Errorf("Reachers(a): got %v, want none", got)
+	}
+	if got := g.Reachers("missing"); got != nil {
+		t.Errorf("Reachers(missing): got %v, want nil", got)
+	}
+}
+
+// TestGraph_AccountColors ensures profile accounts are colored first regardless of the order the profiles are passed.
+func TestGraph_AccountColors(t *testing.T) {
+	g := NewDirectedGraph[S]()
+	for _, n := range []S{"arn:aws:iam::111111111111:role/a", "arn:aws:iam::222222222222:user/p", "arn:aws:iam::333333333333:user/q"} {
+		g.AddNode(n)
+	}
+
+	for _, profiles := range [][]string{
+		{"arn:aws:iam::333333333333:user/q", "arn:aws:iam::222222222222:user/p"},
+		{"arn:aws:iam::222222222222:user/p", "arn:aws:iam::333333333333:user/q"},
+	} {
+		color := g.AccountColors(profiles)
+		got := []string{
+			color.Hex("arn:aws:iam::222222222222:user/p"),
+			color.Hex("arn:aws:iam::333333333333:user/q"),
+			color.Hex("arn:aws:iam::111111111111:role/a"),
+		}
+		if diff := cmp.Diff(got, []string{"#b3e2cd", "#fdcdac", "#cbd5e8"}); diff != "" {
+			t.Errorf("AccountColors(%v) (-got +want):\n%s", profiles, diff)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package graph
+
+import (
+	"fmt"
+	"time"
+)
+
+// Kinds of potential edges.
+const (
+	// PotentialCredentials means the source can create an access key or login profile for the target user.
+	PotentialCredentials = "create-credentials"
+
+	// PotentialAssumeRole means the source user's policies and the target role's trust policy allow sts:AssumeRole,
+	// we don't have credentials for the user so it wasn't attempted.
+	PotentialAssumeRole = "assume-role"
+
+	// PotentialPassRole means the source can pass the target role to a compute service that it can create resources
+	// in, running code as the role.
+	PotentialPassRole = "pass-role"
+)
+
+// Potential is an edge that policy simulation shows is possible but that wasn't traversed by liquidswards, for
+// example because it would change the account like creating an access key does. Both principals are usually in the
+// graph, but unlike the edges added with AddEdge these aren't used to refresh credentials.
+type Potential struct {
+	// Kind is how the source can gain access to the target, one of the Potential* constants.
+	Kind string `json:"Kind"`
+
+	// Actions are the actions that were allowed by the simulation.
+	Actions []string `json:"Actions"`
+
+	// DiscoveredAt is when the edge was first found.
+	DiscoveredAt time.Time `json:"DiscoveredAt"`
+}
+
+// AddPotential records a potential edge from src to target, the actions are merged with any already recorded for it
+// of the same kind. False is returned if nothing new was recorded.
+func (g *Graph[T]) AddPotential(src, target string, p Potential) bool {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if g.potential == nil {
+		g.potential = map[string]map[string][]Potential{}
+	}
+	if g.potential[src] == nil {
+		g.potential[src] = map[string][]Potential{}
+	}
+
+	edges := g.potential[src][target]
+	for i, existing := range edges {
+		if existing.Kind != p.Kind {
+			continue
+		}
+
+		actions := union(existing.Actions, p.Actions)
+		if len(actions) == len(existing.Actions) {
+			return false
+		}
+		edges[i].Actions = actions
+		return true
+	}
+
+	p.Actions = union(nil, p.Actions)
+	g.potential[src][target] = append(edges, p)
+	return true
+}
+
+// PotentialEdges returns a copy of the potential edges keyed by the source and then target ARN, there is one for each
+// kind of edge between the two.
+func (g *Graph[T]) PotentialEdges() map[string]map[string][]Potential {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	resp := map[string]map[string][]Potential{}
+	for src, targets := range g.potential {
+		resp[src] = map[string][]Potential{}
+		for target, edges := range targets {
+			for _, p := range edges {
+				p.Actions = append([]string{}, p.Actions...)
+				resp[src][target] = append(resp[src][target], p)
+			}
+		}
+	}
+	return resp
+}
+
+// LoadPotential reads potential edges saved with SavePotential, they are merged with any already in the graph.
+func (g *Graph[T]) LoadPotential(path string) error {
+	var potential map[string]map[string][]Potential
+	if err := readJSON(path, &potential); err != nil {
+		return fmt.Errorf("LoadPotential(): %w", err)
+	}
+
+	for src, targets := range potential {
+		for target, edges := range targets {
+			for _, p := range edges {
+				g.AddPotential(src, target, p)
+			}
+		}
+	}
+	return nil
+}
+
+// SavePotential writes the potential edges to path.
+func (g *Graph[T]) SavePotential(path string) error {
+	if err := writeJSON(path, g.PotentialEdges()); err != nil {
+		return fmt.Errorf("SavePotential(): %w", err)
+	}
+	return nil
+}
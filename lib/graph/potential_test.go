@@ -0,0 +1,48 @@
+package graph
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGraph_AddPotential(t *testing.T) {
+	g := NewDirectedGraph[V]()
+	at := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	if !g.AddPotential("a", "b", Potential{Kind: PotentialCredentials, Actions: []string{"iam:CreateAccessKey"}, DiscoveredAt: at}) {
+		t.Error("expected the first edge to be recorded")
+	}
+	if g.AddPotential("a", "b", Potential{Kind: PotentialCredentials, Actions: []string{"iam:CreateAccessKey"}, DiscoveredAt: at.Add(time.Hour)}) {
+		t.Error("expected a duplicate edge to be ignored")
+	}
+	if !g.AddPotential("a", "b", Potential{Kind: PotentialCredentials, Actions: []string{"iam:CreateLoginProfile"}, DiscoveredAt: at.Add(time.Hour)}) {
+		t.Error("expected new actions to be recorded")
+	}
+	if !g.AddPotential("a", "b", Potential{Kind: PotentialPassRole, Actions: []string{"iam:PassRole"}, DiscoveredAt: at}) {
+		t.Error("expected an edge of another kind to be recorded")
+	}
+
+	want := map[string]map[string][]Potential{
+		"a": {"b": {
+			{Kind: PotentialCredentials, Actions: []string{"iam:CreateAccessKey", "iam:CreateLoginProfile"}, DiscoveredAt: at},
+			{Kind: PotentialPassRole, Actions: []string{"iam:PassRole"}, DiscoveredAt: at},
+		}},
+	}
+	if diff := cmp.Diff(g.PotentialEdges(), want); diff != "" {
+		t.Errorf("PotentialEdges() (-got +want):\n%s", diff)
+	}
+
+	path := filepath.Join(t.TempDir(), "potential.json")
+	if err := g.SavePotential(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewDirectedGraph[V]()
+	if err := loaded.LoadPotential(path); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(loaded.PotentialEdges(), want); diff != "" {
+		t.Errorf("LoadPotential() (-got +want):\n%s", diff)
+	}
+}
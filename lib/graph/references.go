@@ -0,0 +1,70 @@
+package graph
+
+import (
+	"fmt"
+)
+
+// Reference is a place a role ARN was found outside of iam:ListRoles, like the Lambda function using it.
+type Reference struct {
+	// Kind is the type of resource the ARN was found in, for example lambda-function or trust-policy.
+	Kind string `json:"Kind"`
+
+	// Source is the ARN of the resource, or a description of where in it the ARN was found.
+	Source string `json:"Source"`
+}
+
+func (r Reference) String() string {
+	return fmt.Sprintf("%s %s", r.Kind, r.Source)
+}
+
+// AddReference records that the role arn is referenced by ref, false is returned if it was already recorded.
+func (g *Graph[T]) AddReference(arn string, ref Reference) bool {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if g.references == nil {
+		g.references = map[string][]Reference{}
+	}
+	for _, r := range g.references[arn] {
+		if r == ref {
+			return false
+		}
+	}
+	g.references[arn] = append(g.references[arn], ref)
+	return true
+}
+
+// References returns a copy of the references recorded for each role keyed by the role ARN.
+func (g *Graph[T]) References() map[string][]Reference {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	resp := map[string][]Reference{}
+	for arn, refs := range g.references {
+		resp[arn] = append([]Reference{}, refs...)
+	}
+	return resp
+}
+
+// LoadReferences reads references saved with SaveReferences, they are merged with any already in the graph.
+func (g *Graph[T]) LoadReferences(path string) error {
+	var references map[string][]Reference
+	if err := readJSON(path, &references); err != nil {
+		return fmt.Errorf("LoadReferences(): %w", err)
+	}
+
+	for arn, refs := range references {
+		for _, ref := range refs {
+			g.AddReference(arn, ref)
+		}
+	}
+	return nil
+}
+
+// SaveReferences writes the references of each role to path.
+func (g *Graph[T]) SaveReferences(path string) error {
+	if err := writeJSON(path, g.References()); err != nil {
+		return fmt.Errorf("SaveReferences(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package graph
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"path/filepath"
+	"testing"
+)
+
+func TestGraph_AddReference(t *testing.T) {
+	g := NewDirectedGraph[V]()
+	lambda := Reference{Kind: "lambda-function", Source: "arn:aws:lambda:us-east-1:111111111111:function:f"}
+	trust := Reference{Kind: "trust-policy", Source: "arn:aws:iam::111111111111:role/b"}
+
+	if !g.AddReference("a", lambda) {
+		t.Error("expected the first reference to be recorded")
+	}
+	if g.AddReference("a", lambda) {
+		t.Error("expected a duplicate reference to be ignored")
+	}
+	g.AddReference("a", trust)
+
+	want := map[string][]Reference{"a": {lambda, trust}}
+	if diff := cmp.Diff(g.References(), want); diff != "" {
+		t.Errorf("References() (-got +want):\n%s", diff)
+	}
+
+	path := filepath.Join(t.TempDir(), "references.json")
+	if err := g.SaveReferences(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewDirectedGraph[V]()
+	if err := loaded.LoadReferences(path); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(loaded.References(), want); diff != "" {
+		t.Errorf("LoadReferences() (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,244 @@
+// Package org discovers the accounts in an AWS Organization along with the organizational unit each one is in.
+package org
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/smithy-go"
+	"strings"
+)
+
+// API is the part of the organizations client used to walk the organization, it is only usable from the management
+// account or a delegated administrator.
+type API interface {
+	organizations.ListRootsAPIClient
+	organizations.ListAccountsAPIClient
+	organizations.ListAccountsForParentAPIClient
+	organizations.ListOrganizationalUnitsForParentAPIClient
+	organizations.ListTagsForResourceAPIClient
+}
+
+// Account is a member account of the organization.
+type Account struct {
+	Id     string
+	Name   string
+	Email  string
+	Status string
+
+	// OU is the path of the organizational unit the account is in, made up of the names of the root and each OU below
+	// it, for example Root/Prod/Web. It is empty if the organization couldn't be walked.
+	OU string
+
+	// Tags are only fetched when requested since it takes an API call per account.
+	Tags map[string]string
+}
+
+// Active returns true if the account isn't suspended or being closed.
+func (a Account) Active() bool {
+	return a.Status == string(types.AccountStatusActive)
+}
+
+// Accounts walks the organization from the root and returns every account in it. If walking the organization is
+// denied the accounts are listed with organizations:ListAccounts instead, which some delegated administrators are only
+// allowed to call, and their OU is left empty. If tags is true the tags of each account are fetched as well.
+func Accounts(ctx context.Context, api API, tags bool) ([]Account, error) {
+	accounts, err := tree(ctx, api)
+	if denied(err) {
+		accounts, err = list(ctx, api)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Accounts(): %w", err)
+	}
+
+	if !tags {
+		return accounts, nil
+	}
+	for i, a := range accounts {
+		t, err := accountTags(ctx, api, a.Id)
+		if err != nil {
+			return nil, fmt.Errorf("Accounts(): %w", err)
+		}
+		accounts[i].Tags = t
+	}
+	return accounts, nil
+}
+
+// tree returns the accounts under each root of the organization.
+func tree(ctx context.Context, api API) ([]Account, error) {
+	var accounts []Account
+
+	roots := organizations.NewListRootsPaginator(api, &organizations.ListRootsInput{})
+	for roots.HasMorePages() {
+		page, err := roots.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, root := range page.Roots {
+			found, err := walk(ctx, api, aws.ToString(root.Id), aws.ToString(root.Name))
+			if err != nil {
+				return nil, err
+			}
+			accounts = append(accounts, found...)
+		}
+	}
+	return accounts, nil
+}
+
+// walk returns the accounts under the parent and recursively in each OU below it, path is the OU path of the parent.
+func walk(ctx context.Context, api API, parentId, path string) ([]Account, error) {
+	var accounts []Account
+
+	paginator := organizations.NewListAccountsForParentPaginator(api, &organizations.ListAccountsForParentInput{
+		ParentId: aws.String(parentId),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range page.Accounts {
+			accounts = append(accounts, newAccount(a, path))
+		}
+	}
+
+	ous := organizations.NewListOrganizationalUnitsForParentPaginator(api, &organizations.ListOrganizationalUnitsForParentInput{
+		ParentId: aws.String(parentId),
+	})
+	for ous.HasMorePages() {
+		page, err := ous.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, ou := range page.OrganizationalUnits {
+			found, err := walk(ctx, api, aws.ToString(ou.Id), path+"/"+aws.ToString(ou.Name))
+			if err != nil {
+				return nil, err
+			}
+			accounts = append(accounts, found...)
+		}
+	}
+	return accounts, nil
+}
+
+// list returns every account in the organization without walking it, so the OU of each is unknown.
+func list(ctx context.Context, api API) ([]Account, error) {
+	var accounts []Account
+
+	paginator := organizations.NewListAccountsPaginator(api, &organizations.ListAccountsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range page.Accounts {
+			accounts = append(accounts, newAccount(a, ""))
+		}
+	}
+	return accounts, nil
+}
+
+func newAccount(a types.Account, ou string) Account {
+	return Account{
+		Id:     aws.ToString(a.Id),
+		Name:   aws.ToString(a.Name),
+		Email:  aws.ToString(a.Email),
+		Status: string(a.Status),
+		OU:     ou,
+	}
+}
+
+// denied returns true if err is an AccessDenied error returned by the API.
+func denied(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && strings.HasPrefix(apiErr.ErrorCode(), "AccessDenied")
+}
+
+func accountTags(ctx context.Context, api API, id string) (map[string]string, error) {
+	tags := map[string]string{}
+	paginator := organizations.NewListTagsForResourcePaginator(api, &organizations.ListTagsForResourceInput{
+		ResourceId: aws.String(id),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range page.Tags {
+			tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+		}
+	}
+	return tags, nil
+}
+
+// tagPrefix marks a filter that matches account tags rather than the OU path.
+const tagPrefix = "tag:"
+
+// NewFilter parses the comma separated include and exclude filters. Each filter is either an OU path, which also
+// matches the OUs below it, or tag:<key>=<value> to match an account tag. Both may contain * and ? wildcards, and
+// tag:<key> on its own matches any account with the tag.
+func NewFilter(include, exclude string) Filter {
+	return Filter{
+		Include: utils.RemoveDefaults(utils.SplitCommas(include)),
+		Exclude: utils.RemoveDefaults(utils.SplitCommas(exclude)),
+	}
+}
+
+// Filter selects accounts by OU path or tag.
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+// Match returns true if the account matches one of the include filters, or there are none, and none of the exclude
+// filters.
+func (f Filter) Match(a Account) bool {
+	if len(f.Include) != 0 && !matchAny(f.Include, a) {
+		return false
+	}
+	return !matchAny(f.Exclude, a)
+}
+
+// NeedsTags returns true if any of the filters match on tags.
+func (f Filter) NeedsTags() bool {
+	for _, filter := range append(f.Include, f.Exclude...) {
+		if strings.HasPrefix(filter, tagPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(filters []string, a Account) bool {
+	for _, filter := range filters {
+		if match(filter, a) {
+			return true
+		}
+	}
+	return false
+}
+
+func match(filter string, a Account) bool {
+	if tag, ok := strings.CutPrefix(filter, tagPrefix); ok {
+		key, value, hasValue := strings.Cut(tag, "=")
+		v, ok := a.Tags[key]
+		return ok && (!hasValue || utils.WildcardMatchCase(value, v))
+	}
+
+	// Check the OU and each parent so Root/Prod matches accounts in Root/Prod/Web.
+	for path := a.OU; path != ""; {
+		if utils.WildcardMatch(strings.Trim(filter, "/"), path) {
+			return true
+		}
+		i := strings.LastIndex(path, "/")
+		if i == -1 {
+			break
+		}
+		path = path[:i]
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package org
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+// fakeAPI is an organization with the root r-root, the OUs in ous and accounts keyed by the ID of their parent. If
+// walkDenied is set listing the roots fails with AccessDenied, like it does for principals only allowed ListAccounts.
+type fakeAPI struct {
+	ous        map[string][]types.OrganizationalUnit
+	accounts   map[string][]types.Account
+	tags       map[string][]types.Tag
+	walkDenied bool
+}
+
+func (f fakeAPI) ListRoots(context.Context, *organizations.ListRootsInput, ...func(*organizations.Options)) (*organizations.ListRootsOutput, error) {
+	if f.walkDenied {
+		return nil, &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized to perform: organizations:ListRoots"}
+	}
+	return &organizations.ListRootsOutput{
+		Roots: []types.Root{{Id: aws.String("r-root"), Name: aws.String("Root")}},
+	}, nil
+}
+
+func (f fakeAPI) ListAccounts(context.Context, *organizations.ListAccountsInput, ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error) {
+	var accounts []types.Account
+	for _, parent := range []string{"r-root", "ou-prod", "ou-web"} {
+		accounts = append(accounts, f.accounts[parent]...)
+	}
+	return &organizations.ListAccountsOutput{Accounts: accounts}, nil
+}
+
+func (f fakeAPI) ListAccountsForParent(_ context.Context, in *organizations.ListAccountsForParentInput, _ ...func(*organizations.Options)) (*organizations.ListAccountsForParentOutput, error) {
+	return &organizations.ListAccountsForParentOutput{Accounts: f.accounts[*in.ParentId]}, nil
+}
+
+func (f fakeAPI) ListOrganizationalUnitsForParent(_ context.Context, in *organizations.ListOrganizationalUnitsForParentInput, _ ...func(*organizations.Options)) (*organizations.ListOrganizationalUnitsForParentOutput, error) {
+	return &organizations.ListOrganizationalUnitsForParentOutput{OrganizationalUnits: f.ous[*in.ParentId]}, nil
+}
+
+func (f fakeAPI) ListTagsForResource(_ context.Context, in *organizations.ListTagsForResourceInput, _ ...func(*organizations.Options)) (*organizations.ListTagsForResourceOutput, error) {
+	return &organizations.ListTagsForResourceOutput{Tags: f.tags[*in.ResourceId]}, nil
+}
+
+func account(id, name string, status types.AccountStatus) types.Account {
+	return types.Account{Id: aws.String(id), Name: aws.String(name), Status: status}
+}
+
+var testAPI = fakeAPI{
+	ous: map[string][]types.OrganizationalUnit{
+		"r-root":  {{Id: aws.String("ou-prod"), Name: aws.String("Prod")}},
+		"ou-prod": {{Id: aws.String("ou-web"), Name: aws.String("Web")}},
+	},
+	accounts: map[string][]types.Account{
+		"r-root":  {account("111111111111", "management", types.AccountStatusActive)},
+		"ou-prod": {account("222222222222", "prod", types.AccountStatusActive)},
+		"ou-web":  {account("333333333333", "web", types.AccountStatusSuspended)},
+	},
+	tags: map[string][]types.Tag{
+		"222222222222": {{Key: aws.String("env"), Value: aws.String("production")}},
+	},
+}
+
+func TestAccounts(t *testing.T) {
+	got, err := Accounts(context.Background(), testAPI, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Account{
+		{Id: "111111111111", Name: "management", Status: "ACTIVE", OU: "Root", Tags: map[string]string{}},
+		{Id: "222222222222", Name: "prod", Status: "ACTIVE", OU: "Root/Prod", Tags: map[string]string{"env": "production"}},
+		{Id: "333333333333", Name: "web", Status: "SUSPENDED", OU: "Root/Prod/Web", Tags: map[string]string{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Accounts() (-got +want):\n%s", diff)
+	}
+	if got[2].Active() {
+		t.Error("expected suspended account to not be active")
+	}
+}
+
+// TestAccounts_ListAccounts ensures the accounts are still found without their OU when walking the organization is
+// denied.
+func TestAccounts_ListAccounts(t *testing.T) {
+	api := testAPI
+	api.walkDenied = true
+
+	got, err := Accounts(context.Background(), api, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Account{
+		{Id: "111111111111", Name: "management", Status: "ACTIVE"},
+		{Id: "222222222222", Name: "prod", Status: "ACTIVE"},
+		{Id: "333333333333", Name: "web", Status: "SUSPENDED"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Accounts() (-got +want):\n%s", diff)
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	prod := Account{Id: "222222222222", OU: "Root/Prod", Tags: map[string]string{"env": "production"}}
+	web := Account{Id: "333333333333", OU: "Root/Prod/Web"}
+	sandbox := Account{Id: "444444444444", OU: "Root/Sandbox", Tags: map[string]string{"env": "dev"}}
+
+	tests := []struct {
+		name             string
+		include, exclude string
+		want             []bool
+	}{
+		{name: "no filters", want: []bool{true, true, true}},
+		{name: "ou includes children", include: "Root/Prod", want: []bool{true, true, false}},
+		{name: "ou is case insensitive", include: "root/prod/", want: []bool{true, true, false}},
+		{name: "ou wildcard", include: "Root/*/Web", want: []bool{false, true, false}},
+		{name: "exclude ou", exclude: "Root/Prod/Web", want: []bool{true, false, true}},
+		{name: "tag value", include: "tag:env=prod*", want: []bool{true, false, false}},
+		{name: "tag key", include: "tag:env", want: []bool{true, false, true}},
+		{name: "include and exclude", include: "Root", exclude: "tag:env=dev, Root/Prod/Web", want: []bool{true, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFilter(tt.include, tt.exclude)
+			var got []bool
+			for _, a := range []Account{prod, web, sandbox} {
+				got = append(got, f.Match(a))
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("Match() (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFilter_NeedsTags(t *testing.T) {
+	if NewFilter("Root/Prod", "").NeedsTags() {
+		t.Error("expected OU filters to not need tags")
+	}
+	if !NewFilter("Root/Prod", "tag:env=dev").NeedsTags() {
+		t.Error("expected tag filters to need tags")
+	}
+}
@@ -7,22 +7,62 @@ import (
 )
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"os"
+	"sort"
 	"strings"
 )
 
 var noAssume = flag.Bool("no-assume", false, "do not attempt to assume discovered roles")
+var externalIdsFile = flag.String("external-ids", "", `
+JSON file mapping role ARN patterns to a list of candidate sts:ExternalId values, for example:
+
+  {"arn:aws:iam::*:role/vendor-*": ["id-1", "id-2"]}
+
+Candidates are tried in order along with any external ID found in the role's trust policy, the one that worked is
+saved in the graph and used when refreshing credentials.
+`)
 
 func NewAssume(ctx utils.Context, args types.GlobalPluginArgs) types.Plugin {
+	externalIds, err := LoadExternalIds(*externalIdsFile)
+	if err != nil {
+		ctx.Error.Fatalf("assume: %s", err)
+	}
+
 	return &Assume{
 		GlobalPluginArgs: args,
+		ExternalIds:      externalIds,
+	}
+}
+
+// LoadExternalIds reads the file passed to -external-ids, an empty path returns an empty map.
+func LoadExternalIds(path string) (map[string][]string, error) {
+	ids := map[string][]string{}
+	if path == "" {
+		return ids, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadExternalIds(): %w", err)
+	}
+	if err := json.Unmarshal(b, &ids); err != nil {
+		return nil, fmt.Errorf("LoadExternalIds(): parsing %s: %w", path, err)
 	}
+	return ids, nil
 }
 
 type Assume struct {
 	types.GlobalPluginArgs
 
+	// ExternalIds maps role ARN patterns to candidate external IDs.
+	ExternalIds map[string][]string
+
 	// For mocking assumeRole which gets set in Register
 	AssumeRole func(ctx utils.Context, cfg *creds.Config, role types.Role)
 }
@@ -47,28 +87,96 @@ func (a *Assume) Run(ctx utils.Context) {
 			return
 		}
 
+		// Each pair is tested as a job on the engine, STS throttles per account so jobs are limited by the account of
+		// the principal making the call.
 		a.FoundRoles.Walk(func(role types.Role) {
-			ctx.Debug.Printf("assume: testing: %s -> %s", cfg.Id(), role.Id())
-			if ctx.IsDone("Finished assuming Items, exiting...") {
-				return
-			}
+			a.Engine.Submit(cfg.Account(), func() { a.test(ctx, cfg, role) })
+		})
+	})
+}
+
+// test attempts to assume role from cfg, the new access is added to Access if it works.
+func (a *Assume) test(ctx utils.Context, cfg *creds.Config, role types.Role) {
+	ctx.Debug.Printf("assume: testing: %s -> %s", cfg.Id(), role.Id())
+	if ctx.IsDone("Finished assuming Items, exiting...") {
+		return
+	}
+
+	verifyScope(a.Scope, *role.Arn)
+
+	// Skip pairs that were definitively denied in the scan we're resuming. Allowed pairs and ones that failed for
+	// other reasons, like throttling, are tested again.
+	if entry, ok := a.Checkpoint.Tested(cfg.Id(), role.Id()); ok && entry.Denial != nil && creds.Definitive(*entry.Denial) {
+		ctx.Debug.Printf("assume: skipping previously denied: %s -> %s", cfg.Id(), role.Id())
+		a.Graph.AddDenial(cfg, role.Id(), *entry.Denial)
+		return
+	}
 
-			verifyScope(a.Scope, *role.Arn)
+	externalIds := a.externalIds(role)
+	newCfg, err := cfg.Assume(ctx, *role.Arn, externalIds)
+	if err != nil {
+		ctx.Debug.Println(err)
 
-			newCfg, err := cfg.Assume(ctx, *role.Arn)
-			if err != nil {
-				ctx.Debug.Println(err)
-				return
+		// The denial is built from this attempt, the graph may hold one from a previous scan when -load is used.
+		// Assume tries the external IDs in order, so the error is from the last one.
+		if ctx.Err() == nil {
+			in := &sts.AssumeRoleInput{RoleArn: role.Arn}
+			if len(externalIds) != 0 {
+				in.ExternalId = aws.String(externalIds[len(externalIds)-1])
 			}
+			denial := creds.NewDenial(err, in)
+			a.checkpoint(ctx, cfg.Id(), role.Id(), &denial)
+		}
+		return
+	}
+	a.checkpoint(ctx, cfg.Id(), role.Id(), nil)
 
-			a.Access.Add(newCfg)
-			ctx.Info.Println(strings.Join(newCfg.IdentityPath(), utils.Arrow))
-		})
-	})
+	a.Access.Add(newCfg)
+	ctx.Info.Println(strings.Join(newCfg.IdentityPath(), utils.Arrow))
+}
+
+// externalIds returns the candidate external IDs for role, from its trust policy first followed by the ones passed
+// with -external-ids.
+func (a *Assume) externalIds(role types.Role) []string {
+	var ids []string
+	seen := map[string]bool{}
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range role.ExternalIDs() {
+		add(id)
+	}
+
+	// Sort the patterns so candidates are tried in the same order on every run.
+	var patterns []string
+	for pattern := range a.ExternalIds {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		if !utils.WildcardMatch(pattern, *role.Arn) {
+			continue
+		}
+		for _, id := range a.ExternalIds[pattern] {
+			add(id)
+		}
+	}
+	return ids
+}
+
+func (a *Assume) checkpoint(ctx utils.Context, source, target string, denial *graph.Denial) {
+	if err := a.Checkpoint.AddTested(source, target, denial); err != nil {
+		ctx.Error.Println("assume:", err)
+	}
 }
 
-func verifyScope(scope []string, arn string) {
-	if scope != nil && !utils.ArnInScope(scope, arn) {
+func verifyScope(scope *utils.Scope, arn string) {
+	if !scope.Contains(arn) {
 		// Senders should check if the ARN is in scope, exit to avoid traversing into out of scope accounts.
 		panic(fmt.Errorf("assume roles: exiting due out of scope arn (this is a bug): %s\n", arn))
 	}
@@ -0,0 +1,74 @@
+package plugins
+
+import (
+	"github.com/RyanJarv/liquidswards/lib/checkpoint"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestAssume_Resume ensures only definitive denials from a previous scan are skipped, and that the denial saved to the
+// checkpoint is from the current attempt rather than one already in the graph.
+func TestAssume_Resume(t *testing.T) {
+	const (
+		denied    = "arn:aws:iam::123456789012:role/denied"
+		throttled = "arn:aws:iam::123456789012:role/throttled"
+		unknown   = "arn:aws:iam::123456789012:role/unknown"
+	)
+
+	g := graph.NewDirectedGraph[*creds.Config]()
+	cfg, client := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/source", g))
+	g.AddNode(cfg)
+
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	previous := utils.Must(checkpoint.Open(path, false))
+	for arn, denial := range map[string]graph.Denial{
+		denied:    {Code: "AccessDenied", Reason: creds.ReasonAccessDenied, Time: time.Now()},
+		throttled: {Code: "Throttling", Reason: creds.ReasonThrottled, Time: time.Now()},
+		unknown:   {Reason: creds.ReasonUnknown, Time: time.Now()},
+	} {
+		utils.Must0(previous.AddTested(cfg.Id(), arn, &denial))
+	}
+	utils.Must0(previous.Close())
+
+	cp := utils.Must(checkpoint.Open(path, true))
+	defer cp.Close()
+
+	a := &Assume{GlobalPluginArgs: types.GlobalPluginArgs{
+		Access:     utils.NewIterator[*creds.Config](),
+		FoundRoles: utils.NewIterator[types.Role](),
+		Graph:      g,
+		Scope:      utils.NewScope([]string{testAccountId}),
+		Checkpoint: cp,
+	}}
+
+	// The throttled role is still throttled, but the graph has a stale denial for it from a loaded scan.
+	client.Errors = map[string]error{throttled: &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}}
+	g.AddDenial(cfg, throttled, graph.Denial{Code: "AccessDenied", Reason: creds.ReasonAccessDenied})
+
+	for _, arn := range []string{denied, throttled, unknown} {
+		a.test(ctx, cfg, types.NewRole(arn))
+	}
+
+	var got []string
+	for _, call := range client.Calls {
+		got = append(got, aws.ToString(call.RoleArn))
+	}
+	if diff := cmp.Diff(got, []string{throttled, unknown}); diff != "" {
+		t.Errorf("AssumeRole() calls (-got +want):\n%s", diff)
+	}
+
+	if entry, _ := cp.Tested(cfg.Id(), throttled); entry.Denial == nil || entry.Denial.Reason != creds.ReasonThrottled {
+		t.Errorf("got checkpoint entry %+v, want a throttled denial", entry)
+	}
+	if entry, _ := cp.Tested(cfg.Id(), unknown); !entry.Allowed {
+		t.Errorf("got checkpoint entry %+v, want allowed", entry)
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"flag"
 	"fmt"
 	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/trail"
 	"github.com/RyanJarv/liquidswards/lib/types"
 	"github.com/RyanJarv/liquidswards/lib/utils"
 	"github.com/alitto/pond"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
 	cloudtrailTypes "github.com/aws/aws-sdk-go-v2/service/cloudtrail/types"
-	"regexp"
+	"strings"
 	"sync"
 	"time"
 )
@@ -23,8 +25,6 @@ discover roles that are assumed by other users.
 const MaxWorkers = 3
 const MaxCapacity = MaxWorkers * 1000
 
-var roleArnRe = regexp.MustCompile(`arn:aws:iam::[0-9]{12}:(role|assumed-role)/[-a-zA-Z_0-9+=,.@_/]+`)
-
 func NewCloudTrail(ctx utils.Context, args types.GlobalPluginArgs) types.Plugin {
 	pool := pond.New(MaxWorkers, MaxCapacity, pond.Strategy(pond.Lazy()))
 
@@ -63,6 +63,9 @@ func (a *CloudTrail) Enabled() (enabled bool, reason string) {
 
 func (a *CloudTrail) Run(ctx utils.Context) {
 	for _, node := range a.Graph.Nodes() {
+		if node.Value().Identity.Type == creds.SourceUser {
+			continue
+		}
 		a.run(ctx, node.Value())
 	}
 }
@@ -122,16 +125,41 @@ func (a *CloudTrail) searchCloudTrail(ctx utils.Context, cfg *creds.Config, star
 			panic(err)
 		}
 		for _, event := range page.Events {
-			all := roleArnRe.FindAll([]byte(*event.CloudTrailEvent), -1)
-			for _, role := range all {
-				arn := string(role)
-				if a.Scope != nil && !utils.ArnInScope(a.Scope, arn) {
-					continue
-				}
-				if a.FoundRoles.Add(types.NewRole(arn)) {
-					ctx.Debug.Println("CloudTrail: Found role:", arn)
-				}
+			e, ok, err := trail.ParseEvent([]byte(aws.ToString(event.CloudTrailEvent)))
+			if err != nil {
+				ctx.Error.Printf("cloudtrail: %s\n", err)
+				continue
+			} else if ok {
+				observe(ctx, a.GlobalPluginArgs, "CloudTrail", e)
 			}
 		}
 	}
 }
+
+// observe adds the role that was assumed, and the source principal if it is a role, to the discovered roles. If the
+// call succeeded it is also recorded as an observed edge in the graph.
+func observe(ctx utils.Context, args types.GlobalPluginArgs, name string, e trail.Event) {
+	if !args.Scope.Contains(e.Target) && !args.Scope.Contains(e.Source) {
+		return
+	}
+
+	for _, arn := range []string{e.Target, e.Source} {
+		if !strings.Contains(arn, ":role/") || !args.Scope.Contains(arn) {
+			continue
+		}
+		if args.FoundRoles.Add(types.NewRole(arn)) {
+			ctx.Debug.Printf("%s: Found role: %s\n", name, arn)
+		}
+	}
+
+	if e.ErrorCode != "" || e.Source == "" {
+		return
+	}
+	args.Graph.Observe(e.Id, e.Source, e.Target, graph.Observation{
+		FirstSeen:        e.Time,
+		LastSeen:         e.Time,
+		Count:            1,
+		ExternalIds:      []string{e.ExternalId},
+		SourceIdentities: []string{e.SourceIdentity},
+	})
+}
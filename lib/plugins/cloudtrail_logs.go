@@ -0,0 +1,117 @@
+package plugins
+
+import (
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/trail"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	cloudtrailLogs = flag.String("cloudtrail-logs", "", `
+Search CloudTrail log files for sts:AssumeRole events, either in the bucket a trail delivers to (s3://<bucket>/<prefix>) 
+or a local directory of downloaded logs. Unlike -cloudtrail this covers every region and isn't limited to the last 90 
+days. The bucket is read with the first profile passed to -profiles.
+`)
+	cloudtrailLogsStart = flag.String("cloudtrail-logs-start", "", `
+Only search logs from this time onwards when using -cloudtrail-logs, as a date (2006-01-02) or RFC3339 timestamp.
+`)
+	cloudtrailLogsEnd = flag.String("cloudtrail-logs-end", "", `
+Only search logs before this time when using -cloudtrail-logs, as a date (2006-01-02) or RFC3339 timestamp.
+`)
+)
+
+func NewCloudTrailLogs(ctx utils.Context, args types.GlobalPluginArgs) types.Plugin {
+	return &CloudTrailLogs{
+		Context:          ctx,
+		GlobalPluginArgs: args,
+		WaitGroup:        &sync.WaitGroup{},
+		Location:         *cloudtrailLogs,
+	}
+}
+
+// CloudTrailLogs discovers roles from the sts:AssumeRole events in CloudTrail log files.
+type CloudTrailLogs struct {
+	*sync.WaitGroup
+	utils.Context
+	types.GlobalPluginArgs
+	Location string
+}
+
+func (c *CloudTrailLogs) Name() string {
+	return "cloudtrail-logs"
+}
+
+func (c *CloudTrailLogs) Enabled() (bool, string) {
+	if c.Location == "" {
+		return false, "pass an S3 location or directory with the -cloudtrail-logs flag to enable"
+	}
+	return true, fmt.Sprintf("searching cloudtrail logs in %s for additional in-scope roles", c.Location)
+}
+
+func (c *CloudTrailLogs) Run(ctx utils.Context) {
+	r, err := parseTimeRange(*cloudtrailLogsStart, *cloudtrailLogsEnd)
+	if err != nil {
+		ctx.Error.Printf("cloudtrail-logs: %s\n", err)
+		return
+	}
+
+	src, err := trail.NewSource(c.Location, c.PrimaryAwsConfig)
+	if err != nil {
+		ctx.Error.Printf("cloudtrail-logs: %s\n", err)
+		return
+	}
+
+	c.WaitGroup.Add(1)
+	go func() {
+		defer c.WaitGroup.Done()
+		utils.SetDebugLabels("plugins", "cloudtrail-logs")
+
+		files := 0
+		err := src.Walk(ctx, r, func(name string, body io.Reader) error {
+			files++
+			ctx.Debug.Println("cloudtrail-logs: reading", name)
+			if err := trail.Parse(body, func(e trail.Event) { c.found(ctx, r, e) }); err != nil {
+				ctx.Error.Printf("cloudtrail-logs: %s: %s\n", name, err)
+			}
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			ctx.Error.Printf("cloudtrail-logs: %s\n", err)
+		}
+		ctx.Info.Printf("cloudtrail-logs: finished searching %d log files\n", files)
+	}()
+}
+
+// found passes events in the time range to observe.
+func (c *CloudTrailLogs) found(ctx utils.Context, r trail.TimeRange, e trail.Event) {
+	if r.Contains(e.Time) {
+		observe(ctx, c.GlobalPluginArgs, "CloudTrail logs", e)
+	}
+}
+
+// parseTimeRange parses the -cloudtrail-logs-start and -cloudtrail-logs-end flags.
+func parseTimeRange(start, end string) (trail.TimeRange, error) {
+	var r trail.TimeRange
+	for _, v := range []struct {
+		s string
+		t *time.Time
+	}{{start, &r.Start}, {end, &r.End}} {
+		if v.s == "" {
+			continue
+		}
+
+		var err error
+		if *v.t, err = time.Parse(time.RFC3339, v.s); err == nil {
+			continue
+		}
+		if *v.t, err = time.Parse("2006-01-02", v.s); err != nil {
+			return r, fmt.Errorf("invalid time %s, expected a date (2006-01-02) or RFC3339 timestamp", v.s)
+		}
+	}
+	return r, nil
+}
@@ -21,6 +21,7 @@ type NewFilePluginInput struct {
 func NewFile(_ utils.Context, in types.GlobalPluginArgs) types.Plugin {
 	return &FilePlugin{
 		GlobalPluginArgs: in,
+		FileLocation:     *file,
 		m:                &sync.RWMutex{},
 		covered:          map[string]bool{},
 	}
@@ -56,7 +57,7 @@ func (f *FilePlugin) Run(ctx utils.Context) {
 
 	for _, line := range strings.Split(string(file), "\n") {
 		arn := strings.Trim(line, " \t")
-		if f.Scope != nil && !utils.ArnInScope(f.Scope, arn) {
+		if !f.Scope.Contains(arn) {
 			continue
 		}
 
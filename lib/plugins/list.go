@@ -43,7 +43,7 @@ func (l *List) Run(ctx utils.Context) {
 		l.accountMap.Store(cfg.Account(), 1)
 
 		if err := ForEachRole(ctx, cfg.Config, func(r types.Role) {
-			if l.Scope != nil && !utils.ArnInScope(l.Scope, *r.Arn) {
+			if !l.Scope.Contains(*r.Arn) {
 				ctx.Debug.Println("not in scope, skipping:", *r.Arn)
 				return
 			}
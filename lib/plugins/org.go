@@ -0,0 +1,129 @@
+package plugins
+
+import (
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/org"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"sync"
+)
+
+var (
+	orgDiscover = flag.Bool("org", false, `
+Discover the member accounts of the AWS Organization with organizations:ListAccountsForParent from any principal that
+has access, this is usually only possible from the management account or a delegated administrator. If only
+organizations:ListAccounts is allowed the accounts are found without their OU paths. Discovered accounts are added to
+the scope and the roles in -org-roles are tried in each of them.
+`)
+	orgInclude = flag.String("org-include", "", `
+Only add organization accounts matching one of these filters (seperated by commas) to the scope when using -org. A
+filter is either an OU path like Root/Prod, which also matches the OUs below it, or tag:<key>=<value> to match on an
+account tag. Both may contain * and ? wildcards.
+`)
+	orgExclude = flag.String("org-exclude", "", `
+Do not add organization accounts matching one of these filters (seperated by commas) to the scope when using -org,
+see -org-include for the format.
+`)
+	orgRoles = flag.String("org-roles", "OrganizationAccountAccessRole", `
+Names of roles (seperated by commas) to try in each account discovered with -org.
+`)
+)
+
+func NewOrg(_ utils.Context, args types.GlobalPluginArgs) types.Plugin {
+	return &Org{
+		GlobalPluginArgs: args,
+		Filter:           org.NewFilter(*orgInclude, *orgExclude),
+		Roles:            utils.RemoveDefaults(utils.SplitCommas(*orgRoles)),
+		NewAPI: func(cfg *creds.Config) org.API {
+			return organizations.NewFromConfig(cfg.Config)
+		},
+		m:     &sync.Mutex{},
+		tried: map[string]bool{},
+	}
+}
+
+// Org discovers the accounts in the organization, adds them to the scope and seeds the roles in Roles for each of
+// them.
+type Org struct {
+	types.GlobalPluginArgs
+	Filter org.Filter
+
+	// Roles are the names of the roles to try in each account that is in scope.
+	Roles []string
+
+	// NewAPI returns the organizations client used with cfg, it is replaced when testing.
+	NewAPI func(cfg *creds.Config) org.API
+
+	m     *sync.Mutex
+	tried map[string]bool
+	done  bool
+}
+
+func (o *Org) Name() string { return "org" }
+func (o *Org) Enabled() (bool, string) {
+	if !*orgDiscover {
+		return false, "pass -org to discover accounts with AWS Organizations"
+	}
+	return true, "discovering accounts with organizations:ListAccountsForParent"
+}
+
+func (o *Org) Run(ctx utils.Context) {
+	o.Access.Walk(func(cfg *creds.Config) {
+		// The organization only needs to be listed once, but we don't know which principals can do it so each one is
+		// tried until it succeeds.
+		o.m.Lock()
+		if o.done || o.tried[cfg.Arn()] {
+			o.m.Unlock()
+			return
+		}
+		o.m.Unlock()
+
+		accounts, err := org.Accounts(ctx, o.NewAPI(cfg), o.Filter.NeedsTags())
+		if err != nil {
+			ctx.Debug.Printf("org: unable to list accounts with %s: %s\n", cfg.Arn(), err)
+			return
+		}
+
+		o.m.Lock()
+		o.tried[cfg.Arn()] = true
+		if o.done {
+			o.m.Unlock()
+			return
+		}
+		o.done = true
+		o.m.Unlock()
+
+		ctx.Info.Printf("org: found %d accounts in the organization with %s\n", len(accounts), cfg.Arn())
+		o.add(ctx, utils.PartitionFromArn(cfg.Arn()), accounts)
+	})
+}
+
+// add records the OU of every account in the graph, then adds the active accounts that match the filter to the scope
+// and the candidate roles in them to FoundRoles. The roles are in the same partition as the organization.
+func (o *Org) add(ctx utils.Context, partition string, accounts []org.Account) {
+	for _, a := range accounts {
+		o.Graph.SetAccount(a.Id, graph.Account{Name: a.Name, Email: a.Email, OU: a.OU, Tags: a.Tags})
+	}
+
+	for _, a := range accounts {
+		if !a.Active() || !o.Filter.Match(a) {
+			ctx.Debug.Printf("org: skipping account %s (%s) in %s\n", a.Id, a.Name, a.OU)
+			continue
+		}
+
+		if o.Scope.Add(a.Id) {
+			ctx.Info.Printf("org: added account %s (%s) in %s to the scope\n", a.Id, a.Name, a.OU)
+		}
+
+		for _, name := range o.Roles {
+			arn := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, a.Id, name)
+			if o.FoundRoles.Add(types.NewRole(arn)) {
+				ctx.Debug.Println("org: found:", arn)
+			}
+		}
+	}
+}
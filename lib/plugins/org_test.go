@@ -0,0 +1,137 @@
+package plugins
+
+import (
+	"context"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/org"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgTypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// fakeOrgAPI is an organization with a single account under the root, every call fails with AccessDenied if denied
+// is set. The number of ListRoots calls is counted in calls.
+type fakeOrgAPI struct {
+	denied bool
+	calls  *int
+}
+
+func (f fakeOrgAPI) err(op string) error {
+	return &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized to perform: organizations:" + op}
+}
+
+func (f fakeOrgAPI) ListRoots(context.Context, *organizations.ListRootsInput, ...func(*organizations.Options)) (*organizations.ListRootsOutput, error) {
+	*f.calls++
+	if f.denied {
+		return nil, f.err("ListRoots")
+	}
+	return &organizations.ListRootsOutput{Roots: []orgTypes.Root{{Id: aws.String("r-root"), Name: aws.String("Root")}}}, nil
+}
+
+func (f fakeOrgAPI) ListAccounts(context.Context, *organizations.ListAccountsInput, ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error) {
+	return nil, f.err("ListAccounts")
+}
+
+func (f fakeOrgAPI) ListAccountsForParent(context.Context, *organizations.ListAccountsForParentInput, ...func(*organizations.Options)) (*organizations.ListAccountsForParentOutput, error) {
+	return &organizations.ListAccountsForParentOutput{Accounts: []orgTypes.Account{
+		{Id: aws.String("222222222222"), Name: aws.String("prod"), Status: orgTypes.AccountStatusActive},
+	}}, nil
+}
+
+func (f fakeOrgAPI) ListOrganizationalUnitsForParent(context.Context, *organizations.ListOrganizationalUnitsForParentInput, ...func(*organizations.Options)) (*organizations.ListOrganizationalUnitsForParentOutput, error) {
+	return &organizations.ListOrganizationalUnitsForParentOutput{}, nil
+}
+
+func (f fakeOrgAPI) ListTagsForResource(context.Context, *organizations.ListTagsForResourceInput, ...func(*organizations.Options)) (*organizations.ListTagsForResourceOutput, error) {
+	return &organizations.ListTagsForResourceOutput{}, nil
+}
+
+func newTestOrg(filter org.Filter, roles ...string) *Org {
+	return &Org{
+		GlobalPluginArgs: types.GlobalPluginArgs{
+			Graph:      graph.NewDirectedGraph[*creds.Config](),
+			Access:     utils.NewIterator[*creds.Config](),
+			FoundRoles: utils.NewIterator[types.Role](),
+			Scope:      utils.NewScope([]string{testAccountId}),
+		},
+		Filter: filter,
+		Roles:  roles,
+		m:      &sync.Mutex{},
+		tried:  map[string]bool{},
+	}
+}
+
+func orgFoundRoles(o *Org) []string {
+	var resp []string
+	o.FoundRoles.Walk(func(role types.Role) {
+		resp = append(resp, role.Id())
+	})
+	sort.Strings(resp)
+	return resp
+}
+
+// TestOrg_Add ensures every account is recorded in the graph, while only the active accounts matching the filter are
+// added to the scope and seeded with roles in the partition of the organization.
+func TestOrg_Add(t *testing.T) {
+	accounts := []org.Account{
+		{Id: "222222222222", Name: "prod", Status: "ACTIVE", OU: "Root/Prod"},
+		{Id: "333333333333", Name: "web", Status: "ACTIVE", OU: "Root/Prod/Web"},
+		{Id: "444444444444", Name: "closed", Status: "SUSPENDED", OU: "Root/Prod"},
+		{Id: "555555555555", Name: "sandbox", Status: "ACTIVE", OU: "Root/Sandbox"},
+	}
+
+	o := newTestOrg(org.NewFilter("Root/Prod", "Root/Prod/Web"), "Admin", "OrganizationAccountAccessRole")
+	o.add(ctx, "aws-us-gov", accounts)
+
+	for _, a := range accounts {
+		got, ok := o.Graph.GetAccount(a.Id)
+		if !ok {
+			t.Errorf("expected %s to be added to the graph", a.Id)
+		} else if got.OU != a.OU {
+			t.Errorf("got OU %s for %s, want %s", got.OU, a.Id, a.OU)
+		}
+	}
+
+	if diff := cmp.Diff(o.Scope.Accounts(), []string{testAccountId, "222222222222"}); diff != "" {
+		t.Errorf("Scope (-got +want):\n%s", diff)
+	}
+
+	want := []string{
+		"arn:aws-us-gov:iam::222222222222:role/Admin",
+		"arn:aws-us-gov:iam::222222222222:role/OrganizationAccountAccessRole",
+	}
+	if diff := cmp.Diff(orgFoundRoles(o), want); diff != "" {
+		t.Errorf("FoundRoles (-got +want):\n%s", diff)
+	}
+}
+
+// TestOrg_Run ensures principals that fail to list the organization don't stop the next ones from trying, and that it
+// is only listed once.
+func TestOrg_Run(t *testing.T) {
+	var calls int
+	o := newTestOrg(org.NewFilter("", ""), "Admin")
+	o.NewAPI = func(cfg *creds.Config) org.API {
+		return fakeOrgAPI{denied: cfg.Arn() == "arn:aws:iam::123456789012:user/denied", calls: &calls}
+	}
+	o.Run(ctx)
+
+	for _, name := range []string{"user/denied", "user/admin", "user/other"} {
+		cfg, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, name, nil))
+		o.Access.Add(cfg)
+	}
+
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2", calls)
+	}
+	if diff := cmp.Diff(orgFoundRoles(o), []string{"arn:aws:iam::222222222222:role/Admin"}); diff != "" {
+		t.Errorf("FoundRoles (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,177 @@
+package plugins
+
+import (
+	"flag"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/policy"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"sort"
+	"sync"
+	"time"
+)
+
+var passRole = flag.Bool("pass-role", false, `
+Use iam:SimulatePrincipalPolicy to find roles each accessed principal could pass to a compute service it can create
+resources in, for example with lambda:CreateFunction. Nothing is created, these are shown as pass-role edges in the
+report.
+`)
+
+// PassRoleActions map the actions that run code as a passed role to the service principal the role needs to trust.
+var PassRoleActions = map[string]string{
+	"lambda:CreateFunction":      "lambda.amazonaws.com",
+	"ec2:RunInstances":           "ec2.amazonaws.com",
+	"ecs:RunTask":                "ecs-tasks.amazonaws.com",
+	"glue:CreateDevEndpoint":     "glue.amazonaws.com",
+	"cloudformation:CreateStack": "cloudformation.amazonaws.com",
+}
+
+const actionPassRole = "iam:PassRole"
+
+func NewPassRole(_ utils.Context, args types.GlobalPluginArgs) types.Plugin {
+	return &PassRole{
+		GlobalPluginArgs: args,
+		m:                &sync.Mutex{},
+		sources:          map[string][]passRoleSource{},
+		roles:            map[string][]types.Role{},
+	}
+}
+
+// PassRole records potential edges from accessed principals to the roles they could pass to a compute service.
+type PassRole struct {
+	types.GlobalPluginArgs
+
+	m *sync.Mutex
+
+	// sources and roles are keyed by account, roles can only be passed to services in the same account so every
+	// source is checked against every role in its account.
+	sources map[string][]passRoleSource
+	roles   map[string][]types.Role
+}
+
+// passRoleSource is an accessed principal and the compute actions it is allowed to call.
+type passRoleSource struct {
+	cfg     *creds.Config
+	actions []string
+}
+
+func (p *PassRole) Name() string { return "pass-role" }
+func (p *PassRole) Enabled() (bool, string) {
+	if !*passRole {
+		return false, "pass -pass-role to find roles that can be passed to compute services"
+	}
+	return true, "using iam.SimulatePrincipalPolicy to find roles that can be passed to compute services"
+}
+
+func (p *PassRole) Run(ctx utils.Context) {
+	p.Access.Walk(func(cfg *creds.Config) {
+		p.addSource(ctx, cfg)
+	})
+
+	p.FoundRoles.Walk(func(role types.Role) {
+		p.addRole(ctx, role)
+	})
+}
+
+// addSource checks which compute actions cfg is allowed to call, if any then it is checked against the roles in its
+// account.
+func (p *PassRole) addSource(ctx utils.Context, cfg *creds.Config) {
+	allowed, err := Simulate(ctx, iam.NewFromConfig(cfg.Config), cfg.Id(), utils.Keys(PassRoleActions), []string{"*"})
+	if err != nil {
+		ctx.Debug.Printf("pass-role: unable to simulate %s: %s\n", cfg.Id(), err)
+		return
+	} else if len(allowed["*"]) == 0 {
+		ctx.Debug.Printf("pass-role: %s isn't allowed to create any compute resources\n", cfg.Id())
+		return
+	}
+
+	src := passRoleSource{cfg: cfg, actions: allowed["*"]}
+
+	p.m.Lock()
+	p.sources[cfg.Account()] = append(p.sources[cfg.Account()], src)
+	roles := append([]types.Role{}, p.roles[cfg.Account()]...)
+	p.m.Unlock()
+
+	p.simulate(ctx, src, roles)
+}
+
+// addRole checks whether the sources in the role's account can pass it, roles without a known trust policy are
+// skipped since we can't tell which services could use them.
+func (p *PassRole) addRole(ctx utils.Context, role types.Role) {
+	if !p.Scope.Contains(role.Id()) {
+		return
+	} else if trust, err := role.TrustPolicy(); err != nil || trust == nil {
+		return
+	}
+	account, err := utils.AccountIdFromArn(role.Id())
+	if err != nil {
+		return
+	}
+
+	p.m.Lock()
+	p.roles[account] = append(p.roles[account], role)
+	sources := append([]passRoleSource{}, p.sources[account]...)
+	p.m.Unlock()
+
+	for _, src := range sources {
+		p.simulate(ctx, src, []types.Role{role})
+	}
+}
+
+// simulate records a pass-role edge from src to each of the roles that it is allowed to pass and that trusts a service
+// src can create resources in.
+func (p *PassRole) simulate(ctx utils.Context, src passRoleSource, roles []types.Role) {
+	byArn := map[string]types.Role{}
+	for _, role := range roles {
+		if role.Id() != src.cfg.Id() {
+			byArn[role.Id()] = role
+		}
+	}
+
+	allowed, err := Simulate(ctx, iam.NewFromConfig(src.cfg.Config), src.cfg.Id(), []string{actionPassRole}, utils.Keys(byArn))
+	if err != nil {
+		ctx.Debug.Printf("pass-role: unable to simulate %s: %s\n", src.cfg.Id(), err)
+		return
+	}
+
+	for arn := range allowed {
+		role, ok := byArn[arn]
+		if !ok {
+			continue
+		}
+
+		actions := trustedActions(ctx, role, src.actions)
+		if len(actions) == 0 {
+			continue
+		}
+
+		edge := graph.Potential{Kind: graph.PotentialPassRole, Actions: append([]string{actionPassRole}, actions...), DiscoveredAt: time.Now()}
+		if p.Graph.AddPotential(src.cfg.Id(), arn, edge) {
+			ctx.Info.Printf("pass-role: %s could pass %s with %v\n", src.cfg.Id(), arn, actions)
+		}
+	}
+}
+
+// trustedActions returns the actions whose service principal is trusted by the role. None are returned if the trust
+// policy isn't known.
+func trustedActions(ctx utils.Context, role types.Role, actions []string) []string {
+	trust, err := role.TrustPolicy()
+	if err != nil {
+		ctx.Debug.Printf("pass-role: %s\n", err)
+		return nil
+	} else if trust == nil {
+		return nil
+	}
+
+	var resp []string
+	for _, action := range actions {
+		req := policy.Request{PrincipalType: policy.PrincipalService, Principal: PassRoleActions[action]}
+		if decision, _ := trust.Evaluate(req); decision == policy.Allow {
+			resp = append(resp, action)
+		}
+	}
+	sort.Strings(resp)
+	return resp
+}
@@ -0,0 +1,31 @@
+package plugins
+
+import (
+	"context"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestTrustedActions(t *testing.T) {
+	actions := []string{"ec2:RunInstances", "ecs:RunTask", "lambda:CreateFunction"}
+
+	role := types.NewRole("arn:aws:iam::123456789012:role/lambda")
+	role.AssumeRolePolicyDocument = aws.String(`{
+		"Version": "2012-10-17",
+		"Statement": [{"Effect": "Allow", "Principal": {"Service": ["lambda.amazonaws.com", "ecs-tasks.amazonaws.com"]}, "Action": "sts:AssumeRole"}]
+	}`)
+
+	c := utils.NewContext(context.Background())
+	if diff := cmp.Diff(trustedActions(c, role, actions), []string{"ecs:RunTask", "lambda:CreateFunction"}); diff != "" {
+		t.Errorf("trustedActions() (-got +want):\n%s", diff)
+	}
+
+	// We can't tell which services could use the role when the trust policy isn't known.
+	unknown := types.NewRole("arn:aws:iam::123456789012:role/unknown")
+	if got := trustedActions(c, unknown, actions); len(got) != 0 {
+		t.Errorf("got %v with an unknown trust policy, want none", got)
+	}
+}
@@ -0,0 +1,305 @@
+package plugins
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/policy"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/codebuild"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"sync"
+)
+
+var references = flag.Bool("references", false, `
+Discover roles referenced in IAM policies, role trust policies, instance profiles, Lambda functions, ECS task
+definitions, CloudFormation stacks and CodeBuild projects of each account that is accessed. Where each role was found
+is included in the report.
+`)
+
+// Kinds of references, see graph.Reference.
+const (
+	RefManagedPolicy     = "managed-policy"
+	RefInlinePolicy      = "inline-policy"
+	RefTrustPolicy       = "trust-policy"
+	RefInstanceProfile   = "instance-profile"
+	RefLambdaFunction    = "lambda-function"
+	RefEcsTaskDefinition = "ecs-task-definition"
+	RefCloudFormation    = "cloudformation-stack"
+	RefCodeBuildProject  = "codebuild-project"
+)
+
+// ReferencesAPI holds the clients used by the reference searches.
+type ReferencesAPI struct {
+	IAM            ReferencesIAM
+	Lambda         lambda.ListFunctionsAPIClient
+	ECS            ReferencesECS
+	CloudFormation cloudformation.DescribeStacksAPIClient
+	CodeBuild      ReferencesCodeBuild
+}
+
+// ReferencesIAM is the part of the IAM client used to search policies and instance profiles.
+type ReferencesIAM interface {
+	iam.GetAccountAuthorizationDetailsAPIClient
+	iam.ListInstanceProfilesAPIClient
+}
+
+// ReferencesECS is the part of the ECS client used to search task definitions.
+type ReferencesECS interface {
+	ecs.ListTaskDefinitionFamiliesAPIClient
+	DescribeTaskDefinition(ctx context.Context, params *ecs.DescribeTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error)
+}
+
+// ReferencesCodeBuild is the part of the CodeBuild client used to search projects.
+type ReferencesCodeBuild interface {
+	codebuild.ListProjectsAPIClient
+	BatchGetProjects(ctx context.Context, params *codebuild.BatchGetProjectsInput, optFns ...func(*codebuild.Options)) (*codebuild.BatchGetProjectsOutput, error)
+}
+
+// NewReferencesAPI returns the clients used by the reference searches for cfg.
+func NewReferencesAPI(cfg *creds.Config) ReferencesAPI {
+	return ReferencesAPI{
+		IAM:            iam.NewFromConfig(cfg.Config),
+		Lambda:         lambda.NewFromConfig(cfg.Config),
+		ECS:            ecs.NewFromConfig(cfg.Config),
+		CloudFormation: cloudformation.NewFromConfig(cfg.Config),
+		CodeBuild:      codebuild.NewFromConfig(cfg.Config),
+	}
+}
+
+// referenceSearches are run for each account, they call found with each role ARN that is referenced.
+var referenceSearches = []struct {
+	name   string
+	search func(ctx utils.Context, api ReferencesAPI, found func(arn string, ref graph.Reference)) error
+}{
+	{"iam policies", searchIamPolicies},
+	{"instance profiles", searchInstanceProfiles},
+	{"lambda functions", searchLambdaFunctions},
+	{"ecs task definitions", searchEcsTaskDefinitions},
+	{"cloudformation stacks", searchCloudFormationStacks},
+	{"codebuild projects", searchCodeBuildProjects},
+}
+
+func NewReferences(_ utils.Context, args types.GlobalPluginArgs) types.Plugin {
+	return &References{
+		GlobalPluginArgs: args,
+		NewAPI:           NewReferencesAPI,
+		m:                &sync.Mutex{},
+		searched:         map[string]bool{},
+	}
+}
+
+// References discovers roles from the ARNs referenced in policies and the configuration of other resources.
+type References struct {
+	types.GlobalPluginArgs
+
+	// NewAPI returns the clients used with cfg, it is replaced when testing.
+	NewAPI func(cfg *creds.Config) ReferencesAPI
+
+	// searched holds the searches that succeeded in each account, keyed by account ID and search name.
+	m        *sync.Mutex
+	searched map[string]bool
+}
+
+func (r *References) Name() string { return "references" }
+func (r *References) Enabled() (bool, string) {
+	if !*references {
+		return false, "pass -references to discover roles referenced in policies and resources"
+	}
+	return true, "discovering roles referenced in policies and resources"
+}
+
+func (r *References) Run(ctx utils.Context) {
+	r.Access.Walk(func(cfg *creds.Config) {
+		api := r.NewAPI(cfg)
+
+		// Each search only needs to succeed once per account, but principals may not have access to all of them so
+		// the ones that failed are tried again with the next principal in the account.
+		for _, s := range referenceSearches {
+			key := cfg.Account() + "/" + s.name
+			r.m.Lock()
+			searched := r.searched[key]
+			r.m.Unlock()
+			if searched {
+				continue
+			}
+
+			if err := s.search(ctx, api, func(arn string, ref graph.Reference) { r.found(ctx, arn, ref) }); err != nil {
+				ctx.Debug.Printf("references: unable to search %s in %s with %s: %s\n", s.name, cfg.Account(), cfg.Arn(), err)
+				continue
+			}
+
+			r.m.Lock()
+			r.searched[key] = true
+			r.m.Unlock()
+		}
+	})
+}
+
+// found records the reference and adds the role to FoundRoles if it is in scope.
+func (r *References) found(ctx utils.Context, arn string, ref graph.Reference) {
+	if !policy.IsRoleArn(arn) || !r.Scope.Contains(arn) {
+		return
+	}
+
+	r.Graph.AddReference(arn, ref)
+	if r.FoundRoles.Add(types.NewRole(arn)) {
+		ctx.Debug.Printf("references: found %s in %s\n", arn, ref)
+	}
+}
+
+// searchIamPolicies searches the managed and inline policies for sts:AssumeRole resources, and role trust policies for
+// role principals.
+func searchIamPolicies(ctx utils.Context, api ReferencesAPI, found func(string, graph.Reference)) error {
+	inline := func(principal string, policies []iamTypes.PolicyDetail) {
+		for _, p := range policies {
+			ref := graph.Reference{Kind: RefInlinePolicy, Source: fmt.Sprintf("%s/%s", principal, aws.ToString(p.PolicyName))}
+			foundInPolicy(ctx, aws.ToString(p.PolicyDocument), ref, (*policy.Document).AssumableRoles, found)
+		}
+	}
+
+	paginator := iam.NewGetAccountAuthorizationDetailsPaginator(api.IAM, &iam.GetAccountAuthorizationDetailsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range page.Policies {
+			for _, v := range p.PolicyVersionList {
+				if v.IsDefaultVersion {
+					ref := graph.Reference{Kind: RefManagedPolicy, Source: aws.ToString(p.Arn)}
+					foundInPolicy(ctx, aws.ToString(v.Document), ref, (*policy.Document).AssumableRoles, found)
+				}
+			}
+		}
+		for _, u := range page.UserDetailList {
+			inline(aws.ToString(u.Arn), u.UserPolicyList)
+		}
+		for _, g := range page.GroupDetailList {
+			inline(aws.ToString(g.Arn), g.GroupPolicyList)
+		}
+		for _, role := range page.RoleDetailList {
+			inline(aws.ToString(role.Arn), role.RolePolicyList)
+
+			ref := graph.Reference{Kind: RefTrustPolicy, Source: aws.ToString(role.Arn)}
+			foundInPolicy(ctx, aws.ToString(role.AssumeRolePolicyDocument), ref, (*policy.Document).RolePrincipals, found)
+		}
+	}
+	return nil
+}
+
+// foundInPolicy calls found with each role ARN returned by roles from the parsed policy document.
+func foundInPolicy(ctx utils.Context, doc string, ref graph.Reference, roles func(*policy.Document) []string, found func(string, graph.Reference)) {
+	if doc == "" {
+		return
+	}
+
+	d, err := policy.Parse(doc)
+	if err != nil {
+		ctx.Debug.Printf("references: parsing policy of %s: %s\n", ref, err)
+		return
+	}
+	for _, arn := range roles(d) {
+		found(arn, ref)
+	}
+}
+
+func searchInstanceProfiles(ctx utils.Context, api ReferencesAPI, found func(string, graph.Reference)) error {
+	paginator := iam.NewListInstanceProfilesPaginator(api.IAM, &iam.ListInstanceProfilesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range page.InstanceProfiles {
+			for _, role := range p.Roles {
+				found(aws.ToString(role.Arn), graph.Reference{Kind: RefInstanceProfile, Source: aws.ToString(p.Arn)})
+			}
+		}
+	}
+	return nil
+}
+
+func searchLambdaFunctions(ctx utils.Context, api ReferencesAPI, found func(string, graph.Reference)) error {
+	paginator := lambda.NewListFunctionsPaginator(api.Lambda, &lambda.ListFunctionsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, f := range page.Functions {
+			found(aws.ToString(f.Role), graph.Reference{Kind: RefLambdaFunction, Source: aws.ToString(f.FunctionArn)})
+		}
+	}
+	return nil
+}
+
+// searchEcsTaskDefinitions checks the latest revision of each active task definition family.
+func searchEcsTaskDefinitions(ctx utils.Context, api ReferencesAPI, found func(string, graph.Reference)) error {
+	paginator := ecs.NewListTaskDefinitionFamiliesPaginator(api.ECS, &ecs.ListTaskDefinitionFamiliesInput{
+		Status: ecsTypes.TaskDefinitionFamilyStatusActive,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, family := range page.Families {
+			resp, err := api.ECS.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String(family)})
+			if err != nil {
+				return err
+			}
+			ref := graph.Reference{Kind: RefEcsTaskDefinition, Source: aws.ToString(resp.TaskDefinition.TaskDefinitionArn)}
+			found(aws.ToString(resp.TaskDefinition.TaskRoleArn), ref)
+			found(aws.ToString(resp.TaskDefinition.ExecutionRoleArn), ref)
+		}
+	}
+	return nil
+}
+
+func searchCloudFormationStacks(ctx utils.Context, api ReferencesAPI, found func(string, graph.Reference)) error {
+	paginator := cloudformation.NewDescribeStacksPaginator(api.CloudFormation, &cloudformation.DescribeStacksInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range page.Stacks {
+			found(aws.ToString(s.RoleARN), graph.Reference{Kind: RefCloudFormation, Source: aws.ToString(s.StackId)})
+		}
+	}
+	return nil
+}
+
+func searchCodeBuildProjects(ctx utils.Context, api ReferencesAPI, found func(string, graph.Reference)) error {
+	paginator := codebuild.NewListProjectsPaginator(api.CodeBuild, &codebuild.ListProjectsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		// BatchGetProjects accepts up to 100 names, the same as the maximum page size of ListProjects.
+		if len(page.Projects) == 0 {
+			continue
+		}
+		resp, err := api.CodeBuild.BatchGetProjects(ctx, &codebuild.BatchGetProjectsInput{Names: page.Projects})
+		if err != nil {
+			return err
+		}
+		for _, p := range resp.Projects {
+			found(aws.ToString(p.ServiceRole), graph.Reference{Kind: RefCodeBuildProject, Source: aws.ToString(p.Arn)})
+		}
+	}
+	return nil
+}
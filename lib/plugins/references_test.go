@@ -0,0 +1,367 @@
+package plugins
+
+import (
+	"context"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfnTypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/codebuild"
+	codebuildTypes "github.com/aws/aws-sdk-go-v2/service/codebuild/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// fakeReferencesAPI returns a single page of each resource, the operations in denied fail with AccessDenied. The
+// operations that are called are appended to calls.
+type fakeReferencesAPI struct {
+	details   iam.GetAccountAuthorizationDetailsOutput
+	profiles  []iamTypes.InstanceProfile
+	functions []lambdaTypes.FunctionConfiguration
+	tasks     map[string]*ecsTypes.TaskDefinition
+	stacks    []cfnTypes.Stack
+	projects  []codebuildTypes.Project
+	denied    map[string]bool
+	calls     *[]string
+}
+
+func (f fakeReferencesAPI) call(op string) error {
+	if f.calls != nil {
+		*f.calls = append(*f.calls, op)
+	}
+	if f.denied[op] {
+		return &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: " + op}
+	}
+	return nil
+}
+
+func (f fakeReferencesAPI) api() ReferencesAPI {
+	return ReferencesAPI{IAM: f, Lambda: f, ECS: f, CloudFormation: f, CodeBuild: f}
+}
+
+func (f fakeReferencesAPI) GetAccountAuthorizationDetails(context.Context, *iam.GetAccountAuthorizationDetailsInput, ...func(*iam.Options)) (*iam.GetAccountAuthorizationDetailsOutput, error) {
+	if err := f.call("GetAccountAuthorizationDetails"); err != nil {
+		return nil, err
+	}
+	return &f.details, nil
+}
+
+func (f fakeReferencesAPI) ListInstanceProfiles(context.Context, *iam.ListInstanceProfilesInput, ...func(*iam.Options)) (*iam.ListInstanceProfilesOutput, error) {
+	if err := f.call("ListInstanceProfiles"); err != nil {
+		return nil, err
+	}
+	return &iam.ListInstanceProfilesOutput{InstanceProfiles: f.profiles}, nil
+}
+
+func (f fakeReferencesAPI) ListFunctions(context.Context, *lambda.ListFunctionsInput, ...func(*lambda.Options)) (*lambda.ListFunctionsOutput, error) {
+	if err := f.call("ListFunctions"); err != nil {
+		return nil, err
+	}
+	return &lambda.ListFunctionsOutput{Functions: f.functions}, nil
+}
+
+func (f fakeReferencesAPI) ListTaskDefinitionFamilies(_ context.Context, in *ecs.ListTaskDefinitionFamiliesInput, _ ...func(*ecs.Options)) (*ecs.ListTaskDefinitionFamiliesOutput, error) {
+	if err := f.call("ListTaskDefinitionFamilies"); err != nil {
+		return nil, err
+	}
+
+	var families []string
+	if in.Status == ecsTypes.TaskDefinitionFamilyStatusActive {
+		for family := range f.tasks {
+			families = append(families, family)
+		}
+	}
+	return &ecs.ListTaskDefinitionFamiliesOutput{Families: families}, nil
+}
+
+func (f fakeReferencesAPI) DescribeTaskDefinition(_ context.Context, in *ecs.DescribeTaskDefinitionInput, _ ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+	if err := f.call("DescribeTaskDefinition"); err != nil {
+		return nil, err
+	}
+	return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: f.tasks[aws.ToString(in.TaskDefinition)]}, nil
+}
+
+func (f fakeReferencesAPI) DescribeStacks(context.Context, *cloudformation.DescribeStacksInput, ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error) {
+	if err := f.call("DescribeStacks"); err != nil {
+		return nil, err
+	}
+	return &cloudformation.DescribeStacksOutput{Stacks: f.stacks}, nil
+}
+
+func (f fakeReferencesAPI) ListProjects(context.Context, *codebuild.ListProjectsInput, ...func(*codebuild.Options)) (*codebuild.ListProjectsOutput, error) {
+	if err := f.call("ListProjects"); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, p := range f.projects {
+		names = append(names, aws.ToString(p.Name))
+	}
+	return &codebuild.ListProjectsOutput{Projects: names}, nil
+}
+
+func (f fakeReferencesAPI) BatchGetProjects(_ context.Context, in *codebuild.BatchGetProjectsInput, _ ...func(*codebuild.Options)) (*codebuild.BatchGetProjectsOutput, error) {
+	if err := f.call("BatchGetProjects"); err != nil {
+		return nil, err
+	}
+
+	var projects []codebuildTypes.Project
+	for _, p := range f.projects {
+		for _, name := range in.Names {
+			if aws.ToString(p.Name) == name {
+				projects = append(projects, p)
+			}
+		}
+	}
+	return &codebuild.BatchGetProjectsOutput{Projects: projects}, nil
+}
+
+// assumeRolePolicy allows sts:AssumeRole on role, the IAM API returns it URL encoded.
+func assumeRolePolicy(role string) *string {
+	return aws.String(url.QueryEscape(`{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": "` + role + `"}]}`))
+}
+
+func testRole(name string) string {
+	return "arn:aws:iam::123456789012:role/" + name
+}
+
+var testReferencesAPI = fakeReferencesAPI{
+	details: iam.GetAccountAuthorizationDetailsOutput{
+		Policies: []iamTypes.ManagedPolicyDetail{{
+			Arn: aws.String("arn:aws:iam::123456789012:policy/deploy"),
+			PolicyVersionList: []iamTypes.PolicyVersion{
+				{Document: assumeRolePolicy(testRole("old")), IsDefaultVersion: false},
+				{Document: assumeRolePolicy(testRole("managed")), IsDefaultVersion: true},
+			},
+		}},
+		UserDetailList: []iamTypes.UserDetail{{
+			Arn:            aws.String("arn:aws:iam::123456789012:user/alice"),
+			UserPolicyList: []iamTypes.PolicyDetail{{PolicyName: aws.String("assume"), PolicyDocument: assumeRolePolicy(testRole("user-inline"))}},
+		}},
+		GroupDetailList: []iamTypes.GroupDetail{{
+			Arn:             aws.String("arn:aws:iam::123456789012:group/admins"),
+			GroupPolicyList: []iamTypes.PolicyDetail{{PolicyName: aws.String("assume"), PolicyDocument: assumeRolePolicy(testRole("group-inline"))}},
+		}},
+		RoleDetailList: []iamTypes.RoleDetail{{
+			Arn:            aws.String(testRole("ci")),
+			RolePolicyList: []iamTypes.PolicyDetail{{PolicyName: aws.String("assume"), PolicyDocument: assumeRolePolicy(testRole("role-inline"))}},
+			AssumeRolePolicyDocument: aws.String(url.QueryEscape(`{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": "` + testRole("trusted") + `", "Service": "ec2.amazonaws.com"}, "Action": "sts:AssumeRole"}
+			]}`)),
+		}},
+	},
+	profiles: []iamTypes.InstanceProfile{{
+		Arn:   aws.String("arn:aws:iam::123456789012:instance-profile/web"),
+		Roles: []iamTypes.Role{{Arn: aws.String(testRole("ec2"))}},
+	}},
+	functions: []lambdaTypes.FunctionConfiguration{{
+		FunctionArn: aws.String("arn:aws:lambda:us-east-1:123456789012:function:handler"),
+		Role:        aws.String(testRole("lambda")),
+	}},
+	tasks: map[string]*ecsTypes.TaskDefinition{
+		"web": {
+			TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web:3"),
+			TaskRoleArn:       aws.String(testRole("task")),
+			ExecutionRoleArn:  aws.String(testRole("execution")),
+		},
+	},
+	stacks: []cfnTypes.Stack{{
+		StackId: aws.String("arn:aws:cloudformation:us-east-1:123456789012:stack/app/1"),
+		RoleARN: aws.String(testRole("cfn")),
+	}},
+	projects: []codebuildTypes.Project{{
+		Name:        aws.String("build"),
+		Arn:         aws.String("arn:aws:codebuild:us-east-1:123456789012:project/build"),
+		ServiceRole: aws.String(testRole("codebuild")),
+	}},
+}
+
+type foundRef struct {
+	Arn string
+	Ref graph.Reference
+}
+
+func TestReferenceSearches(t *testing.T) {
+	tests := []struct {
+		name   string
+		search func(utils.Context, ReferencesAPI, func(string, graph.Reference)) error
+		want   []foundRef
+	}{
+		{
+			name:   "iam policies",
+			search: searchIamPolicies,
+			want: []foundRef{
+				{testRole("managed"), graph.Reference{Kind: RefManagedPolicy, Source: "arn:aws:iam::123456789012:policy/deploy"}},
+				{testRole("user-inline"), graph.Reference{Kind: RefInlinePolicy, Source: "arn:aws:iam::123456789012:user/alice/assume"}},
+				{testRole("group-inline"), graph.Reference{Kind: RefInlinePolicy, Source: "arn:aws:iam::123456789012:group/admins/assume"}},
+				{testRole("role-inline"), graph.Reference{Kind: RefInlinePolicy, Source: testRole("ci") + "/assume"}},
+				{testRole("trusted"), graph.Reference{Kind: RefTrustPolicy, Source: testRole("ci")}},
+			},
+		},
+		{
+			name:   "instance profiles",
+			search: searchInstanceProfiles,
+			want: []foundRef{
+				{testRole("ec2"), graph.Reference{Kind: RefInstanceProfile, Source: "arn:aws:iam::123456789012:instance-profile/web"}},
+			},
+		},
+		{
+			name:   "lambda functions",
+			search: searchLambdaFunctions,
+			want: []foundRef{
+				{testRole("lambda"), graph.Reference{Kind: RefLambdaFunction, Source: "arn:aws:lambda:us-east-1:123456789012:function:handler"}},
+			},
+		},
+		{
+			name:   "ecs task definitions",
+			search: searchEcsTaskDefinitions,
+			want: []foundRef{
+				{testRole("task"), graph.Reference{Kind: RefEcsTaskDefinition, Source: "arn:aws:ecs:us-east-1:123456789012:task-definition/web:3"}},
+				{testRole("execution"), graph.Reference{Kind: RefEcsTaskDefinition, Source: "arn:aws:ecs:us-east-1:123456789012:task-definition/web:3"}},
+			},
+		},
+		{
+			name:   "cloudformation stacks",
+			search: searchCloudFormationStacks,
+			want: []foundRef{
+				{testRole("cfn"), graph.Reference{Kind: RefCloudFormation, Source: "arn:aws:cloudformation:us-east-1:123456789012:stack/app/1"}},
+			},
+		},
+		{
+			name:   "codebuild projects",
+			search: searchCodeBuildProjects,
+			want: []foundRef{
+				{testRole("codebuild"), graph.Reference{Kind: RefCodeBuildProject, Source: "arn:aws:codebuild:us-east-1:123456789012:project/build"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []foundRef
+			err := tt.search(ctx, testReferencesAPI.api(), func(arn string, ref graph.Reference) {
+				got = append(got, foundRef{arn, ref})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("found (-got +want):\n%s", diff)
+			}
+
+			denied := testReferencesAPI
+			denied.denied = map[string]bool{
+				"GetAccountAuthorizationDetails": true, "ListInstanceProfiles": true, "ListFunctions": true,
+				"ListTaskDefinitionFamilies": true, "DescribeStacks": true, "ListProjects": true,
+			}
+			if err := tt.search(ctx, denied.api(), func(string, graph.Reference) {}); err == nil {
+				t.Error("expected an error when access is denied")
+			}
+		})
+	}
+}
+
+func newTestReferences(accounts []string, apis map[string]fakeReferencesAPI) *References {
+	return &References{
+		GlobalPluginArgs: types.GlobalPluginArgs{
+			Graph:      graph.NewDirectedGraph[*creds.Config](),
+			Access:     utils.NewIterator[*creds.Config](),
+			FoundRoles: utils.NewIterator[types.Role](),
+			Scope:      utils.NewScope(accounts),
+		},
+		NewAPI: func(cfg *creds.Config) ReferencesAPI {
+			return apis[cfg.Arn()].api()
+		},
+		m:        &sync.Mutex{},
+		searched: map[string]bool{},
+	}
+}
+
+func TestReferences_Found(t *testing.T) {
+	r := newTestReferences([]string{testAccountId}, nil)
+	ref := graph.Reference{Kind: RefLambdaFunction, Source: "arn:aws:lambda:us-east-1:123456789012:function:handler"}
+
+	r.found(ctx, testRole("lambda"), ref)
+	r.found(ctx, testRole("lambda"), ref)
+	r.found(ctx, "arn:aws:iam::222222222222:role/other", ref)
+	r.found(ctx, "arn:aws:iam::123456789012:user/alice", ref)
+	r.found(ctx, "", ref)
+
+	var got []string
+	r.FoundRoles.Walk(func(role types.Role) { got = append(got, role.Id()) })
+	if diff := cmp.Diff(got, []string{testRole("lambda")}); diff != "" {
+		t.Errorf("FoundRoles (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(r.Graph.References(), map[string][]graph.Reference{testRole("lambda"): {ref}}); diff != "" {
+		t.Errorf("References() (-got +want):\n%s", diff)
+	}
+}
+
+// TestReferences_Run ensures searches that failed in an account are tried again with the next principal in it, and
+// the ones that succeeded are not.
+func TestReferences_Run(t *testing.T) {
+	var limitedCalls, adminCalls, otherCalls []string
+
+	limited := testReferencesAPI
+	limited.denied = map[string]bool{"GetAccountAuthorizationDetails": true, "DescribeTaskDefinition": true}
+	limited.calls = &limitedCalls
+
+	admin := testReferencesAPI
+	admin.calls = &adminCalls
+
+	other := testReferencesAPI
+	other.calls = &otherCalls
+
+	limitedCfg, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/limited", nil))
+	adminCfg, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/admin", nil))
+	otherCfg, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/other", nil))
+
+	r := newTestReferences([]string{testAccountId}, map[string]fakeReferencesAPI{
+		limitedCfg.Arn(): limited,
+		adminCfg.Arn():   admin,
+		otherCfg.Arn():   other,
+	})
+	r.Run(ctx)
+
+	r.Access.Add(limitedCfg)
+	want := []string{
+		"GetAccountAuthorizationDetails", "ListInstanceProfiles", "ListFunctions", "ListTaskDefinitionFamilies",
+		"DescribeTaskDefinition", "DescribeStacks", "ListProjects", "BatchGetProjects",
+	}
+	if diff := cmp.Diff(limitedCalls, want); diff != "" {
+		t.Errorf("limited calls (-got +want):\n%s", diff)
+	}
+
+	r.Access.Add(adminCfg)
+	want = []string{"GetAccountAuthorizationDetails", "ListTaskDefinitionFamilies", "DescribeTaskDefinition"}
+	if diff := cmp.Diff(adminCalls, want); diff != "" {
+		t.Errorf("admin calls (-got +want):\n%s", diff)
+	}
+
+	r.Access.Add(otherCfg)
+	if len(otherCalls) != 0 {
+		t.Errorf("expected no calls after every search succeeded, got %v", otherCalls)
+	}
+
+	got := map[string]bool{}
+	r.FoundRoles.Walk(func(role types.Role) { got[role.Id()] = true })
+	for _, name := range []string{"managed", "user-inline", "group-inline", "role-inline", "trusted", "ec2", "lambda", "task", "execution", "cfn", "codebuild"} {
+		if !got[testRole(name)] {
+			t.Errorf("expected %s to be found", testRole(name))
+		}
+	}
+	if got[testRole("old")] {
+		t.Error("expected roles in non-default policy versions to not be found")
+	}
+}
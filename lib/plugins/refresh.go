@@ -4,16 +4,25 @@ import (
 	"flag"
 	"fmt"
 	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/scheduler"
 	"github.com/RyanJarv/liquidswards/lib/types"
 	"github.com/RyanJarv/liquidswards/lib/utils"
-	"runtime/debug"
 	"time"
 )
 
 var CredRefreshSeconds = flag.Int("refresh", 0, `
-The CredRefreshSeconds rate used for the access plugin in seconds. This defaults to once an hour, but if you want to bypass role 
-revocation without using cloudtrail events (-sqs-queue option, see the README for more info) you can set this to 
-approximately three seconds.
+The longest time in seconds between credential refreshes for the access plugin. If you want to bypass role revocation
+without using cloudtrail events (-sqs-queue option, see the README for more info) you can set this to approximately
+three seconds.
+`)
+
+var refreshFraction = flag.Float64("refresh-fraction", 0, `
+Refresh credentials once this fraction of their remaining lifetime has passed, for example 0.5 refreshes one hour
+sessions every 30 minutes. When used with -refresh, whichever comes first is used.
+`)
+
+var refreshWorkers = flag.Int("refresh-workers", scheduler.DefaultOptions.Workers, `
+The number of credential refreshes that can run at once.
 `)
 
 type NewAccessInput struct {
@@ -25,17 +34,42 @@ type NewAccessInput struct {
 	AccessRefresh int
 }
 
+// NewScheduler returns the scheduler shared by the plugins, it is configured with the -refresh flags. Targets are
+// only refreshed again after their first refresh when -refresh or -refresh-fraction is used.
+func NewScheduler(ctx utils.Context) *scheduler.Scheduler {
+	opts := scheduler.DefaultOptions
+	opts.Interval = time.Duration(*CredRefreshSeconds) * time.Second
+	opts.Fraction = *refreshFraction
+	opts.Workers = *refreshWorkers
+
+	s := scheduler.New(ctx, opts)
+	s.Subscribe(func(e scheduler.Event) {
+		if e.Err != nil {
+			ctx.Error.Printf("refresh failed, retrying at %s: %s\n", e.Next.Format(time.TimeOnly), e.Err)
+		} else if e.Next.IsZero() {
+			ctx.Info.Printf("refresh: %s, it won't be refreshed again\n", e.Id)
+		} else {
+			ctx.Info.Printf("refresh: %s, next refresh at %s\n", e.Id, e.Next.Format(time.TimeOnly))
+		}
+	})
+	return s
+}
+
 func NewRefresh(ctx utils.Context, args types.GlobalPluginArgs) types.Plugin {
 	return &Refresh{
 		Context:          ctx,
 		GlobalPluginArgs: args,
 		RefreshSeconds:   *CredRefreshSeconds,
+		Fraction:         *refreshFraction,
 	}
 }
 
+// Refresh keeps the accessed credentials working by adding them to the Scheduler, which refreshes them before they
+// expire.
 type Refresh struct {
 	types.GlobalPluginArgs
 	RefreshSeconds int
+	Fraction       float64
 	Context        utils.Context
 }
 
@@ -44,46 +78,32 @@ func (a *Refresh) Name() string {
 }
 
 func (a *Refresh) Enabled() (bool, string) {
-	if a.RefreshSeconds > 0 {
-		return true, fmt.Sprintf("will CredRefreshSeconds credentials every %d seconds", a.RefreshSeconds)
-	} else {
-		return false, "no -CredRefreshSeconds arg provided"
+	switch {
+	case a.RefreshSeconds > 0 && a.Fraction > 0:
+		return true, fmt.Sprintf("will refresh credentials every %d seconds or after %.0f%% of their lifetime", a.RefreshSeconds, a.Fraction*100)
+	case a.RefreshSeconds > 0:
+		return true, fmt.Sprintf("will refresh credentials every %d seconds", a.RefreshSeconds)
+	case a.Fraction > 0:
+		return true, fmt.Sprintf("will refresh credentials after %.0f%% of their lifetime", a.Fraction*100)
+	default:
+		return false, "no -refresh or -refresh-fraction arg provided"
 	}
 }
 
 func (a *Refresh) Run(ctx utils.Context) {
 	a.Access.Walk(func(cfg *creds.Config) {
-		run(ctx, cfg, a.RefreshSeconds)
+		// We don't have credentials for users.
+		if cfg.Type == creds.SourceUser {
+			return
+		}
+		a.Scheduler.Add(cfg, cfg.Health().Expires)
 	})
 }
 
+// Wait blocks until the scan stops, the Scheduler stops with it.
 func (a *Refresh) Wait() {
-	<-utils.SigTermChan()
-}
-
-// run refreshes credentials periodically and when triggered by the returned channel.
-//
-// This allows us to catch and CredRefreshSeconds revoked sessions, the time between refreshes is configured with -access-CredRefreshSeconds.
-func run(ctx utils.Context, cfg *creds.Config, seconds int) {
-	utils.SetDebugLabels("plugins", "access", "identity", cfg.Arn())
-
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				ctx.Error.Println("access:", r, "\n", string(debug.Stack()))
-			}
-		}()
-		for {
-			select {
-			case <-time.After(time.Second * time.Duration(seconds)):
-			}
-
-			creds, err := cfg.Refresh(ctx)
-			if err != nil {
-				ctx.Error.Printf("refresh failed: %s\n", err)
-				continue
-			}
-			ctx.Info.Printf("refresh: %s -- %s", cfg.Id(), creds.AccessKeyID)
-		}
-	}()
+	select {
+	case <-utils.SigTermChan():
+	case <-a.Context.Done():
+	}
 }
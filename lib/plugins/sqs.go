@@ -7,11 +7,17 @@ import (
 	"fmt"
 	"github.com/RyanJarv/liquidswards/lib/creds"
 	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/policy"
 	"github.com/RyanJarv/liquidswards/lib/types"
 	"github.com/RyanJarv/liquidswards/lib/utils"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"sort"
+	"strings"
+	"time"
 )
 
 var sqsQueue = flag.String("sqs-queue", "", `
@@ -31,10 +37,17 @@ func NewSqs(ctx utils.Context, args types.GlobalPluginArgs) types.Plugin {
 	return &Sqs{
 		GlobalPluginArgs: args,
 		SqsQueue:         *sqsQueue,
+		IAM:              iam.NewFromConfig(args.PrimaryAwsConfig),
 		cfgs:             map[string][]chan int{},
 	}
 }
 
+// PolicyAPI is the part of the IAM client used to read the documents of managed policies.
+type PolicyAPI interface {
+	GetPolicy(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error)
+	GetPolicyVersion(ctx context.Context, params *iam.GetPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error)
+}
+
 type Sqs struct {
 	types.GlobalPluginArgs
 
@@ -42,7 +55,11 @@ type Sqs struct {
 	// credentials only when necessary. AccessRefresh is ignored when this is specified.
 	SqsQueue string
 	Path     string
-	cfgs     map[string][]chan int
+
+	// IAM reads the managed policies attached in events, it uses the same credentials as the queue.
+	IAM PolicyAPI
+
+	cfgs map[string][]chan int
 }
 
 func (a *Sqs) Name() string {
@@ -70,17 +87,136 @@ type CloudTrailEvent struct {
 	Account    string `json:"account"`
 	Time       string `json:"time"`
 	Detail     struct {
-		EventName         string `json:"eventName"`
+		EventSource  string `json:"eventSource"`
+		EventName    string `json:"eventName"`
+		UserIdentity struct {
+			Arn string `json:"arn"`
+		} `json:"userIdentity"`
 		RequestParameters struct {
-			UserName       *string `json:"userName"`
-			RoleName       *string `json:"roleName"`
-			PolicyName     *string `json:"policyName"`
-			PolicyDocument *string `json:"policyDocument"`
+			UserName            *string `json:"userName"`
+			RoleName            *string `json:"roleName"`
+			PolicyName          *string `json:"policyName"`
+			PolicyDocument      *string `json:"policyDocument"`
+			PolicyArn           *string `json:"policyArn"`
+			PolicyId            *string `json:"policyId"`
+			PermissionsBoundary *string `json:"permissionsBoundary"`
 		} `json:"requestParameters"`
 		RecipientAccountId string `json:"recipientAccountId"`
 	} `json:"detail"`
 }
 
+// roleArn returns the ARN of the role named in the request. The partition isn't part of the event so it is taken from
+// the ARN of the caller, which is in the same partition as the account.
+func (e CloudTrailEvent) roleArn() string {
+	partition := utils.PartitionFromArn(e.Detail.UserIdentity.Arn)
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, e.Detail.RecipientAccountId, aws.ToString(e.Detail.RequestParameters.RoleName))
+}
+
+// SqsAction is what is done to the principals affected by an event.
+type SqsAction int
+
+const (
+	// ActionRefresh schedules the credentials of the role to be refreshed now.
+	ActionRefresh SqsAction = iota
+
+	// ActionRetestInbound assumes the role again from each principal with an edge to it, edges that are now denied
+	// are removed.
+	ActionRetestInbound
+
+	// ActionMarkFailed marks the credentials of the role as failed.
+	ActionMarkFailed
+
+	// ActionRemove removes the role from the graph.
+	ActionRemove
+)
+
+func (a SqsAction) String() string {
+	switch a {
+	case ActionRefresh:
+		return "refresh"
+	case ActionRetestInbound:
+		return "re-test inbound edges"
+	case ActionMarkFailed:
+		return "mark failed"
+	case ActionRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// SqsHandler maps an event to the action taken on the principals it affects.
+type SqsHandler struct {
+	// Match returns true if the handler applies to the event, nil matches every event with the name. The plugin is
+	// passed for matches that need to look up more than what is in the event.
+	Match func(utils.Context, *Sqs, CloudTrailEvent) bool
+
+	Action SqsAction
+
+	// AllNodes applies the action to every principal in the graph rather than the role in the event, this is used
+	// for organization wide changes like SCP's.
+	AllNodes bool
+}
+
+// SqsHandlers are the handlers for each CloudTrail event name, the first handler that matches the event is used.
+var SqsHandlers = map[string][]SqsHandler{
+	"PutRolePolicy":              {{Match: policyNamed("AWSRevokeOlderSessions"), Action: ActionRefresh}},
+	"DeleteRolePolicy":           {{Action: ActionRefresh}},
+	"PutRolePermissionsBoundary": {{Action: ActionRefresh}},
+	"UpdateAssumeRolePolicy":     {{Action: ActionRetestInbound}},
+	"AttachRolePolicy":           {{Match: denyPolicy, Action: ActionMarkFailed}},
+	"DeleteRole":                 {{Action: ActionRemove}},
+	"UpdatePolicy":               {{Match: fromSource("organizations.amazonaws.com"), Action: ActionRetestInbound, AllNodes: true}},
+}
+
+func policyNamed(name string) func(utils.Context, *Sqs, CloudTrailEvent) bool {
+	return func(_ utils.Context, _ *Sqs, e CloudTrailEvent) bool {
+		return aws.ToString(e.Detail.RequestParameters.PolicyName) == name
+	}
+}
+
+func fromSource(source string) func(utils.Context, *Sqs, CloudTrailEvent) bool {
+	return func(_ utils.Context, _ *Sqs, e CloudTrailEvent) bool {
+		return e.Detail.EventSource == source
+	}
+}
+
+// denyPolicy returns true if the default version of the attached managed policy denies every action to the role, for
+// example AWSDenyAll. The policy document isn't part of the event so it is read with iam:GetPolicyVersion.
+func denyPolicy(ctx utils.Context, a *Sqs, e CloudTrailEvent) bool {
+	arn := aws.ToString(e.Detail.RequestParameters.PolicyArn)
+	doc, err := a.defaultVersion(ctx, arn)
+	if err != nil {
+		ctx.Error.Printf("sqs: %s\n", err)
+		return false
+	}
+
+	decision, _ := doc.Evaluate(policy.Request{Principal: e.roleArn(), Action: "*"})
+	return decision == policy.ExplicitDeny
+}
+
+// defaultVersion returns the document of the default version of the managed policy arn.
+func (a *Sqs) defaultVersion(ctx utils.Context, arn string) (*policy.Document, error) {
+	p, err := a.IAM.GetPolicy(ctx, &iam.GetPolicyInput{PolicyArn: aws.String(arn)})
+	if err != nil {
+		return nil, fmt.Errorf("defaultVersion(): %w", err)
+	}
+
+	v, err := a.IAM.GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{
+		PolicyArn: aws.String(arn),
+		VersionId: p.Policy.DefaultVersionId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("defaultVersion(): %w", err)
+	}
+
+	doc, err := policy.Parse(aws.ToString(v.PolicyVersion.Document))
+	if err != nil {
+		return nil, fmt.Errorf("defaultVersion(): %s: %w", arn, err)
+	}
+	return doc, nil
+}
+
 type ReceiveMessageFunc func(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
 type DeleteMessageFunc func(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
 type GetNode func(k string) (graph.Node[*creds.Config], bool)
@@ -89,7 +225,7 @@ func (a *Sqs) RunSqsClient(ctx utils.Context, receive ReceiveMessageFunc, delete
 	for ctx.IsRunning() {
 		msg, err := receive(ctx, &sqs.ReceiveMessageInput{
 			QueueUrl:            aws.String(a.SqsQueue),
-			MaxNumberOfMessages: 1,
+			MaxNumberOfMessages: 10,
 			VisibilityTimeout:   5,
 			WaitTimeSeconds:     20,
 		})
@@ -106,33 +242,101 @@ func (a *Sqs) RunSqsClient(ctx utils.Context, receive ReceiveMessageFunc, delete
 				ctx.Error.Printf("failed deleting message from %s: %s\n", a.SqsQueue, err)
 			}
 
-			if err := handleCloudTrailMsg(ctx, getNode, msg); err != nil {
+			if err := a.handleCloudTrailMsg(ctx, getNode, msg); err != nil {
 				fmt.Printf("failed to handle cloudtrail message: %s\n", err)
 			}
 		}
 	}
 }
 
-func handleCloudTrailMsg(ctx utils.Context, get GetNode, msg sqsTypes.Message) error {
+// handleCloudTrailMsg runs the action of the first handler in SqsHandlers that matches the event.
+func (a *Sqs) handleCloudTrailMsg(ctx utils.Context, get GetNode, msg sqsTypes.Message) error {
 	event := CloudTrailEvent{}
 	if err := json.Unmarshal([]byte(*msg.Body), &event); err != nil {
 		return fmt.Errorf("failed to unmarshal event: %s\n", err)
 	}
 
-	detail := event.Detail
-	params := event.Detail.RequestParameters
-	if detail.EventName == "PutRolePolicy" && params.PolicyName != nil && *params.PolicyName == "AWSRevokeOlderSessions" {
-		arn := fmt.Sprintf("arn:aws:iam::%s:role/%s", detail.RecipientAccountId, *detail.RequestParameters.RoleName)
-		node, ok := get(arn)
-		if !ok {
-			return fmt.Errorf("sqs: no config found for %s", arn)
+	for _, h := range SqsHandlers[event.Detail.EventName] {
+		if h.Match != nil && !h.Match(ctx, a, event) {
+			continue
 		}
 
-		if creds, err := node.Value().Refresh(ctx); err != nil {
-			return fmt.Errorf("sqs: failed to CredRefreshSeconds %s: %s", arn, err)
-		} else {
-			ctx.Info.Printf("refreshed %s -- %s", arn, creds.AccessKeyID)
+		var targets []string
+		if h.AllNodes {
+			if a.Graph != nil {
+				targets = utils.Keys(a.Graph.Nodes())
+				sort.Strings(targets)
+			}
+		} else if event.Detail.RequestParameters.RoleName != nil {
+			targets = []string{event.roleArn()}
+		}
+
+		ctx.Info.Printf("sqs: %s, will %s %d principals\n", event.Detail.EventName, h.Action, len(targets))
+
+		var errs []string
+		for _, arn := range targets {
+			if err := a.apply(ctx, get, h.Action, arn); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) != 0 {
+			return fmt.Errorf("sqs: %s", strings.Join(errs, ", "))
+		}
+		return nil
+	}
+	return nil
+}
+
+func (a *Sqs) apply(ctx utils.Context, get GetNode, action SqsAction, arn string) error {
+	node, ok := get(arn)
+	if !ok {
+		return fmt.Errorf("no config found for %s", arn)
+	}
+
+	switch action {
+	case ActionRefresh:
+		// The scheduler refreshes the parents of the role first, the result is logged from its events.
+		a.Scheduler.Schedule(node.Value(), time.Now())
+		ctx.Info.Printf("sqs: scheduled a refresh of %s\n", arn)
+	case ActionRetestInbound:
+		a.retestInbound(ctx, node)
+	case ActionMarkFailed:
+		node.Value().SetState(creds.FailedState)
+		ctx.Info.Printf("marked %s as failed\n", arn)
+	case ActionRemove:
+		if a.Graph.RemoveNode(arn) {
+			ctx.Info.Printf("removed %s\n", arn)
 		}
+		a.Scheduler.Remove(arn)
 	}
 	return nil
 }
+
+// retestInbound assumes the role of node from each principal with an edge to it, the edges that are now denied are
+// removed.
+func (a *Sqs) retestInbound(ctx utils.Context, node graph.Node[*creds.Config]) {
+	arn := node.Value().Id()
+	for id, src := range node.Inbound() {
+		var externalIds []string
+		if edge, ok := src.Edges()[arn]; ok && edge.ExternalId != nil {
+			externalIds = []string{*edge.ExternalId}
+		}
+
+		_, err := src.Value().Assume(ctx, arn, externalIds)
+		if err == nil {
+			continue
+		}
+
+		// The error is classified rather than looking up the denial in the graph, which may be from an earlier test.
+		in := &sts.AssumeRoleInput{RoleArn: aws.String(arn)}
+		if len(externalIds) != 0 {
+			in.ExternalId = aws.String(externalIds[0])
+		}
+		if creds.Definitive(creds.NewDenial(err, in)) {
+			a.Graph.RemoveEdge(id, arn)
+			ctx.Info.Printf("sqs: %s can no longer assume %s\n", id, arn)
+		} else {
+			ctx.Error.Printf("sqs: re-testing %s -> %s: %s\n", id, arn, err)
+		}
+	}
+}
@@ -4,14 +4,19 @@ import (
 	"context"
 	"github.com/RyanJarv/liquidswards/lib/creds"
 	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/scheduler"
 	"github.com/RyanJarv/liquidswards/lib/types"
 	"github.com/RyanJarv/liquidswards/lib/utils"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
 	"github.com/aws/smithy-go/middleware"
 	"github.com/google/go-cmp/cmp"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -28,7 +33,7 @@ var ctx = utils.NewContext(context.Background())
 
 func TestNewAccess(t *testing.T) {
 	g := graph.NewDirectedGraph[*creds.Config]()
-	cfg := utils.Must(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/profile-a", nil))
+	cfg, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/profile-a", nil))
 	g.AddNode(cfg)
 	_, err := NewTestAccess(g)
 	if err != nil {
@@ -38,7 +43,7 @@ func TestNewAccess(t *testing.T) {
 
 func TestAccess_Run(t *testing.T) {
 	g := graph.NewDirectedGraph[*creds.Config]()
-	cfg := utils.Must(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/profile-a", g))
+	cfg, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/profile-a", g))
 	g.AddNode(cfg)
 	access, err := NewTestAccess(g)
 	if err != nil {
@@ -61,7 +66,6 @@ func (m *MockGetNode) GetNode(k string) (graph.Node[*creds.Config], bool) {
 	return nil, false
 }
 
-// TODO: Clean this up
 func TestAccess_RunSqsClient(t *testing.T) {
 	access := &Sqs{
 		SqsQueue: testSqsQueue,
@@ -69,15 +73,11 @@ func TestAccess_RunSqsClient(t *testing.T) {
 			Region:           "us-east-1",
 			PrimaryAwsConfig: aws.Config{Region: "us-east-1"},
 		},
-		cfgs: map[string][]chan int{
-			"role-a": {make(chan int, 10), make(chan int, 10)},
-			"role-b": {make(chan int, 10)},
-			"role-c": {},
-		},
+		cfgs: map[string][]chan int{},
 	}
 
 	runCtx, cancelFunc := ctx.WithCancel()
-	ch := make(chan string, 0)
+	done := make(chan struct{})
 
 	got := MockGetNode{}
 	want := MockGetNode{
@@ -86,27 +86,160 @@ func TestAccess_RunSqsClient(t *testing.T) {
 		"arn:aws:iam::123456789012:role/role-c",
 	}
 
-	go access.RunSqsClient(runCtx, func(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
-		return &sqs.ReceiveMessageOutput{
-			Messages: []sqsTypes.Message{
-				{Body: revokeSessMsg(strings.Split(<-ch, "/")[1])},
-			},
-			ResultMetadata: middleware.Metadata{},
-		}, nil
-	}, func(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
-		return &sqs.DeleteMessageOutput{}, nil
-	}, got.GetNode)
-
+	var batch []sqsTypes.Message
 	for _, arn := range want {
-		ch <- arn
+		batch = append(batch, sqsTypes.Message{Body: revokeSessMsg(strings.Split(arn, "/")[1])})
 	}
+	batch = append(batch, sqsTypes.Message{Body: eventMsg("CreateRole", "role-d", "")})
+
+	var maxMessages int32
+	deleted := 0
+	go func() {
+		access.RunSqsClient(runCtx, func(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+			maxMessages = params.MaxNumberOfMessages
+			msgs := batch
+			batch = nil
+			if msgs == nil {
+				<-runCtx.Done()
+			}
+			return &sqs.ReceiveMessageOutput{Messages: msgs, ResultMetadata: middleware.Metadata{}}, nil
+		}, func(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+			deleted++
+			return &sqs.DeleteMessageOutput{}, nil
+		}, got.GetNode)
+		close(done)
+	}()
 
-	time.Sleep(1 * time.Second)
+	time.Sleep(100 * time.Millisecond)
 	cancelFunc()
+	<-done
 
 	if diff := cmp.Diff(got, want); diff != "" {
 		t.Errorf("got != want, -got +want:\n%s", diff)
 	}
+	if maxMessages != 10 {
+		t.Errorf("got MaxNumberOfMessages %d, want 10", maxMessages)
+	}
+	if deleted != 4 {
+		t.Errorf("got %d deleted messages, want 4", deleted)
+	}
+}
+
+func TestSqs_handleCloudTrailMsg(t *testing.T) {
+	const arn = "arn:aws:iam::123456789012:role/role-a"
+
+	denied := &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: sts:AssumeRole"}
+	throttled := &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}
+
+	tests := []struct {
+		name string
+		body *string
+
+		// assumeErr is returned when the source assumes the role, and stale adds a denial to the graph from an
+		// earlier test.
+		assumeErr error
+		stale     bool
+
+		wantNode   bool
+		wantState  creds.State
+		wantSource bool
+
+		// wantRefresh is whether the role is refreshed, and wantScheduled whether it is still scheduled after.
+		wantRefresh   bool
+		wantScheduled bool
+	}{
+		{name: "ignored", body: eventMsg("CreateRole", "role-a", ""), wantNode: true, wantState: creds.ActiveState, wantSource: true, wantScheduled: true},
+		{name: "revoke", body: revokeSessMsg("role-a"), wantNode: true, wantState: creds.ActiveState, wantSource: true, wantRefresh: true},
+		{name: "deny", body: eventMsg("AttachRolePolicy", "role-a", "arn:aws:iam::aws:policy/AWSDenyAll"), wantNode: true, wantState: creds.FailedState, wantSource: true, wantScheduled: true},
+		{name: "deny some actions", body: eventMsg("AttachRolePolicy", "role-a", "arn:aws:iam::123456789012:policy/DenyS3"), wantNode: true, wantState: creds.ActiveState, wantSource: true, wantScheduled: true},
+		{name: "allow", body: eventMsg("AttachRolePolicy", "role-a", "arn:aws:iam::aws:policy/ReadOnlyAccess"), wantNode: true, wantState: creds.ActiveState, wantSource: true, wantScheduled: true},
+		{name: "unknown policy", body: eventMsg("AttachRolePolicy", "role-a", "arn:aws:iam::123456789012:policy/Missing"), wantNode: true, wantState: creds.ActiveState, wantSource: true, wantScheduled: true},
+		{name: "trust allowed", body: eventMsg("UpdateAssumeRolePolicy", "role-a", ""), wantNode: true, wantState: creds.ActiveState, wantSource: true, wantScheduled: true},
+		{name: "trust denied", body: eventMsg("UpdateAssumeRolePolicy", "role-a", ""), assumeErr: denied, wantNode: true, wantState: creds.ActiveState, wantSource: false, wantScheduled: true},
+		{name: "trust throttled", body: eventMsg("UpdateAssumeRolePolicy", "role-a", ""), assumeErr: throttled, stale: true, wantNode: true, wantState: creds.ActiveState, wantSource: true, wantScheduled: true},
+		{name: "delete", body: eventMsg("DeleteRole", "role-a", ""), wantNode: false, wantSource: false},
+		{name: "scp allowed", body: updateScpMsg(), wantNode: true, wantState: creds.ActiveState, wantSource: true, wantScheduled: true},
+		{name: "scp denied", body: updateScpMsg(), assumeErr: denied, wantNode: true, wantState: creds.ActiveState, wantSource: false, wantScheduled: true},
+		{name: "iam policy updated", body: eventMsg("UpdatePolicy", "", ""), assumeErr: denied, wantNode: true, wantState: creds.ActiveState, wantSource: true, wantScheduled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := graph.NewDirectedGraph[*creds.Config]()
+			source, client := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/source", g))
+			target, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceAssumeRole, "role/role-a", g))
+			g.AddNode(source)
+			g.AddNode(target)
+			g.AddEdge(source, target)
+
+			if tt.assumeErr != nil {
+				client.Errors = map[string]error{arn: tt.assumeErr}
+			}
+			if tt.stale {
+				g.AddDenial(source, arn, graph.Denial{Code: "AccessDenied", Reason: creds.ReasonAccessDenied})
+			}
+
+			access := utils.Must(NewTestAccess(g))
+			refreshed := make(chan scheduler.Event, 1)
+			access.Scheduler.Subscribe(func(e scheduler.Event) { refreshed <- e })
+			access.Scheduler.Schedule(target, time.Now().Add(time.Hour))
+
+			if err := access.handleCloudTrailMsg(ctx, g.GetNode, sqsTypes.Message{Body: tt.body}); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantRefresh {
+				select {
+				case e := <-refreshed:
+					if e.Id != arn || e.Err != nil {
+						t.Errorf("got %+v, want a refresh of %s", e, arn)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for the refresh")
+				}
+			}
+			if got := access.Scheduler.Len() == 1; got != tt.wantScheduled {
+				t.Errorf("got scheduled %v, want %v", got, tt.wantScheduled)
+			}
+
+			node, ok := g.GetNode(arn)
+			if ok != tt.wantNode {
+				t.Fatalf("got node %v, want %v", ok, tt.wantNode)
+			} else if ok && node.Value().State() != tt.wantState {
+				t.Errorf("got state %v, want %v", node.Value().State(), tt.wantState)
+			}
+
+			src, _ := g.GetNode(source.Id())
+			if _, ok := src.Outbound()[arn]; ok != tt.wantSource {
+				t.Errorf("got edge from source %v, want %v", ok, tt.wantSource)
+			}
+		})
+	}
+}
+
+// testPolicies are the default versions of the managed policies returned by mockPolicyAPI, keyed by ARN.
+var testPolicies = map[string]string{
+	"arn:aws:iam::aws:policy/AWSDenyAll":      `{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Action": "*", "Resource": "*"}]}`,
+	"arn:aws:iam::aws:policy/ReadOnlyAccess":  `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": ["iam:Get*", "s3:Get*"], "Resource": "*"}]}`,
+	"arn:aws:iam::123456789012:policy/DenyS3": `{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Action": "s3:*", "Resource": "*"}]}`,
+}
+
+type mockPolicyAPI map[string]string
+
+func (m mockPolicyAPI) GetPolicy(_ context.Context, params *iam.GetPolicyInput, _ ...func(*iam.Options)) (*iam.GetPolicyOutput, error) {
+	if _, ok := m[aws.ToString(params.PolicyArn)]; !ok {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchEntity"}
+	}
+	return &iam.GetPolicyOutput{Policy: &iamTypes.Policy{Arn: params.PolicyArn, DefaultVersionId: aws.String("v2")}}, nil
+}
+
+func (m mockPolicyAPI) GetPolicyVersion(_ context.Context, params *iam.GetPolicyVersionInput, _ ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error) {
+	doc, ok := m[aws.ToString(params.PolicyArn)]
+	if !ok || aws.ToString(params.VersionId) != "v2" {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchEntity"}
+	}
+
+	// Documents returned by the IAM API are URL encoded.
+	return &iam.GetPolicyVersionOutput{PolicyVersion: &iamTypes.PolicyVersion{Document: aws.String(url.QueryEscape(doc))}}, nil
 }
 
 func revokeSessMsg(name string) *string {
@@ -128,11 +261,69 @@ func revokeSessMsg(name string) *string {
 	  }
 	}`)
 }
+
+func eventMsg(eventName, roleName, policyArn string) *string {
+	return aws.String(`{
+	  "detail-type": "AWS API Call via CloudTrail",
+	  "source": "aws.iam",
+	  "account": "123456789012",
+	  "detail": {
+		"eventSource": "iam.amazonaws.com",
+		"eventName": "` + eventName + `",
+		"requestParameters": {
+		  "roleName": "` + roleName + `",
+		  "policyArn": "` + policyArn + `"
+		},
+		"recipientAccountId": "123456789012"
+	  }
+	}`)
+}
+
+// updateScpMsg is an update to a service control policy, which can deny access to any principal in the organization.
+func updateScpMsg() *string {
+	return aws.String(`{
+	  "detail-type": "AWS API Call via CloudTrail",
+	  "source": "aws.organizations",
+	  "account": "123456789012",
+	  "detail": {
+		"eventSource": "organizations.amazonaws.com",
+		"eventName": "UpdatePolicy",
+		"requestParameters": {
+		  "policyId": "p-examplepolicyid111"
+		},
+		"recipientAccountId": "123456789012"
+	  }
+	}`)
+}
+
+func TestCloudTrailEvent_roleArn(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		want   string
+	}{
+		{name: "aws", caller: "arn:aws:iam::123456789012:user/admin", want: "arn:aws:iam::123456789012:role/role-a"},
+		{name: "govcloud", caller: "arn:aws-us-gov:sts::123456789012:assumed-role/admin/session", want: "arn:aws-us-gov:iam::123456789012:role/role-a"},
+		{name: "service", caller: "", want: "arn:aws:iam::123456789012:role/role-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e CloudTrailEvent
+			e.Detail.UserIdentity.Arn = tt.caller
+			e.Detail.RecipientAccountId = testAccountId
+			e.Detail.RequestParameters.RoleName = aws.String("role-a")
+			if got := e.roleArn(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestAccess_Full(t *testing.T) {
 	t.Skip("Requires AWS credentials")
 
 	g := graph.NewDirectedGraph[*creds.Config]()
-	source, err := creds.NewTestAssumesAllConfig(creds.SourceAssumeRole, "role/source", g)
+	source, _, err := creds.NewTestAssumesAllConfig(creds.SourceAssumeRole, "role/source", g)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -175,17 +366,19 @@ func NewTestAccess(g *graph.Graph[*creds.Config]) (*Sqs, error) {
 	return &Sqs{
 		GlobalPluginArgs: types.GlobalPluginArgs{
 			Region:     "us-east-1",
-			Access:     utils.Iterator[*creds.Config]{},
-			FoundRoles: utils.Iterator[types.Role]{},
+			Access:     utils.NewIterator[*creds.Config](),
+			FoundRoles: utils.NewIterator[types.Role](),
 			Graph:      g,
-			Scope:      []string{testAccountId},
+			Scope:      utils.NewScope([]string{testAccountId}),
 			ProgramDir: testPath,
+			Scheduler:  scheduler.New(ctx, scheduler.Options{Workers: 1}),
 			PrimaryAwsConfig: aws.Config{
 				Region:      testRegion,
 				Credentials: credentials.NewStaticCredentialsProvider("key", "secret", "session"),
 			},
 		},
 		SqsQueue: *sqsQueue,
+		IAM:      mockPolicyAPI(testPolicies),
 		cfgs:     map[string][]chan int{},
 	}, nil
 }
@@ -0,0 +1,275 @@
+package plugins
+
+import (
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/policy"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"sort"
+	"sync"
+	"time"
+)
+
+var users = flag.Bool("users", false, `
+List the IAM users of each account that is accessed and add them to the graph. iam:SimulatePrincipalPolicy is used to
+find principals that could create an access key or login profile for each user, and roles that each user could assume.
+Nothing is created, these are shown as potential edges in the report.
+`)
+
+// CredentialActions are the actions that give access to an IAM user when allowed on it.
+var CredentialActions = []string{"iam:CreateAccessKey", "iam:CreateLoginProfile", "iam:UpdateLoginProfile"}
+
+// simulateBatchSize is the number of resources passed to each iam:SimulatePrincipalPolicy call.
+const simulateBatchSize = 20
+
+// UsersAPI is the part of the IAM client used to list users and run simulations.
+type UsersAPI interface {
+	iam.ListUsersAPIClient
+	iam.SimulatePrincipalPolicyAPIClient
+}
+
+func NewUsers(_ utils.Context, args types.GlobalPluginArgs) types.Plugin {
+	return &Users{
+		GlobalPluginArgs: args,
+		NewAPI: func(cfg *creds.Config) UsersAPI {
+			return iam.NewFromConfig(cfg.Config)
+		},
+		m:       &sync.Mutex{},
+		sources: map[string][]*creds.Config{},
+		users:   map[string][]string{},
+		listers: map[string]*creds.Config{},
+	}
+}
+
+// Users adds the IAM users of each accessed account to the graph along with potential edges to and from them.
+type Users struct {
+	types.GlobalPluginArgs
+
+	// NewAPI returns the IAM client used with cfg, it is replaced when testing.
+	NewAPI func(cfg *creds.Config) UsersAPI
+
+	m *sync.Mutex
+
+	// sources and users are the accessed principals and the discovered users of each account, every source is checked
+	// against every user in the same account.
+	sources map[string][]*creds.Config
+	users   map[string][]string
+
+	// listers are the principals that listed the users of each account, they're also used to run simulations for the
+	// users. Accounts without one are listed again with the next principal in them.
+	listers map[string]*creds.Config
+
+	// roles are the roles with a known trust policy, these are checked against every user.
+	roles []types.Role
+}
+
+func (u *Users) Name() string { return "users" }
+func (u *Users) Enabled() (bool, string) {
+	if !*users {
+		return false, "pass -users to add iam users to the graph"
+	}
+	return true, "using iam.ListUsers to add users to the graph"
+}
+
+func (u *Users) Run(ctx utils.Context) {
+	u.Access.Walk(func(cfg *creds.Config) {
+		u.listUsers(ctx, cfg)
+		u.addSource(ctx, cfg)
+	})
+
+	u.FoundRoles.Walk(func(role types.Role) {
+		u.addRole(ctx, role)
+	})
+}
+
+// listUsers adds each in-scope user in the account of cfg to the graph, unless they were already listed.
+func (u *Users) listUsers(ctx utils.Context, cfg *creds.Config) {
+	u.m.Lock()
+	_, listed := u.listers[cfg.Account()]
+	u.m.Unlock()
+	if listed {
+		return
+	}
+
+	var found []string
+	paginator := iam.NewListUsersPaginator(u.NewAPI(cfg), &iam.ListUsersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			// Most principals aren't allowed to list users, so denials are expected and the next principal in the
+			// account is tried.
+			if _, reason := creds.ClassifyAssumeRoleError(err, nil); reason == creds.ReasonAccessDenied {
+				ctx.Debug.Printf("users: unable to list users in %s with %s: %s\n", cfg.Account(), cfg.Arn(), err)
+			} else {
+				ctx.Error.Printf("users: listing users in %s with %s: %s\n", cfg.Account(), cfg.Arn(), err)
+			}
+			return
+		}
+
+		for _, user := range page.Users {
+			arn := aws.ToString(user.Arn)
+			if !u.Scope.Contains(arn) {
+				ctx.Debug.Println("not in scope, skipping:", arn)
+				continue
+			}
+			found = append(found, arn)
+		}
+	}
+
+	u.m.Lock()
+	if _, listed := u.listers[cfg.Account()]; listed {
+		u.m.Unlock()
+		return
+	}
+	u.listers[cfg.Account()] = cfg
+	u.users[cfg.Account()] = found
+	sources := append([]*creds.Config{}, u.sources[cfg.Account()]...)
+	roles := append([]types.Role{}, u.roles...)
+	u.m.Unlock()
+
+	for _, arn := range found {
+		if _, ok := u.Graph.GetNode(arn); ok {
+			continue
+		}
+		node, err := creds.NewUserConfig(ctx, u.Region, arn, u.Graph)
+		if err != nil {
+			ctx.Error.Printf("users: %s\n", err)
+			continue
+		}
+		u.Graph.AddNode(node)
+		ctx.Debug.Println("users: found:", arn)
+	}
+
+	for _, src := range sources {
+		u.simulateCredentials(ctx, src, found)
+	}
+	for _, user := range found {
+		u.simulateAssumeRole(ctx, cfg, user, roles)
+	}
+}
+
+// addSource checks whether cfg can create credentials for the users of its account.
+func (u *Users) addSource(ctx utils.Context, cfg *creds.Config) {
+	u.m.Lock()
+	u.sources[cfg.Account()] = append(u.sources[cfg.Account()], cfg)
+	found := append([]string{}, u.users[cfg.Account()]...)
+	u.m.Unlock()
+
+	u.simulateCredentials(ctx, cfg, found)
+}
+
+// addRole checks whether each user the role's trust policy allows can assume it.
+func (u *Users) addRole(ctx utils.Context, role types.Role) {
+	if trust, err := role.TrustPolicy(); err != nil || trust == nil {
+		return
+	}
+
+	u.m.Lock()
+	u.roles = append(u.roles, role)
+	found := map[string][]string{}
+	for account, arns := range u.users {
+		found[account] = append([]string{}, arns...)
+	}
+	listers := map[string]*creds.Config{}
+	for account, cfg := range u.listers {
+		listers[account] = cfg
+	}
+	u.m.Unlock()
+
+	for account, arns := range found {
+		for _, user := range arns {
+			u.simulateAssumeRole(ctx, listers[account], user, []types.Role{role})
+		}
+	}
+}
+
+// simulateCredentials records a potential edge from src to each user that it is allowed to create credentials for.
+func (u *Users) simulateCredentials(ctx utils.Context, src *creds.Config, users []string) {
+	var targets []string
+	for _, user := range users {
+		if user != src.Id() {
+			targets = append(targets, user)
+		}
+	}
+
+	allowed, err := Simulate(ctx, u.NewAPI(src), src.Id(), CredentialActions, targets)
+	if err != nil {
+		ctx.Debug.Printf("users: unable to simulate %s: %s\n", src.Id(), err)
+		return
+	}
+
+	for user, actions := range allowed {
+		p := graph.Potential{Kind: graph.PotentialCredentials, Actions: actions, DiscoveredAt: time.Now()}
+		if u.Graph.AddPotential(src.Id(), user, p) {
+			ctx.Info.Printf("users: %s can create credentials for %s\n", src.Id(), user)
+		}
+	}
+}
+
+// simulateAssumeRole records a potential edge from user to each of the roles that trusts it and that its identity
+// policies allow it to assume. The simulation is run with cfg, which needs to be in the same account as the user.
+func (u *Users) simulateAssumeRole(ctx utils.Context, cfg *creds.Config, user string, roles []types.Role) {
+	var targets []string
+	for _, role := range roles {
+		trust, err := role.TrustPolicy()
+		if err != nil || trust == nil {
+			continue
+		}
+		if decision, _ := trust.Evaluate(policy.Request{Principal: user}); decision == policy.Allow {
+			targets = append(targets, role.Id())
+		}
+	}
+
+	allowed, err := Simulate(ctx, u.NewAPI(cfg), user, []string{policy.ActionAssumeRole}, targets)
+	if err != nil {
+		ctx.Debug.Printf("users: unable to simulate %s: %s\n", user, err)
+		return
+	}
+
+	for role, actions := range allowed {
+		p := graph.Potential{Kind: graph.PotentialAssumeRole, Actions: actions, DiscoveredAt: time.Now()}
+		if u.Graph.AddPotential(user, role, p) {
+			ctx.Info.Printf("users: %s can assume %s\n", user, role)
+		}
+	}
+}
+
+// Simulate runs iam:SimulatePrincipalPolicy for the principal arn with api and returns the allowed actions keyed by the
+// resource they're allowed on.
+func Simulate(ctx utils.Context, api iam.SimulatePrincipalPolicyAPIClient, arn string, actions, resources []string) (map[string][]string, error) {
+	allowed := map[string][]string{}
+	for len(resources) != 0 {
+		batch := resources[:min(len(resources), simulateBatchSize)]
+		resources = resources[len(batch):]
+
+		paginator := iam.NewSimulatePrincipalPolicyPaginator(api, &iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(arn),
+			ActionNames:     actions,
+			ResourceArns:    batch,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("Simulate(): %w", err)
+			}
+
+			for _, r := range page.EvaluationResults {
+				if r.EvalDecision == iamTypes.PolicyEvaluationDecisionTypeAllowed {
+					resource := aws.ToString(r.EvalResourceName)
+					allowed[resource] = append(allowed[resource], aws.ToString(r.EvalActionName))
+				}
+			}
+		}
+	}
+
+	for _, actions := range allowed {
+		sort.Strings(actions)
+	}
+	return allowed, nil
+}
@@ -0,0 +1,202 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"sync"
+	"testing"
+)
+
+// fakeUsersAPI lists users and allows the actions in allowed, keyed by the principal and then the resource. ListUsers
+// fails with AccessDenied when denied is set, and the batch of resources passed to each SimulatePrincipalPolicy call
+// is appended to batches.
+type fakeUsersAPI struct {
+	users   []string
+	denied  bool
+	allowed map[string]map[string][]string
+	batches *[][]string
+}
+
+func (f fakeUsersAPI) ListUsers(context.Context, *iam.ListUsersInput, ...func(*iam.Options)) (*iam.ListUsersOutput, error) {
+	if f.denied {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: iam:ListUsers"}
+	}
+
+	var resp []iamTypes.User
+	for _, arn := range f.users {
+		resp = append(resp, iamTypes.User{Arn: aws.String(arn)})
+	}
+	return &iam.ListUsersOutput{Users: resp}, nil
+}
+
+func (f fakeUsersAPI) SimulatePrincipalPolicy(_ context.Context, in *iam.SimulatePrincipalPolicyInput, _ ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error) {
+	if f.batches != nil {
+		*f.batches = append(*f.batches, in.ResourceArns)
+	}
+
+	var results []iamTypes.EvaluationResult
+	for _, resource := range in.ResourceArns {
+		for _, action := range in.ActionNames {
+			decision := iamTypes.PolicyEvaluationDecisionTypeImplicitDeny
+			for _, a := range f.allowed[aws.ToString(in.PolicySourceArn)][resource] {
+				if a == action {
+					decision = iamTypes.PolicyEvaluationDecisionTypeAllowed
+				}
+			}
+			results = append(results, iamTypes.EvaluationResult{
+				EvalActionName:   aws.String(action),
+				EvalResourceName: aws.String(resource),
+				EvalDecision:     decision,
+			})
+		}
+	}
+	return &iam.SimulatePrincipalPolicyOutput{EvaluationResults: results}, nil
+}
+
+func testUser(name string) string {
+	return "arn:aws:iam::123456789012:user/" + name
+}
+
+// trustingRole returns a role with a trust policy that allows principal to assume it.
+func trustingRole(name, principal string) types.Role {
+	role := types.NewRole(testRole(name))
+	role.AssumeRolePolicyDocument = aws.String(`{"Version": "2012-10-17", "Statement": [
+		{"Effect": "Allow", "Principal": {"AWS": "` + principal + `"}, "Action": "sts:AssumeRole"}
+	]}`)
+	return role
+}
+
+// TestUsers ensures every source is checked against every user and every user against every role, regardless of the
+// order they're found in, and that users are listed again when the first principal isn't allowed to.
+func TestUsers(t *testing.T) {
+	var batches [][]string
+	api := fakeUsersAPI{
+		users: []string{testUser("admin"), testUser("alice"), testUser("bob")},
+		allowed: map[string]map[string][]string{
+			testUser("limited"): {testUser("alice"): {"iam:CreateAccessKey"}},
+			testUser("admin"): {
+				testUser("admin"): {"iam:CreateAccessKey"},
+				testUser("alice"): {"iam:CreateAccessKey", "iam:CreateLoginProfile"},
+				testUser("bob"):   {"iam:CreateAccessKey"},
+			},
+			testUser("late"):  {testUser("bob"): {"iam:UpdateLoginProfile"}},
+			testUser("alice"): {testRole("early"): {"sts:AssumeRole"}},
+			testUser("bob"):   {testRole("late"): {"sts:AssumeRole"}, testRole("early"): {"sts:AssumeRole"}},
+		},
+		batches: &batches,
+	}
+
+	u := &Users{
+		GlobalPluginArgs: types.GlobalPluginArgs{
+			Graph:      graph.NewDirectedGraph[*creds.Config](),
+			Access:     utils.NewIterator[*creds.Config](),
+			FoundRoles: utils.NewIterator[types.Role](),
+			Scope:      utils.NewScope([]string{testAccountId}),
+		},
+		NewAPI: func(cfg *creds.Config) UsersAPI {
+			f := api
+			f.denied = cfg.Arn() == testUser("limited")
+			return f
+		},
+		m:       &sync.Mutex{},
+		sources: map[string][]*creds.Config{},
+		users:   map[string][]string{},
+		listers: map[string]*creds.Config{},
+	}
+	u.Run(ctx)
+
+	newCfg := func(name string) *creds.Config {
+		cfg, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/"+name, nil))
+		return cfg
+	}
+
+	// The role and the limited source are found before the users are listed, and the late ones after.
+	u.FoundRoles.Add(trustingRole("early", testUser("alice")))
+	u.FoundRoles.Add(types.NewRole(testRole("unknown-trust")))
+	u.Access.Add(newCfg("limited"))
+	if _, ok := u.listers[testAccountId]; ok {
+		t.Fatal("expected the account to not be listed when access is denied")
+	}
+
+	u.Access.Add(newCfg("admin"))
+	u.FoundRoles.Add(trustingRole("late", testUser("bob")))
+	u.Access.Add(newCfg("late"))
+
+	for _, arn := range api.users {
+		if _, ok := u.Graph.GetNode(arn); !ok {
+			t.Errorf("expected %s to be added to the graph", arn)
+		}
+	}
+
+	credentials := func(actions ...string) []graph.Potential {
+		return []graph.Potential{{Kind: graph.PotentialCredentials, Actions: actions}}
+	}
+	assumeRole := []graph.Potential{{Kind: graph.PotentialAssumeRole, Actions: []string{"sts:AssumeRole"}}}
+	want := map[string]map[string][]graph.Potential{
+		testUser("limited"): {testUser("alice"): credentials("iam:CreateAccessKey")},
+		testUser("admin"): {
+			testUser("alice"): credentials("iam:CreateAccessKey", "iam:CreateLoginProfile"),
+			testUser("bob"):   credentials("iam:CreateAccessKey"),
+		},
+		testUser("late"):  {testUser("bob"): credentials("iam:UpdateLoginProfile")},
+		testUser("alice"): {testRole("early"): assumeRole},
+		testUser("bob"):   {testRole("late"): assumeRole},
+	}
+	got := u.Graph.PotentialEdges()
+	if diff := cmp.Diff(got, want, cmpopts.IgnoreFields(graph.Potential{}, "DiscoveredAt")); diff != "" {
+		t.Errorf("PotentialEdges() (-got +want):\n%s", diff)
+	}
+}
+
+// TestSimulate ensures resources are simulated in batches of simulateBatchSize and the allowed actions are merged.
+func TestSimulate(t *testing.T) {
+	var resources []string
+	allowed := map[string][]string{}
+	for i := 0; i < 45; i++ {
+		arn := testUser(fmt.Sprintf("user-%d", i))
+		resources = append(resources, arn)
+		if i%10 == 0 {
+			allowed[arn] = []string{"iam:CreateLoginProfile", "iam:CreateAccessKey"}
+		}
+	}
+
+	var batches [][]string
+	api := fakeUsersAPI{allowed: map[string]map[string][]string{testUser("source"): allowed}, batches: &batches}
+	got, err := Simulate(ctx, api, testUser("source"), []string{"iam:CreateLoginProfile", "iam:CreateAccessKey"}, resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	for _, batch := range batches {
+		sizes = append(sizes, len(batch))
+	}
+	if diff := cmp.Diff(sizes, []int{20, 20, 5}); diff != "" {
+		t.Errorf("batch sizes (-got +want):\n%s", diff)
+	}
+
+	want := map[string][]string{}
+	for arn := range allowed {
+		want[arn] = []string{"iam:CreateAccessKey", "iam:CreateLoginProfile"}
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Simulate() (-got +want):\n%s", diff)
+	}
+
+	batches = nil
+	if _, err := Simulate(ctx, api, testUser("source"), CredentialActions, nil); err != nil {
+		t.Fatal(err)
+	} else if len(batches) != 0 {
+		t.Errorf("expected no calls without resources, got %d", len(batches))
+	}
+}
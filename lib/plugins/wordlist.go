@@ -0,0 +1,177 @@
+package plugins
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	guessRoles = flag.Bool("guess-roles", false, `
+Try common role names in every in-scope account, this can find roles in accounts where iam:ListRoles isn't allowed.
+Names of roles discovered in other accounts are tried as well since naming is usually consistent across an
+organization.
+`)
+	roleWordlist = flag.String("role-wordlist", "", `
+File containing role names to try with -guess-roles instead of the built-in list, one per line. Names may include a
+path, for example service-role/name. Implies -guess-roles.
+`)
+)
+
+// DefaultRoleNames are the role names tried by the wordlist plugin when -role-wordlist isn't used.
+//
+//go:embed roles.txt
+var DefaultRoleNames string
+
+func NewWordlist(ctx utils.Context, args types.GlobalPluginArgs) types.Plugin {
+	names := DefaultRoleNames
+	if *roleWordlist != "" {
+		b, err := os.ReadFile(*roleWordlist)
+		if err != nil {
+			ctx.Error.Fatalf("wordlist: %s", err)
+		}
+		names = string(b)
+	}
+
+	w := &Wordlist{
+		GlobalPluginArgs: args,
+		Names:            ParseWordlist(names),
+		m:                &sync.Mutex{},
+		accounts:         map[string]string{},
+		seen:             map[string]bool{},
+		guessed:          map[string]bool{},
+	}
+	for _, name := range w.Names {
+		w.seen[name] = true
+	}
+	return w
+}
+
+// ParseWordlist returns the role names in s, one per line. Blank lines and lines starting with # are ignored.
+func ParseWordlist(s string) []string {
+	var names []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.Trim(line, " \t\r/")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return utils.FilterDuplicates(names)
+}
+
+// Wordlist adds the ARNs of role names that may exist to FoundRoles for each in-scope account, the Assume plugin then
+// tests if they exist and can be assumed.
+type Wordlist struct {
+	types.GlobalPluginArgs
+	Names []string
+
+	m *sync.Mutex
+
+	// accounts and seen are the in-scope accounts and role names found so far, each name is tried in every account.
+	// Accounts are mapped to the partition of the ARN they were found in.
+	accounts map[string]string
+	seen     map[string]bool
+
+	// guessed holds the ARNs added by this plugin, these are skipped when collecting role names from FoundRoles.
+	guessed map[string]bool
+}
+
+func (w *Wordlist) Name() string { return "wordlist" }
+func (w *Wordlist) Enabled() (bool, string) {
+	if *roleWordlist != "" {
+		return true, fmt.Sprintf("trying role names from %s in each in-scope account", *roleWordlist)
+	} else if *guessRoles {
+		return true, fmt.Sprintf("trying %d common role names in each in-scope account", len(w.Names))
+	}
+	return false, "pass -guess-roles or -role-wordlist to try common role names in each in-scope account"
+}
+
+func (w *Wordlist) Run(ctx utils.Context) {
+	w.Access.Walk(func(cfg *creds.Config) {
+		// The accounts in the scope are only IDs, they're assumed to be in the same partition as the principals we
+		// have access to.
+		partition := utils.PartitionFromArn(cfg.Arn())
+		for _, account := range w.Scope.Accounts() {
+			w.addAccount(ctx, partition, account)
+		}
+		w.addAccount(ctx, partition, cfg.Account())
+	})
+
+	w.FoundRoles.Walk(func(role types.Role) {
+		account, err := utils.AccountIdFromArn(role.Id())
+		if err != nil {
+			return
+		}
+		w.addAccount(ctx, utils.PartitionFromArn(role.Id()), account)
+
+		if name, ok := roleName(role.Id()); ok {
+			w.addName(ctx, name, role.Id())
+		}
+	})
+}
+
+// addAccount tries every known role name in account if it is in scope and hasn't been seen before.
+func (w *Wordlist) addAccount(ctx utils.Context, partition, account string) {
+	if !w.Scope.Contains(fmt.Sprintf("arn:%s:iam::%s:root", partition, account)) {
+		return
+	}
+
+	w.m.Lock()
+	if _, ok := w.accounts[account]; ok {
+		w.m.Unlock()
+		return
+	}
+	w.accounts[account] = partition
+	names := utils.Keys(w.seen)
+	w.m.Unlock()
+
+	for _, name := range names {
+		w.guess(ctx, partition, account, name)
+	}
+}
+
+// addName tries the role name from arn in every known account if it hasn't been seen before.
+func (w *Wordlist) addName(ctx utils.Context, name, arn string) {
+	w.m.Lock()
+	if w.seen[name] || w.guessed[arn] {
+		w.m.Unlock()
+		return
+	}
+	w.seen[name] = true
+	accounts := maps.Clone(w.accounts)
+	w.m.Unlock()
+
+	ctx.Debug.Printf("wordlist: trying role name %s from %s in %d accounts\n", name, arn, len(accounts))
+	for account, partition := range accounts {
+		w.guess(ctx, partition, account, name)
+	}
+}
+
+func (w *Wordlist) guess(ctx utils.Context, partition, account, name string) {
+	arn := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, account, name)
+
+	w.m.Lock()
+	w.guessed[arn] = true
+	w.m.Unlock()
+
+	if w.FoundRoles.Add(types.NewRole(arn)) {
+		ctx.Debug.Println("wordlist: trying:", arn)
+	}
+}
+
+// roleName returns the name of the role including the path, false is returned if arn isn't the ARN of an IAM role.
+func roleName(arn string) (string, bool) {
+	p := strings.SplitN(arn, ":", 6)
+	if len(p) != 6 || p[2] != "iam" {
+		return "", false
+	}
+	return strings.CutPrefix(p[5], "role/")
+}
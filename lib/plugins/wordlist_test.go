@@ -0,0 +1,129 @@
+package plugins
+
+import (
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/google/go-cmp/cmp"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestParseWordlist(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{name: "empty", in: "", want: nil},
+		{name: "names", in: "Admin\nReadOnly\n", want: []string{"Admin", "ReadOnly"}},
+		{name: "comments and blank lines", in: "# roles\n\nAdmin\n  \n#ReadOnly\n", want: []string{"Admin"}},
+		{name: "whitespace and slashes", in: " Admin\t\r\n/service-role/deploy/\n", want: []string{"Admin", "service-role/deploy"}},
+		{name: "duplicates", in: "Admin\nAdmin\r\n", want: []string{"Admin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(ParseWordlist(tt.in), tt.want); diff != "" {
+				t.Errorf("ParseWordlist() (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRoleName(t *testing.T) {
+	tests := []struct {
+		arn    string
+		want   string
+		wantOk bool
+	}{
+		{arn: "arn:aws:iam::123456789012:role/Admin", want: "Admin", wantOk: true},
+		{arn: "arn:aws:iam::123456789012:role/service-role/deploy", want: "service-role/deploy", wantOk: true},
+		{arn: "arn:aws-us-gov:iam::123456789012:role/Admin", want: "Admin", wantOk: true},
+		{arn: "arn:aws:iam::123456789012:user/alice"},
+		{arn: "arn:aws:sts::123456789012:assumed-role/Admin/session"},
+		{arn: "lambda.amazonaws.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			got, ok := roleName(tt.arn)
+			if ok != tt.wantOk {
+				t.Fatalf("got %v, want %v", ok, tt.wantOk)
+			} else if ok && got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestWordlist(accounts []string, names ...string) *Wordlist {
+	w := &Wordlist{
+		GlobalPluginArgs: types.GlobalPluginArgs{
+			Access:     utils.NewIterator[*creds.Config](),
+			FoundRoles: utils.NewIterator[types.Role](),
+			Scope:      utils.NewScope(accounts),
+		},
+		Names:    names,
+		m:        &sync.Mutex{},
+		accounts: map[string]string{},
+		seen:     map[string]bool{},
+		guessed:  map[string]bool{},
+	}
+	for _, name := range names {
+		w.seen[name] = true
+	}
+	return w
+}
+
+func foundRoles(w *Wordlist) []string {
+	var resp []string
+	w.FoundRoles.Walk(func(role types.Role) {
+		resp = append(resp, role.Id())
+	})
+	sort.Strings(resp)
+	return resp
+}
+
+// TestWordlist ensures names are tried in every in-scope account, and that names of roles found in any account are
+// reused in the in-scope ones only.
+func TestWordlist(t *testing.T) {
+	w := newTestWordlist([]string{testAccountId, "222222222222"}, "Admin")
+
+	g := graph.NewDirectedGraph[*creds.Config]()
+	cfg, _ := utils.Must2(creds.NewTestAssumesAllConfig(creds.SourceProfile, "user/source", g))
+	w.Access.Add(cfg)
+	w.Run(ctx)
+
+	w.FoundRoles.Add(types.NewRole("arn:aws:iam::222222222222:role/service-role/deploy"))
+	w.FoundRoles.Add(types.NewRole("arn:aws:iam::333333333333:role/other"))
+
+	want := []string{
+		"arn:aws:iam::123456789012:role/Admin",
+		"arn:aws:iam::123456789012:role/other",
+		"arn:aws:iam::123456789012:role/service-role/deploy",
+		"arn:aws:iam::222222222222:role/Admin",
+		"arn:aws:iam::222222222222:role/other",
+		"arn:aws:iam::222222222222:role/service-role/deploy",
+		"arn:aws:iam::333333333333:role/other",
+	}
+	if diff := cmp.Diff(foundRoles(w), want); diff != "" {
+		t.Errorf("FoundRoles (-got +want):\n%s", diff)
+	}
+}
+
+// TestWordlist_Partition ensures guessed roles are in the partition of the ARN the account was found in.
+func TestWordlist_Partition(t *testing.T) {
+	w := newTestWordlist([]string{"444444444444"}, "Admin")
+	w.Run(ctx)
+
+	w.FoundRoles.Add(types.NewRole("arn:aws-us-gov:iam::444444444444:role/gov"))
+
+	want := []string{
+		"arn:aws-us-gov:iam::444444444444:role/Admin",
+		"arn:aws-us-gov:iam::444444444444:role/gov",
+	}
+	if diff := cmp.Diff(foundRoles(w), want); diff != "" {
+		t.Errorf("FoundRoles (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,265 @@
+package policy
+
+import (
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conditions is the Condition element of a statement, it maps condition operators to condition keys and the values
+// they are compared with.
+type Conditions map[string]map[string]StringList
+
+// Evaluate returns true if every condition matches the request's condition keys, keys must be lowercase.
+func (c Conditions) Evaluate(keys map[string][]string) (bool, error) {
+	for _, op := range sortedKeys(c) {
+		for _, key := range sortedKeys(c[op]) {
+			values, present := keys[strings.ToLower(key)]
+			ok, err := evaluateCondition(op, c[op][key], values, present && len(values) != 0, keys)
+			if err != nil {
+				return false, err
+			} else if !ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type operator struct {
+	base string
+
+	// forAnyValue and forAllValues are set by the ForAnyValue: and ForAllValues: set operator prefixes.
+	forAnyValue  bool
+	forAllValues bool
+
+	// ifExists is set by the IfExists suffix, the condition matches if the key isn't in the request.
+	ifExists bool
+}
+
+func parseOperator(op string) operator {
+	var o operator
+	if prefix := "ForAnyValue:"; len(op) > len(prefix) && strings.EqualFold(op[:len(prefix)], prefix) {
+		o.forAnyValue = true
+		op = op[len(prefix):]
+	} else if prefix := "ForAllValues:"; len(op) > len(prefix) && strings.EqualFold(op[:len(prefix)], prefix) {
+		o.forAllValues = true
+		op = op[len(prefix):]
+	}
+	if op != "Null" && strings.HasSuffix(op, "IfExists") {
+		o.ifExists = true
+		op = strings.TrimSuffix(op, "IfExists")
+	}
+	o.base = op
+	return o
+}
+
+// matchFunc compares a value from the policy with a value from the request.
+type matchFunc func(policy, request string) bool
+
+// operators maps the base condition operators to how values are compared and whether the operator is negated.
+var operators = map[string]struct {
+	match   matchFunc
+	negated bool
+}{
+	"StringEquals":              {match: stringEquals},
+	"StringNotEquals":           {match: stringEquals, negated: true},
+	"StringEqualsIgnoreCase":    {match: strings.EqualFold},
+	"StringNotEqualsIgnoreCase": {match: strings.EqualFold, negated: true},
+	"StringLike":                {match: utils.WildcardMatchCase},
+	"StringNotLike":             {match: utils.WildcardMatchCase, negated: true},
+	"NumericEquals":             {match: numeric(func(p, r float64) bool { return r == p })},
+	"NumericNotEquals":          {match: numeric(func(p, r float64) bool { return r == p }), negated: true},
+	"NumericLessThan":           {match: numeric(func(p, r float64) bool { return r < p })},
+	"NumericLessThanEquals":     {match: numeric(func(p, r float64) bool { return r <= p })},
+	"NumericGreaterThan":        {match: numeric(func(p, r float64) bool { return r > p })},
+	"NumericGreaterThanEquals":  {match: numeric(func(p, r float64) bool { return r >= p })},
+	"DateEquals":                {match: date(func(p, r time.Time) bool { return r.Equal(p) })},
+	"DateNotEquals":             {match: date(func(p, r time.Time) bool { return r.Equal(p) }), negated: true},
+	"DateLessThan":              {match: date(func(p, r time.Time) bool { return r.Before(p) })},
+	"DateLessThanEquals":        {match: date(func(p, r time.Time) bool { return !r.After(p) })},
+	"DateGreaterThan":           {match: date(func(p, r time.Time) bool { return r.After(p) })},
+	"DateGreaterThanEquals":     {match: date(func(p, r time.Time) bool { return !r.Before(p) })},
+	"Bool":                      {match: strings.EqualFold},
+	"BinaryEquals":              {match: stringEquals},
+	"IpAddress":                 {match: ipAddress},
+	"NotIpAddress":              {match: ipAddress, negated: true},
+	"ArnEquals":                 {match: utils.WildcardMatchCase},
+	"ArnLike":                   {match: utils.WildcardMatchCase},
+	"ArnNotEquals":              {match: utils.WildcardMatchCase, negated: true},
+	"ArnNotLike":                {match: utils.WildcardMatchCase, negated: true},
+}
+
+// evaluateCondition evaluates a single condition operator and key. The rules follow the IAM documentation:
+//
+//   - Values in the policy are OR'd, the condition matches if any of them match.
+//   - Missing keys don't match, except for negated operators, IfExists and ForAllValues.
+//   - ForAnyValue matches if any request value matches, ForAllValues if every request value does.
+//   - Null checks whether the key is missing rather than comparing values.
+func evaluateCondition(op string, policyValues, requestValues []string, present bool, keys map[string][]string) (bool, error) {
+	o := parseOperator(op)
+
+	if o.base == "Null" {
+		for _, v := range policyValues {
+			if isNull, err := strconv.ParseBool(v); err != nil {
+				return false, fmt.Errorf("invalid value for Null condition: %s", v)
+			} else if isNull != present {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	def, ok := operators[o.base]
+	if !ok {
+		return false, fmt.Errorf("unsupported condition operator: %s", op)
+	}
+
+	if !present {
+		switch {
+		case o.ifExists, o.forAllValues:
+			return true, nil
+		case o.forAnyValue:
+			return false, nil
+		default:
+			return def.negated, nil
+		}
+	}
+
+	// matches returns whether a single request value satisfies the condition.
+	matches := func(request string) bool {
+		for _, p := range policyValues {
+			if def.match(substituteVariables(p, keys), request) {
+				return !def.negated
+			}
+		}
+		return def.negated
+	}
+
+	switch {
+	case o.forAllValues:
+		for _, v := range requestValues {
+			if !matches(v) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case def.negated && !o.forAnyValue:
+		// Without a set operator a negated operator requires that none of the request values match.
+		for _, v := range requestValues {
+			if !matches(v) {
+				return false, nil
+			}
+		}
+		return true, nil
+	default:
+		for _, v := range requestValues {
+			if matches(v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+var variableRe = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// substituteVariables replaces policy variables like ${aws:username} with the value of the key from the request. The
+// special variables ${*}, ${?} and ${$} are replaced with the characters they escape, these are still treated as
+// wildcards by the Like operators.
+func substituteVariables(value string, keys map[string][]string) string {
+	if !strings.Contains(value, "${") {
+		return value
+	}
+
+	return variableRe.ReplaceAllStringFunc(value, func(v string) string {
+		name := v[2 : len(v)-1]
+		switch name {
+		case "*", "?", "$":
+			return name
+		}
+
+		// Variables can have a default value, ${aws:username, 'default'}.
+		def := ""
+		if i := strings.Index(name, ","); i != -1 {
+			def = strings.Trim(strings.TrimSpace(name[i+1:]), "'")
+			name = strings.TrimSpace(name[:i])
+		}
+		if values := keys[strings.ToLower(name)]; len(values) != 0 {
+			return values[0]
+		}
+		return def
+	})
+}
+
+func stringEquals(policy, request string) bool { return policy == request }
+
+func numeric(cmp func(policy, request float64) bool) matchFunc {
+	return func(policy, request string) bool {
+		p, err := strconv.ParseFloat(policy, 64)
+		if err != nil {
+			return false
+		}
+		r, err := strconv.ParseFloat(request, 64)
+		if err != nil {
+			return false
+		}
+		return cmp(p, r)
+	}
+}
+
+func date(cmp func(policy, request time.Time) bool) matchFunc {
+	return func(policy, request string) bool {
+		p, err := parseDate(policy)
+		if err != nil {
+			return false
+		}
+		r, err := parseDate(request)
+		if err != nil {
+			return false
+		}
+		return cmp(p, r)
+	}
+}
+
+// parseDate parses the date formats accepted by the date condition operators, ISO 8601 dates and epoch seconds.
+func parseDate(s string) (time.Time, error) {
+	if epoch, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s", s)
+}
+
+func ipAddress(policy, request string) bool {
+	ip := net.ParseIP(request)
+	if ip == nil {
+		return false
+	}
+	if !strings.Contains(policy, "/") {
+		return ip.Equal(net.ParseIP(policy))
+	}
+	_, network, err := net.ParseCIDR(policy)
+	if err != nil {
+		return false
+	}
+	return network.Contains(ip)
+}
@@ -0,0 +1,417 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"net/url"
+	"strings"
+)
+
+const (
+	EffectAllow = "Allow"
+	EffectDeny  = "Deny"
+
+	// ActionAssumeRole is the action evaluated by trust policies when no action is set on the request.
+	ActionAssumeRole = "sts:AssumeRole"
+)
+
+// Principal types, these are the keys used in the Principal element of a policy.
+const (
+	PrincipalAWS           = "AWS"
+	PrincipalService       = "Service"
+	PrincipalFederated     = "Federated"
+	PrincipalCanonicalUser = "CanonicalUser"
+)
+
+// Document is a policy such as a role's AssumeRolePolicyDocument, for example:
+//
+//	{
+//	    "Version": "2012-10-17",
+//	    "Statement": [
+//	        {
+//	            "Effect": "Allow",
+//	            "Principal": {
+//	                "AWS": "arn:aws:iam::336983520827:root"
+//	            },
+//	            "Action": "sts:AssumeRole",
+//	            "Condition": {
+//	                "StringEquals": {"sts:ExternalId": "example"}
+//	            }
+//	        }
+//	    ]
+//	}
+type Document struct {
+	Version   string
+	Id        string `json:",omitempty"`
+	Statement Statements
+}
+
+// Parse parses a policy document, documents returned by the IAM API are URL encoded and are decoded first.
+func Parse(doc string) (*Document, error) {
+	if !strings.HasPrefix(strings.TrimSpace(doc), "{") {
+		decoded, err := url.QueryUnescape(doc)
+		if err != nil {
+			return nil, fmt.Errorf("Parse(): url decoding policy: %w", err)
+		}
+		doc = decoded
+	}
+
+	var d Document
+	if err := json.Unmarshal([]byte(doc), &d); err != nil {
+		return nil, fmt.Errorf("Parse(): %w", err)
+	}
+	return &d, nil
+}
+
+// Statements can be a single statement or a list of them.
+type Statements []Statement
+
+func (s *Statements) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) != 0 && b[0] == '{' {
+		var stmt Statement
+		if err := json.Unmarshal(b, &stmt); err != nil {
+			return err
+		}
+		*s = Statements{stmt}
+		return nil
+	}
+
+	var list []Statement
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+type Statement struct {
+	Sid          string `json:",omitempty"`
+	Effect       string
+	Principal    *Principal `json:",omitempty"`
+	NotPrincipal *Principal `json:",omitempty"`
+	Action       StringList `json:",omitempty"`
+	NotAction    StringList `json:",omitempty"`
+	Resource     StringList `json:",omitempty"`
+	NotResource  StringList `json:",omitempty"`
+	Condition    Conditions `json:",omitempty"`
+}
+
+// Principal is the Principal or NotPrincipal element of a statement, which is either "*" or an object mapping
+// principal types to a string or list of strings.
+type Principal struct {
+	// All is set when the principal is "*", which matches every principal.
+	All bool
+
+	AWS           StringList
+	Service       StringList
+	Federated     StringList
+	CanonicalUser StringList
+}
+
+func (p *Principal) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if s != "*" {
+			return fmt.Errorf("unexpected principal %q, expected \"*\" or an object", s)
+		}
+		p.All = true
+		return nil
+	}
+
+	var obj map[string]StringList
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return fmt.Errorf("parsing principal: %w", err)
+	}
+	for k, v := range obj {
+		switch k {
+		case PrincipalAWS:
+			p.AWS = v
+		case PrincipalService:
+			p.Service = v
+		case PrincipalFederated:
+			p.Federated = v
+		case PrincipalCanonicalUser:
+			p.CanonicalUser = v
+		default:
+			return fmt.Errorf("unknown principal type: %s", k)
+		}
+	}
+	return nil
+}
+
+func (p Principal) MarshalJSON() ([]byte, error) {
+	if p.All {
+		return json.Marshal("*")
+	}
+
+	obj := map[string]StringList{}
+	for k, v := range map[string]StringList{
+		PrincipalAWS:           p.AWS,
+		PrincipalService:       p.Service,
+		PrincipalFederated:     p.Federated,
+		PrincipalCanonicalUser: p.CanonicalUser,
+	} {
+		if len(v) != 0 {
+			obj[k] = v
+		}
+	}
+	return json.Marshal(obj)
+}
+
+// Values returns the principals of the given type.
+func (p *Principal) Values(principalType string) []string {
+	switch principalType {
+	case PrincipalAWS:
+		return p.AWS
+	case PrincipalService:
+		return p.Service
+	case PrincipalFederated:
+		return p.Federated
+	case PrincipalCanonicalUser:
+		return p.CanonicalUser
+	default:
+		return nil
+	}
+}
+
+// StringList is a policy value that may be a single value or a list of them. Booleans and numbers are converted to
+// strings, condition operators parse them again as needed.
+type StringList []string
+
+func (s *StringList) UnmarshalJSON(b []byte) error {
+	var raw interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&raw); err != nil {
+		return err
+	}
+
+	var values []interface{}
+	if list, ok := raw.([]interface{}); ok {
+		values = list
+	} else {
+		values = []interface{}{raw}
+	}
+
+	*s = StringList{}
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			*s = append(*s, v)
+		case json.Number, bool:
+			*s = append(*s, fmt.Sprint(v))
+		case nil:
+			continue
+		default:
+			return fmt.Errorf("unexpected policy value: %s", b)
+		}
+	}
+	return nil
+}
+
+func (s StringList) MarshalJSON() ([]byte, error) {
+	if len(s) == 1 {
+		return json.Marshal(s[0])
+	}
+	return json.Marshal([]string(s))
+}
+
+// Decision is the result of evaluating a policy.
+type Decision int
+
+const (
+	// ImplicitDeny means no statement applied to the request.
+	ImplicitDeny Decision = iota
+	Allow
+	ExplicitDeny
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Allow:
+		return "Allow"
+	case ExplicitDeny:
+		return "ExplicitDeny"
+	default:
+		return "ImplicitDeny"
+	}
+}
+
+// Request is the request a policy is evaluated against.
+type Request struct {
+	// Principal is the ARN of the caller, or the service, federated provider or canonical user ID depending on
+	// PrincipalType. For roles this is the role ARN rather than the assumed-role session ARN.
+	Principal string
+
+	// PrincipalType defaults to PrincipalAWS.
+	PrincipalType string
+
+	// Action defaults to ActionAssumeRole.
+	Action string
+
+	// Context holds the condition keys of the request, keys are case-insensitive.
+	Context map[string][]string
+}
+
+// Evaluate evaluates the policy for req. Explicit denies take precedence over allows. An error is returned if a
+// condition operator isn't known, the decision is what the statements that could be evaluated resulted in.
+func (d *Document) Evaluate(req Request) (Decision, error) {
+	if req.PrincipalType == "" {
+		req.PrincipalType = PrincipalAWS
+	}
+	if req.Action == "" {
+		req.Action = ActionAssumeRole
+	}
+	keys := map[string][]string{}
+	for k, v := range req.Context {
+		keys[strings.ToLower(k)] = v
+	}
+
+	var errs []string
+	decision := ImplicitDeny
+	for _, stmt := range d.Statement {
+		applies, err := stmt.applies(req, keys)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		} else if !applies {
+			continue
+		}
+
+		if stmt.Effect == EffectDeny {
+			decision = ExplicitDeny
+		} else if stmt.Effect == EffectAllow && decision != ExplicitDeny {
+			decision = Allow
+		}
+	}
+
+	if len(errs) != 0 {
+		return decision, fmt.Errorf("Evaluate(): %s", strings.Join(errs, ", "))
+	}
+	return decision, nil
+}
+
+func (s Statement) applies(req Request, keys map[string][]string) (bool, error) {
+	if len(s.Action) != 0 && !matchesAny(s.Action, req.Action) {
+		return false, nil
+	} else if len(s.NotAction) != 0 && matchesAny(s.NotAction, req.Action) {
+		return false, nil
+	}
+
+	if s.Principal != nil && !s.Principal.Matches(req.PrincipalType, req.Principal) {
+		return false, nil
+	} else if s.NotPrincipal != nil && s.NotPrincipal.Matches(req.PrincipalType, req.Principal) {
+		return false, nil
+	}
+
+	return s.Condition.Evaluate(keys)
+}
+
+func matchesAny(patterns []string, action string) bool {
+	for _, p := range patterns {
+		if utils.WildcardMatch(p, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if principal of the given type is referenced by p.
+//
+// For AWS principals an account ID or root ARN matches every principal in the account, since access is then
+// delegated to the account's identity policies which we don't evaluate here.
+func (p *Principal) Matches(principalType, principal string) bool {
+	if p.All {
+		return true
+	}
+
+	account, _ := utils.AccountIdFromArn(principal)
+	for _, v := range p.Values(principalType) {
+		switch {
+		case v == "*":
+			return true
+		case principalType != PrincipalAWS:
+			if strings.EqualFold(v, principal) {
+				return true
+			}
+		case account != "" && (v == account || v == fmt.Sprintf("arn:aws:iam::%s:root", account)):
+			return true
+		case v == principal:
+			return true
+		}
+	}
+	return false
+}
+
+// ExternalIDs returns every sts:ExternalId value required by the Allow statements of the policy. Patterns used with
+// StringLike are only returned when they don't contain wildcards.
+func (d *Document) ExternalIDs() []string {
+	var ids []string
+	seen := map[string]bool{}
+	for _, stmt := range d.Statement {
+		if stmt.Effect != EffectAllow {
+			continue
+		}
+		for _, op := range sortedKeys(stmt.Condition) {
+			base := parseOperator(op).base
+			if base != "StringEquals" && base != "StringEqualsIgnoreCase" && base != "StringLike" {
+				continue
+			}
+			for _, key := range sortedKeys(stmt.Condition[op]) {
+				if !strings.EqualFold(key, "sts:ExternalId") {
+					continue
+				}
+				for _, v := range stmt.Condition[op][key] {
+					if seen[v] || (base == "StringLike" && strings.ContainsAny(v, "*?")) {
+						continue
+					}
+					seen[v] = true
+					ids = append(ids, v)
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// AssumableRoles returns the role ARNs in the Resource element of Allow statements that grant sts:AssumeRole. This is
+// for identity policies, ARNs containing wildcards are skipped since they don't refer to a single role.
+func (d *Document) AssumableRoles() []string {
+	var arns []string
+	for _, stmt := range d.Statement {
+		if stmt.Effect != EffectAllow || !matchesAny(stmt.Action, ActionAssumeRole) {
+			continue
+		}
+		for _, v := range stmt.Resource {
+			if IsRoleArn(v) && !strings.ContainsAny(v, "*?") {
+				arns = append(arns, v)
+			}
+		}
+	}
+	return utils.FilterDuplicates(arns)
+}
+
+// RolePrincipals returns the role ARNs in the AWS principals of Allow statements, for example the roles trusted by a
+// role's trust policy.
+func (d *Document) RolePrincipals() []string {
+	var arns []string
+	for _, stmt := range d.Statement {
+		if stmt.Effect != EffectAllow || stmt.Principal == nil {
+			continue
+		}
+		for _, v := range stmt.Principal.AWS {
+			if IsRoleArn(v) {
+				arns = append(arns, v)
+			}
+		}
+	}
+	return utils.FilterDuplicates(arns)
+}
+
+// IsRoleArn returns true if arn is the ARN of an IAM role.
+func IsRoleArn(arn string) bool {
+	p := strings.SplitN(arn, ":", 6)
+	return len(p) == 6 && p[0] == "arn" && p[2] == "iam" && strings.HasPrefix(p[5], "role/")
+}
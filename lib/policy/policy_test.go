@@ -0,0 +1,349 @@
+package policy
+
+import (
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"net/url"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc := `{
+		"Version": "2012-10-17",
+		"Statement": {
+			"Effect": "Allow",
+			"Principal": {"AWS": ["arn:aws:iam::111111111111:root", "222222222222"], "Service": "ec2.amazonaws.com"},
+			"Action": "sts:AssumeRole",
+			"Condition": {"Bool": {"aws:MultiFactorAuthPresent": true}, "NumericLessThan": {"aws:MultiFactorAuthAge": 3600}}
+		}
+	}`
+
+	// Documents returned by iam:ListRoles are URL encoded.
+	for _, in := range []string{doc, url.QueryEscape(doc)} {
+		got, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &Document{
+			Version: "2012-10-17",
+			Statement: Statements{{
+				Effect: "Allow",
+				Principal: &Principal{
+					AWS:     StringList{"arn:aws:iam::111111111111:root", "222222222222"},
+					Service: StringList{"ec2.amazonaws.com"},
+				},
+				Action: StringList{"sts:AssumeRole"},
+				Condition: Conditions{
+					"Bool":            {"aws:MultiFactorAuthPresent": {"true"}},
+					"NumericLessThan": {"aws:MultiFactorAuthAge": {"3600"}},
+				},
+			}},
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Parse() (-got +want):\n%s", diff)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, doc := range []string{
+		`{"Statement": [{"Effect": "Allow", "Principal": "arn:aws:iam::111111111111:root"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Principal": {"Unknown": "x"}}]}`,
+		`{"Statement": [{"Effect": "Allow", "Action": {"x": "y"}}]}`,
+	} {
+		if _, err := Parse(doc); err == nil {
+			t.Errorf("expected Parse() to fail for %s", doc)
+		}
+	}
+}
+
+func TestPrincipal_MarshalJSON(t *testing.T) {
+	for _, p := range []Principal{
+		{All: true},
+		{AWS: StringList{"arn:aws:iam::111111111111:root"}, Federated: StringList{"cognito-identity.amazonaws.com"}},
+	} {
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Principal
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, p); diff != "" {
+			t.Errorf("round trip of %s (-got +want):\n%s", b, diff)
+		}
+	}
+}
+
+const alice = "arn:aws:iam::111111111111:user/alice"
+
+func TestDocument_Evaluate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		req    Request
+		want   Decision
+	}{
+		{
+			name:   "principal arn",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111111111111:user/alice"}, "Action": "sts:AssumeRole"}]}`,
+			req:    Request{Principal: alice},
+			want:   Allow,
+		},
+		{
+			name:   "other principal",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111111111111:user/bob"}, "Action": "sts:AssumeRole"}]}`,
+			req:    Request{Principal: alice},
+			want:   ImplicitDeny,
+		},
+		{
+			name:   "principal arn prefix is not a match",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111111111111:user/alice-admin"}, "Action": "sts:AssumeRole"}]}`,
+			req:    Request{Principal: alice},
+			want:   ImplicitDeny,
+		},
+		{
+			name:   "account id",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": ["222222222222", "111111111111"]}, "Action": "sts:AssumeRole"}]}`,
+			req:    Request{Principal: alice},
+			want:   Allow,
+		},
+		{
+			name:   "account root",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111111111111:root"}, "Action": "sts:*"}]}`,
+			req:    Request{Principal: alice},
+			want:   Allow,
+		},
+		{
+			name:   "wildcard principal",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "sts:AssumeRole"}]}`,
+			req:    Request{Principal: alice},
+			want:   Allow,
+		},
+		{
+			name:   "service principal",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"Service": "lambda.amazonaws.com"}, "Action": "sts:AssumeRole"}]}`,
+			req:    Request{Principal: alice},
+			want:   ImplicitDeny,
+		},
+		{
+			name:   "service principal request",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"Service": "lambda.amazonaws.com"}, "Action": "sts:AssumeRole"}]}`,
+			req:    Request{Principal: "lambda.amazonaws.com", PrincipalType: PrincipalService},
+			want:   Allow,
+		},
+		{
+			name:   "federated principal",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"Federated": "arn:aws:iam::111111111111:saml-provider/okta"}, "Action": "sts:AssumeRoleWithSAML"}]}`,
+			req:    Request{Principal: "arn:aws:iam::111111111111:saml-provider/okta", PrincipalType: PrincipalFederated, Action: "sts:AssumeRoleWithSAML"},
+			want:   Allow,
+		},
+		{
+			name:   "other action",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "sts:AssumeRoleWithWebIdentity"}]}`,
+			req:    Request{Principal: alice},
+			want:   ImplicitDeny,
+		},
+		{
+			name:   "not action",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": "*", "NotAction": "sts:TagSession"}]}`,
+			req:    Request{Principal: alice},
+			want:   Allow,
+		},
+		{
+			name:   "not principal",
+			policy: `{"Statement": [{"Effect": "Deny", "NotPrincipal": {"AWS": "arn:aws:iam::111111111111:user/bob"}, "Action": "sts:AssumeRole"}, {"Effect": "Allow", "Principal": "*", "Action": "sts:AssumeRole"}]}`,
+			req:    Request{Principal: alice},
+			want:   ExplicitDeny,
+		},
+		{
+			name:   "deny overrides allow",
+			policy: `{"Statement": [{"Effect": "Deny", "Principal": {"AWS": "arn:aws:iam::111111111111:user/alice"}, "Action": "sts:AssumeRole"}, {"Effect": "Allow", "Principal": "*", "Action": "sts:AssumeRole"}]}`,
+			req:    Request{Principal: alice},
+			want:   ExplicitDeny,
+		},
+		{
+			name:   "external id",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "111111111111"}, "Action": "sts:AssumeRole", "Condition": {"StringEquals": {"sts:ExternalId": ["a", "b"]}}}]}`,
+			req:    Request{Principal: alice, Context: map[string][]string{"sts:externalid": {"b"}}},
+			want:   Allow,
+		},
+		{
+			name:   "missing external id",
+			policy: `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "111111111111"}, "Action": "sts:AssumeRole", "Condition": {"StringEquals": {"sts:ExternalId": "a"}}}]}`,
+			req:    Request{Principal: alice},
+			want:   ImplicitDeny,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := doc.Evaluate(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate(): got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocument_EvaluateUnknownOperator(t *testing.T) {
+	doc, err := Parse(`{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "sts:AssumeRole", "Condition": {"StringSortOf": {"sts:ExternalId": "a"}}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := doc.Evaluate(Request{Principal: alice}); err == nil || got != ImplicitDeny {
+		t.Errorf("Evaluate(): got %s, %v, want ImplicitDeny and an error", got, err)
+	}
+}
+
+func TestConditions_Evaluate(t *testing.T) {
+	keys := map[string][]string{
+		"aws:principaltag/team":        {"Platform"},
+		"aws:multifactorauthage":       {"300"},
+		"aws:multifactorauthpresent":   {"true"},
+		"aws:currenttime":              {"2022-06-01T00:00:00Z"},
+		"aws:sourceip":                 {"10.0.1.5"},
+		"aws:principalarn":             {"arn:aws:iam::111111111111:role/deploy"},
+		"aws:tagkeys":                  {"team", "env"},
+		"aws:username":                 {"alice"},
+		"saml:aud":                     {"https://signin.aws.amazon.com/saml"},
+		"sts:rolesessionname":          {"alice"},
+		"aws:principalorgpaths":        {"o-1/r-1/ou-1/"},
+		"aws:requestedregion":          {"us-east-1"},
+		"aws:principalservicenamelist": {},
+	}
+
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		{`{"StringEquals": {"aws:PrincipalTag/team": "Platform"}}`, true},
+		{`{"StringEquals": {"aws:PrincipalTag/team": "platform"}}`, false},
+		{`{"StringEqualsIgnoreCase": {"aws:PrincipalTag/team": "platform"}}`, true},
+		{`{"StringNotEquals": {"aws:PrincipalTag/team": ["Security", "Audit"]}}`, true},
+		{`{"StringNotEqualsIgnoreCase": {"aws:PrincipalTag/team": "PLATFORM"}}`, false},
+		{`{"StringLike": {"aws:PrincipalTag/team": "Plat*"}}`, true},
+		{`{"StringLike": {"aws:PrincipalTag/team": "plat*"}}`, false},
+		{`{"StringNotLike": {"aws:PrincipalTag/team": "Sec*"}}`, true},
+		{`{"StringEquals": {"aws:PrincipalTag/missing": "x"}}`, false},
+		{`{"StringNotEquals": {"aws:PrincipalTag/missing": "x"}}`, true},
+		{`{"StringEqualsIfExists": {"aws:PrincipalTag/missing": "x"}}`, true},
+		{`{"StringEqualsIfExists": {"aws:PrincipalTag/team": "x"}}`, false},
+		{`{"NumericLessThan": {"aws:MultiFactorAuthAge": 3600}}`, true},
+		{`{"NumericGreaterThan": {"aws:MultiFactorAuthAge": "3600"}}`, false},
+		{`{"NumericEquals": {"aws:MultiFactorAuthAge": 300}}`, true},
+		{`{"NumericNotEquals": {"aws:MultiFactorAuthAge": 300}}`, false},
+		{`{"NumericLessThanEquals": {"aws:MultiFactorAuthAge": 300}}`, true},
+		{`{"NumericGreaterThanEquals": {"aws:MultiFactorAuthAge": 301}}`, false},
+		{`{"Bool": {"aws:MultiFactorAuthPresent": true}}`, true},
+		{`{"Bool": {"aws:MultiFactorAuthPresent": "false"}}`, false},
+		{`{"BoolIfExists": {"aws:SecureTransport": "true"}}`, true},
+		{`{"DateGreaterThan": {"aws:CurrentTime": "2022-01-01T00:00:00Z"}}`, true},
+		{`{"DateLessThan": {"aws:CurrentTime": "2022-01-01"}}`, false},
+		{`{"DateEquals": {"aws:CurrentTime": 1654041600}}`, true},
+		{`{"DateNotEquals": {"aws:CurrentTime": 1654041600}}`, false},
+		{`{"DateLessThanEquals": {"aws:CurrentTime": "2022-06-01T00:00:00Z"}}`, true},
+		{`{"DateGreaterThanEquals": {"aws:CurrentTime": "2022-06-02T00:00:00Z"}}`, false},
+		{`{"IpAddress": {"aws:SourceIp": ["192.168.0.0/16", "10.0.0.0/8"]}}`, true},
+		{`{"IpAddress": {"aws:SourceIp": "10.0.1.5"}}`, true},
+		{`{"NotIpAddress": {"aws:SourceIp": "10.0.0.0/8"}}`, false},
+		{`{"ArnLike": {"aws:PrincipalArn": "arn:aws:iam::*:role/deploy"}}`, true},
+		{`{"ArnEquals": {"aws:PrincipalArn": "arn:aws:iam::111111111111:role/deploy"}}`, true},
+		{`{"ArnNotLike": {"aws:PrincipalArn": "arn:aws:iam::*:role/dep*"}}`, false},
+		{`{"ArnNotEquals": {"aws:PrincipalArn": "arn:aws:iam::111111111111:role/other"}}`, true},
+		{`{"BinaryEquals": {"saml:aud": "https://signin.aws.amazon.com/saml"}}`, true},
+		{`{"Null": {"aws:PrincipalTag/missing": "true"}}`, true},
+		{`{"Null": {"aws:PrincipalTag/team": "true"}}`, false},
+		{`{"Null": {"aws:PrincipalTag/team": "false"}}`, true},
+		{`{"Null": {"aws:PrincipalServiceNameList": "true"}}`, true},
+		{`{"ForAnyValue:StringEquals": {"aws:TagKeys": ["env", "owner"]}}`, true},
+		{`{"ForAnyValue:StringEquals": {"aws:TagKeys": ["owner"]}}`, false},
+		{`{"ForAnyValue:StringEquals": {"aws:PrincipalTag/missing": ["owner"]}}`, false},
+		{`{"ForAnyValue:StringNotEquals": {"aws:TagKeys": ["team"]}}`, true},
+		{`{"ForAllValues:StringEquals": {"aws:TagKeys": ["team", "env", "owner"]}}`, true},
+		{`{"ForAllValues:StringEquals": {"aws:TagKeys": ["team"]}}`, false},
+		{`{"ForAllValues:StringEquals": {"aws:PrincipalTag/missing": ["team"]}}`, true},
+		{`{"ForAllValues:StringNotEquals": {"aws:TagKeys": ["owner"]}}`, true},
+		{`{"ForAnyValue:StringLike": {"aws:PrincipalOrgPaths": "o-1/r-1/ou-1/*"}}`, true},
+		{`{"StringEquals": {"sts:RoleSessionName": "${aws:username}"}}`, true},
+		{`{"StringEquals": {"sts:RoleSessionName": "${aws:missing, 'alice'}"}}`, true},
+		{`{"StringEquals": {"aws:PrincipalTag/team": "${*}"}}`, false},
+		{`{"StringEquals": {"aws:RequestedRegion": "us-east-1"}, "Bool": {"aws:MultiFactorAuthPresent": "false"}}`, false},
+		{`{"StringEquals": {"aws:RequestedRegion": "us-east-1", "aws:PrincipalTag/team": "Platform"}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			var c Conditions
+			if err := json.Unmarshal([]byte(tt.condition), &c); err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Evaluate(keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate(): got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocument_ExternalIDs(t *testing.T) {
+	doc, err := Parse(`{
+		"Statement": [
+			{"Effect": "Allow", "Principal": {"AWS": "111111111111"}, "Action": "sts:AssumeRole", "Condition": {"StringEquals": {"sts:ExternalId": ["a", "b"]}}},
+			{"Effect": "Allow", "Principal": {"AWS": "222222222222"}, "Action": "sts:AssumeRole", "Condition": {"StringLike": {"sts:ExternalId": ["c", "d*"]}}},
+			{"Effect": "Allow", "Principal": {"AWS": "333333333333"}, "Action": "sts:AssumeRole", "Condition": {"StringEquals": {"sts:externalid": "a"}}},
+			{"Effect": "Deny", "Principal": "*", "Action": "sts:AssumeRole", "Condition": {"StringEquals": {"sts:ExternalId": "e"}}}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(doc.ExternalIDs(), []string{"a", "b", "c"}); diff != "" {
+		t.Errorf("ExternalIDs() (-got +want):\n%s", diff)
+	}
+}
+
+func TestDocument_AssumableRoles(t *testing.T) {
+	doc, err := Parse(`{
+		"Statement": [
+			{"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": ["arn:aws:iam::111111111111:role/a", "arn:aws:iam::*:role/b"]},
+			{"Effect": "Allow", "Action": "sts:*", "Resource": "arn:aws:iam::222222222222:role/path/c"},
+			{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:iam::333333333333:role/d"},
+			{"Effect": "Deny", "Action": "sts:AssumeRole", "Resource": "arn:aws:iam::444444444444:role/e"},
+			{"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": "arn:aws:iam::555555555555:user/f"}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"arn:aws:iam::111111111111:role/a", "arn:aws:iam::222222222222:role/path/c"}
+	if diff := cmp.Diff(doc.AssumableRoles(), want); diff != "" {
+		t.Errorf("AssumableRoles() (-got +want):\n%s", diff)
+	}
+}
+
+func TestDocument_RolePrincipals(t *testing.T) {
+	doc, err := Parse(`{
+		"Statement": [
+			{"Effect": "Allow", "Principal": {"AWS": ["arn:aws:iam::111111111111:role/a", "222222222222"]}, "Action": "sts:AssumeRole"},
+			{"Effect": "Allow", "Principal": {"Service": "ec2.amazonaws.com"}, "Action": "sts:AssumeRole"},
+			{"Effect": "Deny", "Principal": {"AWS": "arn:aws:iam::333333333333:role/c"}, "Action": "sts:AssumeRole"}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(doc.RolePrincipals(), []string{"arn:aws:iam::111111111111:role/a"}); diff != "" {
+		t.Errorf("RolePrincipals() (-got +want):\n%s", diff)
+	}
+}
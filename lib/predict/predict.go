@@ -0,0 +1,163 @@
+package predict
+
+import (
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/policy"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Finding is a source and target pair where the trust policy model and the scan disagree.
+type Finding struct {
+	Source    string
+	Target    string
+	Predicted policy.Decision
+	Observed  graph.EdgeStatus
+
+	// Denial is the reason the scan was denied, if it was.
+	Denial *graph.Denial `json:",omitempty"`
+}
+
+// Report is the result of comparing predicted edges with the edges observed in the graph.
+type Report struct {
+	// Unexpected edges were observed but the trust policy doesn't allow them, these are worth a closer look since
+	// access was granted by something we don't model.
+	Unexpected []Finding
+
+	// Missing edges are allowed by the trust policy but were denied, usually because of SCP's, permission boundaries
+	// or missing identity permissions.
+	Missing []Finding
+
+	// Untested edges are allowed by the trust policy but were never attempted.
+	Untested []Finding
+
+	// Agreed is the number of pairs where the prediction matched what was observed.
+	Agreed int
+
+	// Errors are trust policies that couldn't be parsed or evaluated, these roles are skipped.
+	Errors []string
+}
+
+// Compare predicts which edges should exist from every principal in the graph to every role with a known trust
+// policy and compares the prediction with what was observed. Only the trust policy is evaluated, identity policies,
+// SCP's and permission boundaries are unknown so an allowed prediction means the role trusts the principal.
+func Compare[T graph.Value](g *graph.Graph[T], roles []types.Role) Report {
+	var report Report
+
+	var sources []graph.Node[T]
+	for _, n := range g.Nodes() {
+		sources = append(sources, n)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Value().Id() < sources[j].Value().Id() })
+
+	sort.Slice(roles, func(i, j int) bool { return *roles[i].Arn < *roles[j].Arn })
+
+	for _, role := range roles {
+		trust, err := role.TrustPolicy()
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		} else if trust == nil {
+			continue
+		}
+
+		for _, src := range sources {
+			source := src.Value().Id()
+			observed := g.EdgeStatus(source, role.Id())
+
+			predicted, err := trust.Evaluate(Request(source, src.Edges()[role.Id()]))
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", role.Id(), err))
+			}
+
+			finding := Finding{Source: source, Target: role.Id(), Predicted: predicted, Observed: observed}
+			if denial, ok := src.Denied()[role.Id()]; ok {
+				finding.Denial = &denial
+			}
+
+			switch {
+			case observed == graph.EdgeAllowed && predicted != policy.Allow:
+				report.Unexpected = append(report.Unexpected, finding)
+			case observed == graph.EdgeDenied && predicted == policy.Allow:
+				report.Missing = append(report.Missing, finding)
+			case observed == graph.EdgeUntested && predicted == policy.Allow:
+				report.Untested = append(report.Untested, finding)
+			case observed != graph.EdgeUntested:
+				report.Agreed++
+			}
+		}
+	}
+	return report
+}
+
+// Request returns the request used to evaluate trust policies for source, with the condition keys we know about.
+// The external ID is only known when the edge was traversed with one.
+func Request(source string, edge graph.Edge) policy.Request {
+	keys := map[string][]string{
+		"aws:PrincipalArn": {source},
+	}
+	if account, err := utils.AccountIdFromArn(source); err == nil {
+		keys["aws:PrincipalAccount"] = []string{account}
+	}
+	if strings.Contains(source, ":role/") {
+		keys["aws:PrincipalType"] = []string{"AssumedRole"}
+	} else if strings.Contains(source, ":user/") {
+		keys["aws:PrincipalType"] = []string{"User"}
+	}
+	if edge.ExternalId != nil {
+		keys["sts:ExternalId"] = []string{*edge.ExternalId}
+	}
+
+	return policy.Request{Principal: source, Context: keys}
+}
+
+// Print writes the report in the same format as the other reports.
+func (r Report) Print(w io.Writer) {
+	section := func(title, arrow string, color utils.Color, findings []Finding, describe func(Finding) string) {
+		if len(findings) == 0 {
+			return
+		}
+
+		utils.Must(fmt.Fprintf(w, "\n%s\n", color.Color(title)))
+		last := ""
+		for _, f := range findings {
+			if f.Target != last {
+				utils.Must(fmt.Fprintf(w, "\n %s %s", color.Color("*"), f.Target))
+				last = f.Target
+			}
+			utils.Must(fmt.Fprintf(w, "\n\t%s %s%s", color.Color(arrow), f.Source, describe(f)))
+		}
+		utils.Must(fmt.Fprintf(w, "\n"))
+	}
+
+	byTarget := func(findings []Finding) []Finding {
+		sorted := make([]Finding, len(findings))
+		copy(sorted, findings)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Target < sorted[j].Target })
+		return sorted
+	}
+
+	section("Assumed, but the trust policy does not allow it:", "<-", utils.Red, byTarget(r.Unexpected), func(f Finding) string {
+		return fmt.Sprintf(" (%s)", f.Predicted)
+	})
+	section("Trust policy allows it, but it was denied:", "<-", utils.Yellow, byTarget(r.Missing), func(f Finding) string {
+		if f.Denial == nil {
+			return ""
+		}
+		return fmt.Sprintf(" (%s: %s)", f.Denial.Reason, f.Denial.Code)
+	})
+	section("Trust policy allows it, but it was not tested:", "<-", utils.Cyan, byTarget(r.Untested), func(Finding) string {
+		return ""
+	})
+
+	utils.Must(fmt.Fprintf(w, "\n%d unexpected, %d missing, %d untested, %d agreed\n",
+		len(r.Unexpected), len(r.Missing), len(r.Untested), r.Agreed))
+
+	for _, err := range r.Errors {
+		utils.Must(fmt.Fprintf(w, "%s %s\n", utils.Red.Color("error:"), err))
+	}
+}
@@ -0,0 +1,127 @@
+package predict
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/policy"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/google/go-cmp/cmp"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type V string
+
+func (v V) Id() string                   { return string(v) }
+func (v V) SetGraph(_ interface{})       {}
+func (v V) MarshalJSON() ([]byte, error) { return []byte(fmt.Sprintf("%q", v)), nil }
+
+const (
+	alice   = "arn:aws:iam::111111111111:user/alice"
+	trusted = "arn:aws:iam::111111111111:role/trusted"
+	vendor  = "arn:aws:iam::111111111111:role/vendor"
+	other   = "arn:aws:iam::111111111111:role/other"
+	open    = "arn:aws:iam::222222222222:role/open"
+)
+
+func NewTestRole(arn, trust string) types.Role {
+	role := types.NewRole(arn)
+	role.AssumeRolePolicyDocument = aws.String(trust)
+	return role
+}
+
+func trusts(principal string) string {
+	return fmt.Sprintf(`{"Statement": [{"Effect": "Allow", "Principal": {"AWS": %q}, "Action": "sts:AssumeRole"}]}`, principal)
+}
+
+func TestCompare(t *testing.T) {
+	g := graph.NewDirectedGraph[V]()
+	g.AddNode(V(alice))
+
+	// trusted: allowed by the trust policy and assumed.
+	g.AddEdge(V(alice), V(trusted))
+
+	// vendor: assumed with an external ID, the trust policy only allows it with the ID.
+	g.AddEdge(V(alice), V(vendor))
+	g.SetEdge(alice, vendor, graph.Edge{ExternalId: aws.String("secret")})
+
+	// other: assumed even though the trust policy doesn't mention alice.
+	g.AddEdge(V(alice), V(other))
+
+	// open: allowed by the trust policy but denied, probably by an SCP.
+	g.AddDenial(V(alice), open, graph.Denial{Code: "AccessDenied", Reason: "AccessDenied"})
+
+	roles := []types.Role{
+		NewTestRole(trusted, trusts(alice)),
+		NewTestRole(vendor, `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "111111111111"}, "Action": "sts:AssumeRole", "Condition": {"StringEquals": {"sts:ExternalId": "secret"}}}]}`),
+		NewTestRole(other, trusts("arn:aws:iam::111111111111:user/bob")),
+		NewTestRole(open, trusts("*")),
+		NewTestRole("arn:aws:iam::111111111111:role/broken", `{"Statement": [{"Principal": "nope"}]}`),
+		types.NewRole("arn:aws:iam::111111111111:role/unknown"),
+	}
+
+	report := Compare(g, roles)
+
+	pairs := func(findings []Finding) []string {
+		var resp []string
+		for _, f := range findings {
+			resp = append(resp, f.Source+" -> "+f.Target)
+		}
+		return resp
+	}
+
+	if diff := cmp.Diff(pairs(report.Unexpected), []string{alice + " -> " + other}); diff != "" {
+		t.Errorf("Unexpected (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(pairs(report.Missing), []string{alice + " -> " + open}); diff != "" {
+		t.Errorf("Missing (-got +want):\n%s", diff)
+	}
+	if report.Missing[0].Denial == nil || report.Missing[0].Denial.Code != "AccessDenied" {
+		t.Errorf("Missing denial: got %+v", report.Missing[0].Denial)
+	}
+	if report.Unexpected[0].Predicted != policy.ImplicitDeny {
+		t.Errorf("Unexpected prediction: got %s, want %s", report.Unexpected[0].Predicted, policy.ImplicitDeny)
+	}
+
+	// alice -> trusted and alice -> vendor agree. The roles alice assumed never tried anything themselves, only open
+	// trusts them so those are the untested predictions.
+	if report.Agreed != 2 {
+		t.Errorf("Agreed: got %d, want 2", report.Agreed)
+	}
+	if diff := cmp.Diff(pairs(report.Untested), []string{other + " -> " + open, trusted + " -> " + open, vendor + " -> " + open}); diff != "" {
+		t.Errorf("Untested (-got +want):\n%s", diff)
+	}
+	if len(report.Errors) != 1 || !strings.Contains(report.Errors[0], "role/broken") {
+		t.Errorf("Errors: got %v", report.Errors)
+	}
+
+	var buf bytes.Buffer
+	report.Print(&buf)
+	if !strings.Contains(buf.String(), "1 unexpected, 1 missing") {
+		t.Errorf("Print(): unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestSaveRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.json")
+	roles := []types.Role{
+		NewTestRole(trusted, trusts(alice)),
+		types.NewRole(other),
+	}
+
+	if err := SaveRoles(path, roles); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadRoles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Roles without a trust policy aren't useful for predictions and aren't saved.
+	if len(got) != 1 || got[0].Id() != trusted || *got[0].AssumeRolePolicyDocument != trusts(alice) {
+		t.Errorf("LoadRoles(): got %+v", got)
+	}
+}
@@ -0,0 +1,43 @@
+package predict
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"os"
+	"sort"
+)
+
+// SaveRoles saves the roles that have a trust policy so predictions can be made without access to the account later.
+func SaveRoles(path string, roles []types.Role) error {
+	var withPolicy []types.Role
+	for _, role := range roles {
+		if role.AssumeRolePolicyDocument != nil {
+			withPolicy = append(withPolicy, role)
+		}
+	}
+	sort.Slice(withPolicy, func(i, j int) bool { return *withPolicy[i].Arn < *withPolicy[j].Arn })
+
+	b, err := json.MarshalIndent(withPolicy, "", "  ")
+	if err != nil {
+		return fmt.Errorf("SaveRoles(): %w", err)
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return fmt.Errorf("SaveRoles(): %w", err)
+	}
+	return nil
+}
+
+// LoadRoles loads roles saved with SaveRoles.
+func LoadRoles(path string) ([]types.Role, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadRoles(): %w", err)
+	}
+
+	var roles []types.Role
+	if err := json.Unmarshal(b, &roles); err != nil {
+		return nil, fmt.Errorf("LoadRoles(): %w", err)
+	}
+	return roles, nil
+}
@@ -0,0 +1,219 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"sync"
+	"time"
+)
+
+// Options configure the token buckets and retries of a Limiter.
+type Options struct {
+	// Rate is the number of requests per second each bucket starts at, it is halved on each throttling error down to
+	// MinRate and increased by Increase after each successful request up to MaxRate.
+	Rate     float64
+	MinRate  float64
+	MaxRate  float64
+	Increase float64
+
+	// MaxAttempts and MaxBackoff configure the retryer, retries are delayed with exponential backoff and jitter.
+	MaxAttempts int
+	MaxBackoff  time.Duration
+}
+
+var DefaultOptions = Options{
+	Rate:        10,
+	MinRate:     0.5,
+	MaxRate:     50,
+	Increase:    0.5,
+	MaxAttempts: 5,
+	MaxBackoff:  10 * time.Second,
+}
+
+// Default is the Limiter shared by the configs created in the creds package.
+var Default = New(DefaultOptions)
+
+func New(opts Options) *Limiter {
+	return &Limiter{
+		opts:    opts,
+		buckets: map[Key]*bucket{},
+	}
+}
+
+// Limiter paces requests with a token bucket for each account, region and API. The rate of a bucket backs off when the
+// API returns a throttling error and recovers as requests succeed, so the scan slows down to what each account allows
+// rather than failing.
+type Limiter struct {
+	opts Options
+
+	m       sync.Mutex
+	buckets map[Key]*bucket
+}
+
+// Key identifies a bucket, API is the service and operation, for example STS.AssumeRole.
+type Key struct {
+	Account string
+	Region  string
+	API     string
+}
+
+// Apply sets up the retryer and the rate limiting middleware on cfg for requests made by the given account. Clients
+// created from cfg after this share the limits of other configs in the same account.
+func (l *Limiter) Apply(cfg *aws.Config, account string) {
+	cfg.Retryer = func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = l.opts.MaxAttempts
+			o.MaxBackoff = l.opts.MaxBackoff
+
+			// Retries are paced by the buckets, so the SDK's retry quota would only cause requests to fail early.
+			o.RateLimiter = noQuota{}
+
+			// Only errors returned by the API are retried, network failures usually fail the same way again and
+			// backing off on them would slow down scans with unreachable endpoints.
+			o.Retryables = append([]retry.IsErrorRetryable{retry.IsErrorRetryableFunc(apiErrorsOnly)}, o.Retryables...)
+		})
+	}
+
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		mw := middleware.FinalizeMiddlewareFunc("RateLimit", func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			b := l.bucket(Key{
+				Account: account,
+				Region:  awsmiddleware.GetRegion(ctx),
+				API:     fmt.Sprintf("%s.%s", awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)),
+			})
+			if err := b.wait(ctx); err != nil {
+				return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+			}
+
+			out, md, err := next.HandleFinalize(ctx, in)
+			if IsThrottle(err) {
+				b.throttled(l.opts)
+			} else if err == nil {
+				b.succeeded(l.opts)
+			}
+			return out, md, err
+		})
+
+		// Running after the retry middleware means each attempt waits for a token, not just the first one.
+		if err := stack.Finalize.Insert(mw, "Retry", middleware.After); err != nil {
+			return stack.Finalize.Add(mw, middleware.After)
+		}
+		return nil
+	})
+}
+
+// Rate returns the current rate of the bucket for key, or zero if no requests have been made with it.
+func (l *Limiter) Rate(key Key) float64 {
+	l.m.Lock()
+	b, ok := l.buckets[key]
+	l.m.Unlock()
+	if !ok {
+		return 0
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.rate
+}
+
+func (l *Limiter) bucket(key Key) *bucket {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{rate: l.opts.Rate, tokens: 1, last: time.Now()}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// throttleCodes are the error codes returned by AWS APIs when requests are throttled.
+var throttleCodes = map[string]bool{
+	"Throttling":                true,
+	"ThrottlingException":       true,
+	"ThrottledException":        true,
+	"RequestThrottledException": true,
+	"TooManyRequestsException":  true,
+	"RequestLimitExceeded":      true,
+	"RequestThrottled":          true,
+	"SlowDown":                  true,
+	"EC2ThrottledException":     true,
+}
+
+// IsThrottle returns true if err is a throttling error from an AWS API.
+func IsThrottle(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && throttleCodes[apiErr.ErrorCode()]
+}
+
+// apiErrorsOnly stops errors that weren't returned by an AWS API from being retried, the retryables after it decide
+// whether API errors are.
+func apiErrorsOnly(err error) aws.Ternary {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return aws.UnknownTernary
+	}
+	return aws.FalseTernary
+}
+
+// bucket is a token bucket that holds up to a second worth of requests. Tokens are reserved by wait, so the balance
+// is negative when requests are waiting.
+type bucket struct {
+	m      sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// wait blocks until a token is available or ctx is done.
+func (b *bucket) wait(ctx context.Context) error {
+	b.m.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, max(b.rate, 1))
+	b.last = now
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.m.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttled halves the rate and drops any saved up tokens.
+func (b *bucket) throttled(opts Options) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.rate = max(b.rate/2, opts.MinRate)
+	b.tokens = min(b.tokens, 0)
+}
+
+func (b *bucket) succeeded(opts Options) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.rate = min(b.rate+opts.Increase, opts.MaxRate)
+}
+
+// noQuota is a retry.RateLimiter that always allows retries.
+type noQuota struct{}
+
+func (noQuota) GetToken(context.Context, uint) (func() error, error) {
+	return func() error { return nil }, nil
+}
+func (noQuota) AddTokens(uint) error { return nil }
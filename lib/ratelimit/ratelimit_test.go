@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const callerIdentity = `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::123456789012:user/test</Arn>
+    <UserId>AIDATEST</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`
+
+const throttling = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error><Type>Sender</Type><Code>Throttling</Code><Message>Rate exceeded</Message></Error>
+</ErrorResponse>`
+
+// TestLimiter_Apply ensures throttled requests are retried and the rate of the bucket backs off.
+func TestLimiter_Apply(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, throttling)
+			return
+		}
+		fmt.Fprint(w, callerIdentity)
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions
+	opts.MaxBackoff = time.Millisecond
+	l := New(opts)
+
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(_, _ string, _ ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: server.URL}, nil
+		}),
+	}
+	l.Apply(&cfg, "123456789012")
+
+	if _, err := sts.NewFromConfig(cfg).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("expected 3 attempts, got %d", got)
+	}
+
+	// Halved twice and increased once.
+	key := Key{Account: "123456789012", Region: "us-east-1", API: "STS.GetCallerIdentity"}
+	if got, want := l.Rate(key), opts.Rate/4+opts.Increase; got != want {
+		t.Errorf("Rate(): got %v, want %v", got, want)
+	}
+}
+
+// TestLimiter_NetworkError ensures requests that fail without a response from the API aren't retried.
+func TestLimiter_NetworkError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	l := New(DefaultOptions)
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(_, _ string, _ ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: server.URL}, nil
+		}),
+	}
+	l.Apply(&cfg, "123456789012")
+
+	start := time.Now()
+	if _, err := sts.NewFromConfig(cfg).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("got %s, expected the request to fail without retrying", d)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	b := &bucket{rate: 4, tokens: 1, last: time.Now()}
+	opts := Options{MinRate: 1, MaxRate: 5, Increase: 2}
+
+	b.throttled(opts)
+	b.throttled(opts)
+	b.throttled(opts)
+	if b.rate != 1 || b.tokens > 0 {
+		t.Errorf("expected the rate to back off to MinRate and drop saved tokens, got %v and %v", b.rate, b.tokens)
+	}
+
+	b.succeeded(opts)
+	b.succeeded(opts)
+	b.succeeded(opts)
+	if b.rate != 5 {
+		t.Errorf("expected the rate to recover up to MaxRate, got %v", b.rate)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.tokens = -10
+	if err := b.wait(ctx); err == nil {
+		t.Error("expected wait() to return an error when ctx is done")
+	}
+}
+
+func TestIsThrottle(t *testing.T) {
+	for err, want := range map[error]bool{
+		&smithy.GenericAPIError{Code: "Throttling"}:                          true,
+		fmt.Errorf("wrapped: %w", &smithy.GenericAPIError{Code: "SlowDown"}): true,
+		&smithy.GenericAPIError{Code: "AccessDenied"}:                        false,
+		fmt.Errorf("test"): false,
+	} {
+		if got := IsThrottle(err); got != want {
+			t.Errorf("IsThrottle(%v): got %v, want %v", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package scheduler
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Target is a principal whose credentials are refreshed by the Scheduler, *creds.Config implements this.
+type Target interface {
+	Id() string
+	Refresh(utils.Context) (aws.Credentials, error)
+
+	// Parent returns the ID of the principal the credentials are assumed from, or an empty string if there isn't one.
+	Parent() string
+}
+
+// Options configure when a Scheduler refreshes credentials.
+type Options struct {
+	// Fraction is how much of the remaining lifetime of the credentials passes before they're refreshed, and Interval
+	// is the longest time between refreshes. Whichever comes first is used, a zero value disables either one.
+	Fraction float64
+	Interval time.Duration
+
+	// Lifetime is assumed for credentials when their expiry isn't known yet, this is the default for sts:AssumeRole.
+	Lifetime time.Duration
+
+	// Jitter randomly moves each refresh by up to this fraction of its delay, so principals discovered together are
+	// spread out.
+	Jitter float64
+
+	// Retry is the delay before trying again after a refresh fails and MinDelay is the shortest delay between
+	// refreshes of the same principal.
+	Retry    time.Duration
+	MinDelay time.Duration
+
+	// Workers is the number of refreshes that can run at once.
+	Workers int
+}
+
+var DefaultOptions = Options{
+	Fraction: 0.5,
+	Lifetime: time.Hour,
+	Jitter:   0.1,
+	Retry:    30 * time.Second,
+	MinDelay: time.Second,
+	Workers:  10,
+}
+
+// Event is emitted after each refresh, Err is set if it failed.
+type Event struct {
+	Id  string
+	Err error
+
+	// Expires is when the new credentials expire, it is zero if they don't or the refresh failed.
+	Expires time.Time
+
+	// Next is when the principal will be refreshed again, it is zero if it won't be.
+	Next time.Time
+}
+
+// New returns a Scheduler that refreshes the credentials of the targets added to it until ctx is done.
+func New(ctx utils.Context, opts Options) *Scheduler {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	s := &Scheduler{
+		ctx:     ctx,
+		opts:    opts,
+		items:   map[string]*item{},
+		running: map[string]bool{},
+		changed: make(chan struct{}),
+	}
+	for i := 0; i < opts.Workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// Scheduler refreshes credentials before they expire. Every target is kept in a single queue ordered by when it is
+// next due, rather than each one having its own goroutine and timer.
+//
+// When more than one target is due, the ones closer to a profile are refreshed first and a target isn't refreshed
+// while its parent is due or being refreshed, so the children use the parent's new credentials.
+type Scheduler struct {
+	ctx  utils.Context
+	opts Options
+
+	m       sync.Mutex
+	queue   queue
+	items   map[string]*item
+	running map[string]bool
+
+	// changed is closed and replaced whenever the queue changes or a refresh finishes, this wakes the waiting workers.
+	changed chan struct{}
+
+	subscribers []func(Event)
+}
+
+// Subscribe calls f with each Event emitted after this is called. It is called from the worker that did the refresh,
+// so it should not block.
+func (s *Scheduler) Subscribe(f func(Event)) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.subscribers = append(s.subscribers, f)
+}
+
+// Add schedules t based on when its current credentials expire, a zero expires means it isn't known. Targets that are
+// already scheduled are left as they are.
+func (s *Scheduler) Add(t Target, expires time.Time) {
+	if expires.IsZero() {
+		expires = time.Now().Add(s.opts.Lifetime)
+	}
+
+	if next, ok := s.next(time.Now(), expires, nil); ok {
+		s.schedule(t, next, false)
+	}
+}
+
+// Schedule refreshes t at due. If t is already scheduled it is moved to due when that is sooner, this is used to
+// refresh a principal early, for example after its sessions are revoked. If t is being refreshed, due is used after
+// the refresh finishes when it is sooner than the next refresh.
+func (s *Scheduler) Schedule(t Target, due time.Time) {
+	s.schedule(t, due, true)
+}
+
+// Remove stops refreshing the target with the given ID, for example after the principal is deleted. If it is being
+// refreshed the refresh finishes, but it isn't scheduled again.
+func (s *Scheduler) Remove(id string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	it, ok := s.items[id]
+	if !ok {
+		return
+	}
+	delete(s.items, id)
+	if it.index >= 0 {
+		heap.Remove(&s.queue, it.index)
+	} else {
+		it.removed = true
+	}
+	s.notify()
+}
+
+// Len returns the number of targets that are scheduled.
+func (s *Scheduler) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.queue)
+}
+
+func (s *Scheduler) schedule(t Target, due time.Time, sooner bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if it, ok := s.items[t.Id()]; ok {
+		if !sooner {
+			return
+		} else if it.index < 0 {
+			// The credentials being refreshed may be from before whatever caused this, so it's refreshed again.
+			if it.pending.IsZero() || due.Before(it.pending) {
+				it.pending = due
+			}
+		} else if due.Before(it.due) {
+			it.due = due
+			heap.Fix(&s.queue, it.index)
+			s.notify()
+		}
+		return
+	}
+
+	it := &item{target: t, due: due}
+	s.items[t.Id()] = it
+	heap.Push(&s.queue, it)
+	s.notify()
+}
+
+// next returns when credentials expiring at expires should be refreshed, or the retry time if err is set. False is
+// returned if they don't need to be refreshed again.
+func (s *Scheduler) next(now time.Time, expires time.Time, err error) (time.Time, bool) {
+	var delay time.Duration
+	switch {
+	case err != nil:
+		delay = s.opts.Retry
+	case !expires.IsZero() && s.opts.Fraction > 0:
+		delay = time.Duration(float64(expires.Sub(now)) * s.opts.Fraction)
+		if s.opts.Interval > 0 {
+			delay = min(delay, s.opts.Interval)
+		}
+	case s.opts.Interval > 0:
+		delay = s.opts.Interval
+	default:
+		return time.Time{}, false
+	}
+
+	if s.opts.Jitter > 0 {
+		delay += time.Duration(float64(delay) * s.opts.Jitter * (rand.Float64()*2 - 1))
+	}
+	return now.Add(max(delay, s.opts.MinDelay)), true
+}
+
+func (s *Scheduler) work() {
+	for {
+		it, ok := s.take()
+		if !ok {
+			return
+		}
+
+		creds, err := s.refresh(it.target)
+
+		var expires time.Time
+		if err == nil && creds.CanExpire {
+			expires = creds.Expires
+		}
+		next, again := s.next(time.Now(), expires, err)
+
+		s.m.Lock()
+		delete(s.running, it.target.Id())
+		if !it.pending.IsZero() && (!again || it.pending.Before(next)) {
+			next, again = it.pending, true
+		}
+		it.pending = time.Time{}
+		if again && !it.removed {
+			it.due = next
+			heap.Push(&s.queue, it)
+		} else {
+			if !it.removed {
+				delete(s.items, it.target.Id())
+			}
+			next = time.Time{}
+		}
+		subscribers := s.subscribers
+		s.notify()
+		s.m.Unlock()
+
+		event := Event{Id: it.target.Id(), Err: err, Expires: expires, Next: next}
+		for _, f := range subscribers {
+			f(event)
+		}
+	}
+}
+
+// refresh calls t.Refresh, a panic is logged and returned as an error rather than taking down the worker.
+func (s *Scheduler) refresh(t Target) (creds aws.Credentials, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.ctx.Error.Println("scheduler:", r, "\n", string(debug.Stack()))
+			err = fmt.Errorf("refresh %s: %v", t.Id(), r)
+		}
+	}()
+	return t.Refresh(s.ctx)
+}
+
+// take blocks until a target is ready to be refreshed and removes it from the queue, false is returned once the
+// context is done.
+func (s *Scheduler) take() (*item, bool) {
+	for s.ctx.Err() == nil {
+		s.m.Lock()
+		now := time.Now()
+		it, next := s.ready(now)
+		if it != nil {
+			heap.Remove(&s.queue, it.index)
+			s.running[it.target.Id()] = true
+			s.m.Unlock()
+			return it, true
+		}
+
+		// Wait for the next target to be due, or for a change if the due ones are blocked by their parents.
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(now))
+			wait = timer.C
+		}
+		changed := s.changed
+		s.m.Unlock()
+
+		select {
+		case <-s.ctx.Done():
+		case <-changed:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	return nil, false
+}
+
+// ready returns the due target with the fewest scheduled ancestors that isn't blocked by its parent, nil is returned
+// if there isn't one. Ties are broken by due time and then ID. The earliest due time after now is returned as well, it
+// is zero if nothing else is scheduled.
+func (s *Scheduler) ready(now time.Time) (*item, time.Time) {
+	var best *item
+	var bestDepth int
+	next := s.queue.due(now, func(it *item) {
+		if s.blocked(it, now) {
+			return
+		}
+
+		depth := s.depth(it)
+		if best == nil || depth < bestDepth ||
+			depth == bestDepth && (it.due.Before(best.due) || it.due.Equal(best.due) && it.target.Id() < best.target.Id()) {
+			best, bestDepth = it, depth
+		}
+	})
+	return best, next
+}
+
+// blocked returns true if the parent of it is being refreshed, or if it is due and closer to a profile. Comparing the
+// depth means targets that are each other's parent don't block each other.
+func (s *Scheduler) blocked(it *item, now time.Time) bool {
+	parent := it.target.Parent()
+	if parent == "" || parent == it.target.Id() {
+		return false
+	} else if s.running[parent] {
+		return true
+	}
+	p, ok := s.items[parent]
+	return ok && p.index >= 0 && !p.due.After(now) && s.depth(p) < s.depth(it)
+}
+
+// depth returns the number of scheduled ancestors of it.
+func (s *Scheduler) depth(it *item) int {
+	seen := map[string]bool{it.target.Id(): true}
+	depth := 0
+	for id := it.target.Parent(); id != "" && !seen[id]; depth++ {
+		p, ok := s.items[id]
+		if !ok {
+			break
+		}
+		seen[id] = true
+		id = p.target.Parent()
+	}
+	return depth
+}
+
+// notify wakes the waiting workers, s.m must be held.
+func (s *Scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+type item struct {
+	target Target
+	due    time.Time
+
+	// index is the position of the item in the queue, or -1 while it is being refreshed.
+	index int
+
+	// pending is the earliest due time passed to Schedule while the item was being refreshed, it is zero if there
+	// wasn't one.
+	pending time.Time
+
+	// removed is set when Remove is called while the item is being refreshed.
+	removed bool
+}
+
+// queue is a container/heap ordered by due time.
+type queue []*item
+
+func (q queue) Len() int           { return len(q) }
+func (q queue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q queue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+// due calls f with each item that is due at now and returns the earliest due time after now, or zero if there isn't
+// one. An item is never due before its parent in the heap, so only the due items and the ones right below them are
+// visited.
+func (q queue) due(now time.Time, f func(*item)) time.Time {
+	var next time.Time
+	for stack := []int{0}; len(stack) != 0; {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(q) {
+			continue
+		}
+
+		if it := q[i]; it.due.After(now) {
+			if next.IsZero() || it.due.Before(next) {
+				next = it.due
+			}
+		} else {
+			f(it)
+			stack = append(stack, 2*i+1, 2*i+2)
+		}
+	}
+	return next
+}
+
+func (q *queue) Push(x any) {
+	it := x.(*item)
+	it.index = len(*q)
+	*q = append(*q, it)
+}
+
+func (q *queue) Pop() any {
+	old := *q
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	it.index = -1
+	*q = old[:len(old)-1]
+	return it
+}
@@ -0,0 +1,296 @@
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+var ctx = utils.NewContext(context.Background())
+
+// mockTarget records its refreshes in log, the credentials it returns expire after lifetime.
+type mockTarget struct {
+	id, parent string
+	lifetime   time.Duration
+	err        error
+	delay      time.Duration
+
+	log *refreshLog
+}
+
+func (t *mockTarget) Id() string     { return t.id }
+func (t *mockTarget) Parent() string { return t.parent }
+func (t *mockTarget) Refresh(utils.Context) (aws.Credentials, error) {
+	t.log.start(t.id)
+	defer t.log.end(t.id)
+	time.Sleep(t.delay)
+
+	if t.err != nil {
+		return aws.Credentials{}, t.err
+	}
+	return aws.Credentials{CanExpire: true, Expires: time.Now().Add(t.lifetime)}, nil
+}
+
+type refreshLog struct {
+	m             sync.Mutex
+	order         []string
+	running, peak int
+}
+
+func (l *refreshLog) start(id string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.order = append(l.order, id)
+	l.running++
+	l.peak = max(l.peak, l.running)
+}
+
+func (l *refreshLog) end(string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.running--
+}
+
+// events returns a channel that receives each event emitted by s.
+func events(s *Scheduler) chan Event {
+	ch := make(chan Event, 100)
+	s.Subscribe(func(e Event) { ch <- e })
+	return ch
+}
+
+func receive(t *testing.T, ch chan Event, n int) []Event {
+	var resp []Event
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-ch:
+			resp = append(resp, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d of %d", i+1, n)
+		}
+	}
+	return resp
+}
+
+func TestScheduler_next(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		opts     Options
+		expires  time.Time
+		err      error
+		want     time.Duration
+		wantNext bool
+	}{
+		{name: "fraction", opts: Options{Fraction: 0.5}, expires: now.Add(time.Hour), want: 30 * time.Minute, wantNext: true},
+		{name: "interval first", opts: Options{Fraction: 0.5, Interval: time.Minute}, expires: now.Add(time.Hour), want: time.Minute, wantNext: true},
+		{name: "fraction first", opts: Options{Fraction: 0.5, Interval: time.Hour}, expires: now.Add(time.Hour), want: 30 * time.Minute, wantNext: true},
+		{name: "no expiry", opts: Options{Fraction: 0.5, Interval: time.Minute}, want: time.Minute, wantNext: true},
+		{name: "never", opts: Options{Fraction: 0.5}, wantNext: false},
+		{name: "expired", opts: Options{Fraction: 0.5, MinDelay: time.Second}, expires: now.Add(-time.Hour), want: time.Second, wantNext: true},
+		{name: "failed", opts: Options{Fraction: 0.5, Retry: 10 * time.Second}, expires: now.Add(time.Hour), err: errors.New("test"), want: 10 * time.Second, wantNext: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scheduler{opts: tt.opts}
+			got, ok := s.next(now, tt.expires, tt.err)
+			if ok != tt.wantNext {
+				t.Fatalf("got %v, want %v", ok, tt.wantNext)
+			} else if ok && got.Sub(now) != tt.want {
+				t.Errorf("got %s, want %s", got.Sub(now), tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduler_Jitter(t *testing.T) {
+	now := time.Now()
+	s := &Scheduler{opts: Options{Interval: time.Minute, Jitter: 0.1}}
+	for i := 0; i < 100; i++ {
+		got, _ := s.next(now, time.Time{}, nil)
+		if d := got.Sub(now); d < 54*time.Second || d > 66*time.Second {
+			t.Fatalf("got %s, want within 10%% of 1m", d)
+		}
+	}
+}
+
+// TestScheduler_ParentsFirst ensures a child that is due with its parent is refreshed after it.
+func TestScheduler_ParentsFirst(t *testing.T) {
+	runCtx, cancel := ctx.WithCancel()
+	defer cancel()
+
+	s := New(runCtx, Options{Fraction: 0.5, Workers: 4})
+	ch := events(s)
+
+	log := &refreshLog{}
+	grandchild := &mockTarget{id: "c", parent: "b", lifetime: time.Hour, log: log}
+	child := &mockTarget{id: "b", parent: "a", lifetime: time.Hour, log: log}
+	parent := &mockTarget{id: "a", lifetime: time.Hour, delay: 10 * time.Millisecond, log: log}
+
+	// Added in reverse order and with the children due first.
+	now := time.Now()
+	s.Schedule(grandchild, now.Add(-2*time.Second))
+	s.Schedule(child, now.Add(-time.Second))
+	s.Schedule(parent, now)
+
+	for _, e := range receive(t, ch, 3) {
+		if e.Err != nil {
+			t.Errorf("%s: %s", e.Id, e.Err)
+		} else if d := time.Until(e.Next); d < 29*time.Minute || d > 31*time.Minute {
+			t.Errorf("%s: got next refresh in %s, want 30m", e.Id, d)
+		}
+	}
+
+	log.m.Lock()
+	defer log.m.Unlock()
+	if got := log.order; len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("got order %v, want [a b c]", got)
+	}
+	if s.Len() != 3 {
+		t.Errorf("got %d scheduled, want 3", s.Len())
+	}
+}
+
+// TestScheduler_Workers ensures no more than Workers refreshes run at once.
+func TestScheduler_Workers(t *testing.T) {
+	runCtx, cancel := ctx.WithCancel()
+	defer cancel()
+
+	s := New(runCtx, Options{Fraction: 0.5, Workers: 2})
+	ch := events(s)
+
+	log := &refreshLog{}
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		s.Schedule(&mockTarget{id: id, lifetime: time.Hour, delay: 5 * time.Millisecond, log: log}, time.Now())
+	}
+	receive(t, ch, 6)
+
+	log.m.Lock()
+	defer log.m.Unlock()
+	if log.peak != 2 {
+		t.Errorf("got %d refreshes at once, want 2", log.peak)
+	}
+}
+
+// TestScheduler_Failed ensures failures are retried and emitted as events.
+func TestScheduler_Failed(t *testing.T) {
+	runCtx, cancel := ctx.WithCancel()
+	defer cancel()
+
+	s := New(runCtx, Options{Fraction: 0.5, Retry: 10 * time.Millisecond, Workers: 1})
+	ch := events(s)
+
+	target := &mockTarget{id: "a", err: errors.New("denied"), log: &refreshLog{}}
+	s.Schedule(target, time.Now())
+
+	for _, e := range receive(t, ch, 2) {
+		if e.Err == nil || e.Id != "a" || e.Next.IsZero() {
+			t.Errorf("got %+v, want a failure with a retry", e)
+		}
+	}
+}
+
+// TestScheduler_Cancel ensures the workers exit when the context is done.
+func TestScheduler_Cancel(t *testing.T) {
+	runCtx, cancel := ctx.WithCancel()
+	s := New(runCtx, Options{Interval: time.Hour, Workers: 1})
+	ch := events(s)
+
+	cancel()
+	s.Schedule(&mockTarget{id: "a", log: &refreshLog{}}, time.Now())
+
+	select {
+	case e := <-ch:
+		t.Errorf("got %+v after the context was cancelled", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestScheduler_ScheduleWhileRefreshing ensures a target scheduled while it is being refreshed is refreshed again
+// afterwards, rather than waiting for its next refresh.
+func TestScheduler_ScheduleWhileRefreshing(t *testing.T) {
+	runCtx, cancel := ctx.WithCancel()
+	defer cancel()
+
+	s := New(runCtx, Options{Interval: time.Hour, Workers: 1})
+	ch := events(s)
+
+	log := &refreshLog{}
+	target := &mockTarget{id: "a", delay: 20 * time.Millisecond, log: log}
+	s.Schedule(target, time.Now())
+
+	for started := false; !started; time.Sleep(time.Millisecond) {
+		log.m.Lock()
+		started = len(log.order) != 0
+		log.m.Unlock()
+	}
+	s.Schedule(target, time.Now())
+
+	got := receive(t, ch, 2)
+	if d := time.Until(got[1].Next); d < 59*time.Minute {
+		t.Errorf("got next refresh in %s after the second refresh, want 1h", d)
+	}
+}
+
+// TestQueue_due ensures only the due items are visited and the earliest due time after now is returned.
+func TestQueue_due(t *testing.T) {
+	now := time.Now()
+	q := queue{}
+	for i, offset := range []int{5, -3, 8, -1, 2, -7, 0, 9, -2} {
+		heap.Push(&q, &item{target: &mockTarget{id: fmt.Sprint(i)}, due: now.Add(time.Duration(offset) * time.Second)})
+	}
+
+	var got []string
+	next := q.due(now, func(it *item) {
+		got = append(got, it.target.Id())
+	})
+	sort.Strings(got)
+
+	if want := []string{"1", "3", "5", "6", "8"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if want := now.Add(2 * time.Second); !next.Equal(want) {
+		t.Errorf("got next %s, want %s", next, want)
+	}
+}
+
+// TestScheduler_Remove ensures removed targets aren't refreshed, including ones that are being refreshed.
+func TestScheduler_Remove(t *testing.T) {
+	runCtx, cancel := ctx.WithCancel()
+	defer cancel()
+
+	s := New(runCtx, Options{Interval: 10 * time.Millisecond, Workers: 1})
+	ch := events(s)
+
+	log := &refreshLog{}
+	s.Schedule(&mockTarget{id: "a", log: log}, time.Now().Add(time.Hour))
+	s.Remove("a")
+	if s.Len() != 0 {
+		t.Errorf("got %d scheduled, want 0", s.Len())
+	}
+
+	running := &mockTarget{id: "b", delay: 20 * time.Millisecond, log: log}
+	s.Schedule(running, time.Now())
+	for started := false; !started; time.Sleep(time.Millisecond) {
+		log.m.Lock()
+		started = len(log.order) != 0
+		log.m.Unlock()
+	}
+	s.Remove("b")
+
+	if e := receive(t, ch, 1)[0]; e.Id != "b" || !e.Next.IsZero() {
+		t.Errorf("got %+v, want b without a next refresh", e)
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("got %+v after b was removed", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,221 @@
+// Package secret encrypts the credentials saved with the graph.
+//
+// A random data key is generated for each Keyring and used to encrypt values with AES-GCM. The data key is wrapped with
+// age, either with a passphrase (scrypt) or for a list of X25519 recipients, and stored next to each value. This keeps
+// every value self-contained while only running the KDF once per data key.
+package secret
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PassphraseEnv is the environment variable the passphrase is read from when it isn't prompted for.
+const PassphraseEnv = "LIQUIDSWARDS_PASSPHRASE"
+
+// prefix identifies values sealed by this package and the format version.
+const prefix = "lqs1"
+
+// ErrNoIdentity is returned by Open when the keyring can only be used to encrypt.
+var ErrNoIdentity = errors.New("no identity to decrypt with")
+
+// Keyring seals values with a data key that is wrapped for its recipients.
+type Keyring struct {
+	recipients []age.Recipient
+	identities []age.Identity
+
+	mu      sync.Mutex
+	key     []byte
+	wrapped string
+	keys    map[string][]byte
+}
+
+// NewPassphraseKeyring returns a keyring that derives the key from passphrase with scrypt.
+func NewPassphraseKeyring(passphrase string) (*Keyring, error) {
+	return newPassphraseKeyring(passphrase, 0)
+}
+
+// newPassphraseKeyring allows lowering the scrypt work factor in tests, 0 uses age's default.
+func newPassphraseKeyring(passphrase string, workFactor int) (*Keyring, error) {
+	r, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("NewPassphraseKeyring(): %w", err)
+	}
+	if workFactor != 0 {
+		r.SetWorkFactor(workFactor)
+	}
+
+	i, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("NewPassphraseKeyring(): %w", err)
+	}
+	return &Keyring{recipients: []age.Recipient{r}, identities: []age.Identity{i}}, nil
+}
+
+// NewAgeKeyring returns a keyring using the X25519 recipients and identities in the given age files. Either path may
+// be empty, without recipients the keyring can only decrypt and without identities it can only encrypt.
+func NewAgeKeyring(recipientsPath, identityPath string) (*Keyring, error) {
+	k := &Keyring{}
+	if recipientsPath != "" {
+		f, err := os.Open(recipientsPath)
+		if err != nil {
+			return nil, fmt.Errorf("NewAgeKeyring(): %w", err)
+		}
+		defer f.Close()
+
+		if k.recipients, err = age.ParseRecipients(f); err != nil {
+			return nil, fmt.Errorf("NewAgeKeyring(): %s: %w", recipientsPath, err)
+		}
+	}
+	if identityPath != "" {
+		f, err := os.Open(identityPath)
+		if err != nil {
+			return nil, fmt.Errorf("NewAgeKeyring(): %w", err)
+		}
+		defer f.Close()
+
+		if k.identities, err = age.ParseIdentities(f); err != nil {
+			return nil, fmt.Errorf("NewAgeKeyring(): %s: %w", identityPath, err)
+		}
+	}
+	return k, nil
+}
+
+// CanSeal returns true if the keyring has recipients to encrypt for.
+func (k *Keyring) CanSeal() bool {
+	return len(k.recipients) != 0
+}
+
+// Seal encrypts plaintext and returns it as a printable string.
+func (k *Keyring) Seal(plaintext []byte) (string, error) {
+	key, wrapped, err := k.dataKey()
+	if err != nil {
+		return "", fmt.Errorf("Seal(): %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", fmt.Errorf("Seal(): %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("Seal(): %w", err)
+	}
+
+	ct := aead.Seal(nonce, nonce, plaintext, []byte(prefix))
+	return strings.Join([]string{prefix, wrapped, base64.RawStdEncoding.EncodeToString(ct)}, "."), nil
+}
+
+// Open decrypts a value returned by Seal.
+func (k *Keyring) Open(sealed string) ([]byte, error) {
+	p := strings.Split(sealed, ".")
+	if len(p) != 3 || p[0] != prefix {
+		return nil, fmt.Errorf("Open(): unknown format")
+	}
+
+	key, err := k.unwrap(p[1])
+	if err != nil {
+		return nil, fmt.Errorf("Open(): %w", err)
+	}
+	ct, err := base64.RawStdEncoding.DecodeString(p[2])
+	if err != nil {
+		return nil, fmt.Errorf("Open(): %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("Open(): %w", err)
+	}
+	if len(ct) < aead.NonceSize() {
+		return nil, fmt.Errorf("Open(): ciphertext is too short")
+	}
+	plaintext, err := aead.Open(nil, ct[:aead.NonceSize()], ct[aead.NonceSize():], []byte(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("Open(): %w", err)
+	}
+	return plaintext, nil
+}
+
+// dataKey returns the key values are sealed with, generating and wrapping it the first time it's called.
+func (k *Keyring) dataKey() ([]byte, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.key != nil {
+		return k.key, k.wrapped, nil
+	} else if !k.CanSeal() {
+		return nil, "", fmt.Errorf("no recipients to encrypt for")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, k.recipients...)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := w.Write(key); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	k.key = key
+	k.wrapped = base64.RawStdEncoding.EncodeToString(buf.Bytes())
+	return k.key, k.wrapped, nil
+}
+
+// unwrap decrypts a wrapped data key, keys are cached since every value saved at the same time shares one.
+func (k *Keyring) unwrap(wrapped string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.keys[wrapped]; ok {
+		return key, nil
+	} else if wrapped == k.wrapped {
+		return k.key, nil
+	} else if len(k.identities) == 0 {
+		return nil, ErrNoIdentity
+	}
+
+	b, err := base64.RawStdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(b), k.identities...)
+	if err != nil {
+		return nil, err
+	}
+	key, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.keys == nil {
+		k.keys = map[string][]byte{}
+	}
+	k.keys[wrapped] = key
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
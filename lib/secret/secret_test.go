@@ -0,0 +1,101 @@
+package secret
+
+import (
+	"errors"
+	"filippo.io/age"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPassphraseKeyring(t *testing.T) {
+	k, err := newPassphraseKeyring("correct horse", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sealed []string
+	for _, v := range []string{"first", "second"} {
+		s, err := k.Seal([]byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(s, v) {
+			t.Errorf("sealed value contains the plaintext: %s", s)
+		}
+		sealed = append(sealed, s)
+	}
+
+	// A new keyring with the same passphrase, like the next time the graph is loaded.
+	other, err := newPassphraseKeyring("correct horse", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"first", "second"} {
+		got, err := other.Open(sealed[i])
+		if err != nil {
+			t.Fatal(err)
+		} else if string(got) != want {
+			t.Errorf("Open(): got %q, want %q", got, want)
+		}
+	}
+
+	wrong, err := newPassphraseKeyring("wrong", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Open(sealed[0]); err == nil {
+		t.Error("Open() with the wrong passphrase: expected an error")
+	}
+
+	tampered := sealed[0][:len(sealed[0])-2] + "AA"
+	if _, err := k.Open(tampered); err == nil {
+		t.Error("Open() of a tampered value: expected an error")
+	}
+}
+
+func TestAgeKeyring(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	recipients := filepath.Join(dir, "recipients.txt")
+	identity := filepath.Join(dir, "key.txt")
+	if err := os.WriteFile(recipients, []byte(id.Recipient().String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(identity, []byte(id.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	encryptOnly, err := NewAgeKeyring(recipients, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := encryptOnly.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data key is remembered, but a keyring without an identity can't open values from other keyrings.
+	if got, err := encryptOnly.Open(sealed); err != nil || string(got) != "secret" {
+		t.Errorf("Open() with the same keyring: got %q, %v", got, err)
+	}
+	if _, err := (&Keyring{recipients: encryptOnly.recipients}).Open(sealed); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("Open() without an identity: got %v, want ErrNoIdentity", err)
+	}
+
+	decryptOnly, err := NewAgeKeyring("", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decryptOnly.CanSeal() {
+		t.Error("CanSeal(): expected false without recipients")
+	}
+	if got, err := decryptOnly.Open(sealed); err != nil || string(got) != "secret" {
+		t.Errorf("Open(): got %q, %v", got, err)
+	}
+}
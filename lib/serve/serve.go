@@ -0,0 +1,342 @@
+// Package serve exposes the credentials of a principal in the graph over HTTP, for tools that can't use profiles.
+//
+// Two formats are supported:
+//
+//   - The ECS container credentials provider, used by setting AWS_CONTAINER_CREDENTIALS_FULL_URI to <url>/creds and
+//     AWS_CONTAINER_AUTHORIZATION_TOKEN to the token.
+//   - The EC2 instance metadata service (IMDSv2), used by setting AWS_EC2_METADATA_SERVICE_ENDPOINT to the URL of a
+//     separate listener serving IMDSHandler. SDKs only keep the host and port of the endpoint, so it uses the
+//     standard paths and is only protected by the IMDSv2 session token, like the real service.
+//
+// The principal can be switched at runtime with a PUT to /admin/role.
+package serve
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSessionTTL is the longest IMDSv2 session token EC2 allows.
+	maxSessionTTL = 6 * time.Hour
+
+	// defaultExpiration is returned for credentials that don't expire, both formats require an expiration.
+	defaultExpiration = time.Hour
+)
+
+// Server serves the credentials of one principal in the graph at a time.
+type Server struct {
+	utils.Context
+	Graph *graph.Graph[*creds.Config]
+
+	// Token authenticates requests, it is sent in the Authorization header or as the first part of the IMDS path.
+	Token string
+
+	// Resolve returns the ARN of the principal passed to the admin route.
+	Resolve func(string) (string, error)
+
+	mu       sync.Mutex
+	arn      string
+	sessions map[string]time.Time
+
+	// refreshing serializes the refreshes of each principal without holding mu, so the other routes don't wait on them.
+	refreshing map[string]*sync.Mutex
+}
+
+// New returns a server for the credentials of arn.
+func New(ctx utils.Context, g *graph.Graph[*creds.Config], arn string, token string) (*Server, error) {
+	if len(token) < 16 {
+		return nil, fmt.Errorf("New(): the auth token must be at least 16 characters")
+	}
+
+	s := &Server{
+		Context:    ctx,
+		Graph:      g,
+		Token:      token,
+		sessions:   map[string]time.Time{},
+		refreshing: map[string]*sync.Mutex{},
+		Resolve: func(arn string) (string, error) {
+			return arn, nil
+		},
+	}
+	if err := s.SetArn(arn); err != nil {
+		return nil, fmt.Errorf("New(): %w", err)
+	}
+	return s, nil
+}
+
+// NewToken returns a random auth token.
+func NewToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("NewToken(): %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Listen listens on addr, which has to be a loopback address so the credentials aren't exposed to the network.
+func Listen(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Listen(): %w", err)
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return nil, fmt.Errorf("Listen(): %s is not a loopback IP address", host)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Listen(): %w", err)
+	}
+	return l, nil
+}
+
+// Arn returns the principal currently being served.
+func (s *Server) Arn() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.arn
+}
+
+// SetArn switches the principal being served.
+func (s *Server) SetArn(arn string) error {
+	if _, ok := s.Graph.GetNode(arn); !ok {
+		return fmt.Errorf("SetArn(): %s not found in graph", arn)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.arn = arn
+	return nil
+}
+
+// Handler returns the HTTP handler for the ECS credentials and admin routes, these require the auth token.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /creds", s.authorized(s.ecsCredentials))
+	mux.HandleFunc("GET /admin/role", s.authorized(s.getRole))
+	mux.HandleFunc("PUT /admin/role", s.authorized(s.putRole))
+	return loopbackOnly(mux)
+}
+
+// IMDSHandler returns the HTTP handler for the IMDSv2 routes. It needs its own listener since SDKs send requests to
+// the standard paths regardless of the path of the configured endpoint.
+func (s *Server) IMDSHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /latest/api/token", s.imdsToken)
+	mux.HandleFunc("GET /latest/meta-data/iam/security-credentials/", s.imdsSession(s.imdsRoleName))
+	mux.HandleFunc("GET /latest/meta-data/iam/security-credentials/{name}", s.imdsSession(s.imdsCredentials))
+	return loopbackOnly(mux)
+}
+
+// loopbackOnly rejects requests with a Host header that isn't a loopback address, this prevents websites from reaching
+// the server through DNS rebinding.
+func loopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ip := net.ParseIP(strings.Trim(host, "[]")); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// authorized requires the auth token in the Authorization header, as sent by the ECS credentials provider.
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.validToken(r.Header.Get("Authorization")) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// imdsSession requires an IMDSv2 session token.
+func (s *Server) imdsSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := r.Header.Get("X-aws-ec2-metadata-token")
+
+		s.mu.Lock()
+		expires, ok := s.sessions[session]
+		s.mu.Unlock()
+
+		if !ok || time.Now().After(expires) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) imdsToken(w http.ResponseWriter, r *http.Request) {
+	// Like EC2, sessions aren't handed out to requests that went through a proxy.
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	ttl, err := strconv.Atoi(r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+	if err != nil || ttl < 1 || time.Duration(ttl)*time.Second > maxSessionTTL {
+		http.Error(w, "invalid X-aws-ec2-metadata-token-ttl-seconds", http.StatusBadRequest)
+		return
+	}
+
+	session, err := NewToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	for k, expires := range s.sessions {
+		if now.After(expires) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[session] = now.Add(time.Duration(ttl) * time.Second)
+	s.mu.Unlock()
+
+	w.Header().Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(ttl))
+	_, _ = io.WriteString(w, session)
+}
+
+func (s *Server) imdsRoleName(w http.ResponseWriter, r *http.Request) {
+	node, _ := s.Graph.GetNode(s.Arn())
+	_, _ = io.WriteString(w, node.Value().Name())
+}
+
+type imdsCredentials struct {
+	Code            string
+	LastUpdated     string
+	Type            string
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+func (s *Server) imdsCredentials(w http.ResponseWriter, r *http.Request) {
+	node, _ := s.Graph.GetNode(s.Arn())
+	if r.PathValue("name") != node.Value().Name() {
+		http.NotFound(w, r)
+		return
+	}
+
+	c, err := s.credentials(node.Value().Id())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, imdsCredentials{
+		Code:            "Success",
+		LastUpdated:     time.Now().UTC().Format(time.RFC3339),
+		Type:            "AWS-HMAC",
+		AccessKeyId:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		Token:           c.SessionToken,
+		Expiration:      c.Expires.UTC().Format(time.RFC3339),
+	})
+}
+
+type ecsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+	RoleArn         string
+}
+
+func (s *Server) ecsCredentials(w http.ResponseWriter, r *http.Request) {
+	arn := s.Arn()
+	c, err := s.credentials(arn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, ecsCredentials{
+		AccessKeyId:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		Token:           c.SessionToken,
+		Expiration:      c.Expires.UTC().Format(time.RFC3339),
+		RoleArn:         arn,
+	})
+}
+
+// credentials returns the credentials of arn, refreshing them through the graph when they expire.
+func (s *Server) credentials(arn string) (aws.Credentials, error) {
+	s.mu.Lock()
+	m, ok := s.refreshing[arn]
+	if !ok {
+		m = &sync.Mutex{}
+		s.refreshing[arn] = m
+	}
+	s.mu.Unlock()
+
+	m.Lock()
+	defer m.Unlock()
+
+	c, err := creds.Fresh(s.Context, s.Graph, arn)
+	if err != nil {
+		s.Error.Printf("retrieving credentials for %s: %s\n", arn, err)
+		return c, err
+	}
+	if !c.CanExpire {
+		c.Expires = time.Now().Add(defaultExpiration)
+	}
+	return c, nil
+}
+
+func (s *Server) getRole(w http.ResponseWriter, r *http.Request) {
+	_, _ = fmt.Fprintln(w, s.Arn())
+}
+
+func (s *Server) putRole(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	arn, err := s.Resolve(strings.TrimSpace(string(b)))
+	if err == nil {
+		err = s.SetArn(arn)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Info.Printf("now serving credentials for %s\n", arn)
+	_, _ = fmt.Fprintln(w, arn)
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,187 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ctx = utils.NewContext(context.Background())
+
+const (
+	token = "0123456789abcdef0123"
+	roleA = "arn:aws:iam::123456789012:role/a"
+	roleB = "arn:aws:iam::123456789012:role/b"
+)
+
+func NewTestServer(t *testing.T) (*Server, *httptest.Server) {
+	g := graph.NewDirectedGraph[*creds.Config]()
+	for _, arn := range []string{roleA, roleB} {
+		cfg := utils.Must(creds.NewConfig(ctx, "us-east-1", creds.Identity{Type: creds.SourceAssumeRole, Name: arn, Arn: arn}))
+		cfg.SetProvider(aws.NewCredentialsCache(credentials.StaticCredentialsProvider{Value: aws.Credentials{
+			AccessKeyID:     "AKIA" + strings.ToUpper(cfg.Name()),
+			SecretAccessKey: "secret",
+			SessionToken:    "session",
+			CanExpire:       true,
+			Expires:         time.Now().Add(time.Hour),
+		}}))
+		g.AddNode(cfg)
+	}
+
+	s, err := New(ctx, g, roleA, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func do(t *testing.T, method, url string, headers map[string]string, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestServer_ECS(t *testing.T) {
+	_, srv := NewTestServer(t)
+
+	if code, _ := do(t, "GET", srv.URL+"/creds", map[string]string{"Authorization": "wrong"}, ""); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// The SDK's own container credentials provider can use the endpoint.
+	provider := endpointcreds.New(srv.URL+"/creds", func(o *endpointcreds.Options) {
+		o.AuthorizationToken = token
+	})
+	got, err := provider.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AccessKeyID != "AKIAA" || got.SessionToken != "session" || !got.CanExpire {
+		t.Errorf("unexpected credentials: %+v", got)
+	}
+}
+
+func TestServer_IMDS(t *testing.T) {
+	s, _ := NewTestServer(t)
+	srv := httptest.NewServer(s.IMDSHandler())
+	t.Cleanup(srv.Close)
+	base := srv.URL + "/latest"
+
+	if code, _ := do(t, "GET", base+"/meta-data/iam/security-credentials/", nil, ""); code != http.StatusUnauthorized {
+		t.Errorf("without a session: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	proxied := map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60", "X-Forwarded-For": "192.0.2.1"}
+	if code, _ := do(t, "PUT", base+"/api/token", proxied, ""); code != http.StatusForbidden {
+		t.Errorf("through a proxy: got status %d, want %d", code, http.StatusForbidden)
+	}
+
+	code, session := do(t, "PUT", base+"/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"}, "")
+	if code != http.StatusOK {
+		t.Fatalf("PUT api/token: got status %d", code)
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": session}
+
+	if _, name := do(t, "GET", base+"/meta-data/iam/security-credentials/", headers, ""); name != "a" {
+		t.Errorf("role name: got %q, want a", name)
+	}
+
+	code, body := do(t, "GET", base+"/meta-data/iam/security-credentials/a", headers, "")
+	var got imdsCredentials
+	if err := json.Unmarshal([]byte(body), &got); err != nil || code != http.StatusOK {
+		t.Fatalf("credentials: %d %s", code, body)
+	}
+	if got.Code != "Success" || got.AccessKeyId != "AKIAA" || got.Expiration == "" {
+		t.Errorf("unexpected credentials: %+v", got)
+	}
+
+	// The SDK's own instance role provider can use the endpoint.
+	provider := ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+		o.Client = imds.New(imds.Options{Endpoint: srv.URL})
+	})
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKIAA" || creds.SessionToken != "session" || !creds.CanExpire {
+		t.Errorf("unexpected credentials: %+v", creds)
+	}
+}
+
+func TestServer_Admin(t *testing.T) {
+	s, srv := NewTestServer(t)
+	auth := map[string]string{"Authorization": token}
+
+	if code, _ := do(t, "PUT", srv.URL+"/admin/role", nil, roleB); code != http.StatusUnauthorized {
+		t.Errorf("without a token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := do(t, "PUT", srv.URL+"/admin/role", auth, "arn:aws:iam::123456789012:role/missing"); code != http.StatusBadRequest {
+		t.Errorf("missing role: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code, _ := do(t, "PUT", srv.URL+"/admin/role", auth, roleB); code != http.StatusOK || s.Arn() != roleB {
+		t.Errorf("switching to b: got status %d, serving %s", code, s.Arn())
+	}
+
+	_, body := do(t, "GET", srv.URL+"/creds", auth, "")
+	if !strings.Contains(body, `"AccessKeyId":"AKIAB"`) {
+		t.Errorf("expected b's credentials after switching: %s", body)
+	}
+}
+
+func TestServer_Host(t *testing.T) {
+	_, srv := NewTestServer(t)
+	req := utils.Must(http.NewRequest("GET", srv.URL+"/creds", nil))
+	req.Host = "attacker.example.com"
+	req.Header.Set("Authorization", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("non-loopback Host: got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestListen(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", ":0", "192.0.2.1:0", "localhost:0"} {
+		if l, err := Listen(addr); err == nil {
+			l.Close()
+			t.Errorf("Listen(%s): expected an error", addr)
+		}
+	}
+
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
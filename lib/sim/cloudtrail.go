@@ -0,0 +1,107 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type lookupEventsInput struct {
+	StartTime        *float64
+	EndTime          *float64
+	LookupAttributes []struct {
+		AttributeKey   string
+		AttributeValue string
+	}
+}
+
+type lookupEvent struct {
+	EventId         string
+	EventName       string
+	EventTime       float64
+	CloudTrailEvent string
+}
+
+type lookupEventsOutput struct {
+	Events []lookupEvent
+}
+
+func (s *Simulator) serveCloudTrail(w http.ResponseWriter, r *http.Request, caller *principal) {
+	target := r.Header.Get("X-Amz-Target")
+	action := target[strings.LastIndex(target, ".")+1:]
+	s.record("cloudtrail", action)
+
+	if !allowed(caller.Actions, "cloudtrail:"+action) {
+		msg := fmt.Sprintf("User: %s is not authorized to perform: cloudtrail:%s", caller.Arn, action)
+		jsonError(w, http.StatusBadRequest, "AccessDeniedException", msg)
+		return
+	}
+
+	switch action {
+	case "LookupEvents":
+		s.lookupEvents(w, r, caller)
+	default:
+		jsonError(w, http.StatusBadRequest, "UnknownOperationException", fmt.Sprintf("cloudtrail:%s is not simulated", action))
+	}
+}
+
+// lookupEvents returns the events in the caller's account in a single page. Only the EventName lookup attribute is
+// supported.
+func (s *Simulator) lookupEvents(w http.ResponseWriter, r *http.Request, caller *principal) {
+	var in lookupEventsInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		jsonError(w, http.StatusBadRequest, "SerializationException", err.Error())
+		return
+	}
+
+	s.m.Lock()
+	events := s.events[caller.Account]
+	s.m.Unlock()
+
+	out := lookupEventsOutput{Events: []lookupEvent{}}
+	for i, event := range events {
+		t := float64(event.EventTime.UnixNano()) / float64(time.Second)
+		if in.StartTime != nil && t < *in.StartTime {
+			continue
+		} else if in.EndTime != nil && t >= *in.EndTime {
+			continue
+		}
+
+		matches := true
+		for _, attr := range in.LookupAttributes {
+			if attr.AttributeKey == "EventName" && attr.AttributeValue != event.EventName {
+				matches = false
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		out.Events = append(out.Events, lookupEvent{
+			EventId:         fmt.Sprintf("%s-%d", caller.Account, i),
+			EventName:       event.EventName,
+			EventTime:       t,
+			CloudTrailEvent: string(event.CloudTrailEvent),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// jsonError writes an error in the format used by the AWS JSON protocol (CloudTrail).
+func jsonError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, map[string]string{"__type": code, "message": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
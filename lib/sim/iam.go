@@ -0,0 +1,83 @@
+package sim
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type iamRole struct {
+	Path                     string `xml:"Path"`
+	RoleName                 string `xml:"RoleName"`
+	RoleId                   string `xml:"RoleId"`
+	Arn                      string `xml:"Arn"`
+	CreateDate               string `xml:"CreateDate"`
+	AssumeRolePolicyDocument string `xml:"AssumeRolePolicyDocument"`
+}
+
+type listRolesResponse struct {
+	XMLName xml.Name `xml:"ListRolesResponse"`
+	Result  struct {
+		IsTruncated bool      `xml:"IsTruncated"`
+		Roles       []iamRole `xml:"Roles>member"`
+	} `xml:"ListRolesResult"`
+	ResponseMetadata responseMetadata `xml:"ResponseMetadata"`
+}
+
+func (s *Simulator) serveIam(w http.ResponseWriter, r *http.Request, caller *principal) {
+	if err := r.ParseForm(); err != nil {
+		queryError(w, http.StatusBadRequest, "MalformedQueryString", err.Error())
+		return
+	}
+
+	action := r.PostForm.Get("Action")
+	s.record("iam", action)
+
+	if !allowed(caller.Actions, "iam:"+action) {
+		msg := fmt.Sprintf("User: %s is not authorized to perform: iam:%s", caller.Arn, action)
+		queryError(w, http.StatusForbidden, "AccessDenied", msg)
+		return
+	}
+
+	switch action {
+	case "ListRoles":
+		s.listRoles(w, caller)
+	default:
+		queryError(w, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("iam:%s is not simulated", action))
+	}
+}
+
+// listRoles returns all roles in the caller's account in a single page.
+func (s *Simulator) listRoles(w http.ResponseWriter, caller *principal) {
+	prefix := fmt.Sprintf("arn:aws:iam::%s:role/", caller.Account)
+
+	s.m.Lock()
+	var arns []string
+	for arn := range s.roles {
+		if strings.HasPrefix(arn, prefix) {
+			arns = append(arns, arn)
+		}
+	}
+	sort.Strings(arns)
+
+	resp := listRolesResponse{}
+	for _, arn := range arns {
+		role := s.roles[arn]
+		resp.Result.Roles = append(resp.Result.Roles, iamRole{
+			Path:       normalizePath(role.Path),
+			RoleName:   role.Name,
+			RoleId:     "AROA" + strings.ToUpper(role.Name),
+			Arn:        arn,
+			CreateDate: time.Unix(0, 0).UTC().Format(time.RFC3339),
+			// IAM returns trust policies URL encoded.
+			AssumeRolePolicyDocument: url.QueryEscape(string(role.TrustPolicy)),
+		})
+	}
+	s.m.Unlock()
+
+	writeXML(w, http.StatusOK, resp)
+}
@@ -0,0 +1,31 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/policy"
+)
+
+// trusts evaluates the role's trust policy for sts:AssumeRole by the caller. Identity permissions and SCP's are not
+// simulated, an account ID or root ARN in the trust policy grants access to every principal in the account.
+func trusts(doc json.RawMessage, caller *principal, externalId *string) (bool, error) {
+	trust, err := policy.Parse(string(doc))
+	if err != nil {
+		return false, fmt.Errorf("parsing trust policy: %w", err)
+	}
+
+	keys := map[string][]string{
+		"aws:PrincipalArn":     {caller.Id},
+		"aws:PrincipalAccount": {caller.Account},
+		"aws:userid":           {caller.UserId},
+	}
+	if externalId != nil {
+		keys["sts:ExternalId"] = []string{*externalId}
+	}
+
+	decision, err := trust.Evaluate(policy.Request{Principal: caller.Id, Context: keys})
+	if err != nil {
+		return false, fmt.Errorf("evaluating trust policy: %w", err)
+	}
+	return decision == policy.Allow, nil
+}
@@ -0,0 +1,327 @@
+// Package sim is an in-process simulator of the small set of AWS APIs liquidswards uses. It allows running full scans
+// without AWS, which is mostly useful for testing.
+//
+// The simulator is driven by a Fixture describing accounts, principals and role trust policies. Trust policies are
+// enforced on sts:AssumeRole, and the actions allowed for each principal are enforced on every call. Callers are
+// identified by the access key ID in the SigV4 Authorization header, signatures are not verified.
+//
+// The following APIs are supported:
+//
+//   - sts:AssumeRole
+//   - sts:GetCallerIdentity
+//   - iam:ListRoles
+//   - cloudtrail:LookupEvents
+//   - sqs:ReceiveMessage
+//   - sqs:DeleteMessage
+//
+// Point the SDK at the simulator with the resolver returned by Simulator.EndpointResolver.
+package sim
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fixture declares the state of the simulated AWS environment.
+type Fixture struct {
+	Accounts []Account `json:"Accounts"`
+}
+
+type Account struct {
+	Id     string  `json:"Id"`
+	Users  []User  `json:"Users"`
+	Roles  []Role  `json:"Roles"`
+	Events []Event `json:"Events"`
+	Queues []Queue `json:"Queues"`
+}
+
+type User struct {
+	Name            string `json:"Name"`
+	Path            string `json:"Path"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+
+	// Actions the user is allowed to call, for example "iam:ListRoles" or "sts:*". All actions are allowed if this
+	// is nil.
+	Actions []string `json:"Actions"`
+}
+
+type Role struct {
+	Name        string          `json:"Name"`
+	Path        string          `json:"Path"`
+	TrustPolicy json.RawMessage `json:"TrustPolicy"`
+
+	// Actions the role is allowed to call, see User.Actions.
+	Actions []string `json:"Actions"`
+}
+
+// Event is a CloudTrail event returned by cloudtrail:LookupEvents.
+type Event struct {
+	EventName       string          `json:"EventName"`
+	EventTime       time.Time       `json:"EventTime"`
+	CloudTrailEvent json.RawMessage `json:"CloudTrailEvent"`
+}
+
+type Queue struct {
+	Name     string   `json:"Name"`
+	Messages []string `json:"Messages"`
+}
+
+// LoadFixture reads a JSON encoded Fixture from path.
+func LoadFixture(path string) (Fixture, error) {
+	var fixture Fixture
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fixture, fmt.Errorf("LoadFixture(): %w", err)
+	}
+
+	if err := json.Unmarshal(b, &fixture); err != nil {
+		return fixture, fmt.Errorf("LoadFixture(): %w", err)
+	}
+	return fixture, nil
+}
+
+// principal is a user or an assumed role session.
+type principal struct {
+	Arn     string
+	Account string
+	UserId  string
+
+	// Id is the IAM ARN of the role or user, for roles this is the role ARN rather than the session ARN.
+	Id      string
+	Actions []string
+}
+
+type message struct {
+	id      string
+	handle  string
+	body    string
+	visible time.Time
+}
+
+// New returns a simulator for the given fixture, use Start to begin serving requests.
+func New(fixture Fixture) (*Simulator, error) {
+	s := &Simulator{
+		m:      &sync.Mutex{},
+		keys:   map[string]*principal{},
+		roles:  map[string]Role{},
+		events: map[string][]Event{},
+		queues: map[string][]*message{},
+	}
+
+	for _, account := range fixture.Accounts {
+		if !accountRe.MatchString(account.Id) {
+			return nil, fmt.Errorf("invalid account id: %s", account.Id)
+		}
+
+		for _, user := range account.Users {
+			arn := fmt.Sprintf("arn:aws:iam::%s:user%s%s", account.Id, normalizePath(user.Path), user.Name)
+			s.keys[user.AccessKeyId] = &principal{
+				Arn:     arn,
+				Id:      arn,
+				Account: account.Id,
+				UserId:  "AIDA" + strings.ToUpper(user.Name),
+				Actions: user.Actions,
+			}
+		}
+
+		for _, role := range account.Roles {
+			s.roles[roleArn(account.Id, role)] = role
+		}
+
+		s.events[account.Id] = account.Events
+
+		for _, queue := range account.Queues {
+			for _, body := range queue.Messages {
+				s.SendMessage(account.Id, queue.Name, body)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+type Simulator struct {
+	m      *sync.Mutex
+	server *httptest.Server
+	keys   map[string]*principal
+	roles  map[string]Role
+	events map[string][]Event
+	queues map[string][]*message
+	seq    int
+
+	// Calls records the Service:Action of each request received in order.
+	Calls []string
+
+	// AssumeRoleCalls records the RoleArn of each sts:AssumeRole request received in order.
+	AssumeRoleCalls []string
+}
+
+var accountRe = regexp.MustCompile(`^[0-9]{12}$`)
+
+// Start starts serving requests on a random local port.
+func (s *Simulator) Start() {
+	s.server = httptest.NewServer(s)
+}
+
+// Close stops the server started by Start.
+func (s *Simulator) Close() {
+	s.server.Close()
+}
+
+// URL returns the base URL of the server started by Start.
+func (s *Simulator) URL() string {
+	return s.server.URL
+}
+
+// EndpointResolver returns a resolver that points every service at the simulator.
+func (s *Simulator) EndpointResolver() aws.EndpointResolverWithOptions {
+	return aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		return aws.Endpoint{
+			URL:               s.URL(),
+			PartitionID:       "aws",
+			SigningRegion:     region,
+			HostnameImmutable: true,
+			Source:            aws.EndpointSourceCustom,
+		}, nil
+	})
+}
+
+// ServeHTTP routes requests to the service based on the credential scope of the Authorization header.
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	accessKey, service, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		queryError(w, http.StatusForbidden, "IncompleteSignature", err.Error())
+		return
+	}
+
+	s.m.Lock()
+	caller, ok := s.keys[accessKey]
+	s.m.Unlock()
+	if !ok {
+		queryError(w, http.StatusForbidden, "InvalidClientTokenId", "The security token included in the request is invalid.")
+		return
+	}
+
+	switch service {
+	case "sts":
+		s.serveSts(w, r, caller)
+	case "iam":
+		s.serveIam(w, r, caller)
+	case "cloudtrail":
+		s.serveCloudTrail(w, r, caller)
+	case "sqs":
+		s.serveSqs(w, r, caller)
+	default:
+		queryError(w, http.StatusBadRequest, "UnknownService", fmt.Sprintf("service %s is not simulated", service))
+	}
+}
+
+func (s *Simulator) record(service, action string) {
+	s.m.Lock()
+	s.Calls = append(s.Calls, service+":"+action)
+	s.m.Unlock()
+}
+
+// nextId returns a unique identifier with the given prefix.
+func (s *Simulator) nextId(prefix string) string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.seq++
+	return fmt.Sprintf("%s%016d", prefix, s.seq)
+}
+
+// parseAuthorization returns the access key and service from a SigV4 Authorization header, which looks like:
+//
+//	AWS4-HMAC-SHA256 Credential=AKID/20220101/us-east-1/sts/aws4_request, SignedHeaders=host, Signature=abcd
+func parseAuthorization(header string) (accessKey string, service string, err error) {
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(part), "AWS4-HMAC-SHA256"))
+		if !strings.HasPrefix(part, "Credential=") {
+			continue
+		}
+
+		scope := strings.Split(strings.TrimPrefix(part, "Credential="), "/")
+		if len(scope) != 5 {
+			return "", "", fmt.Errorf("malformed credential scope: %s", part)
+		}
+		return scope[0], scope[3], nil
+	}
+	return "", "", fmt.Errorf("missing credential in authorization header")
+}
+
+// allowed returns true if any of the patterns in actions match action, patterns may contain * wildcards. A nil list
+// allows everything.
+func allowed(actions []string, action string) bool {
+	if actions == nil {
+		return true
+	}
+	for _, pattern := range actions {
+		if utils.WildcardMatch(pattern, action) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if !strings.HasSuffix(path, "/") {
+		path = path + "/"
+	}
+	return path
+}
+
+func roleArn(account string, role Role) string {
+	return fmt.Sprintf("arn:aws:iam::%s:role%s%s", account, normalizePath(role.Path), role.Name)
+}
+
+type queryErrorResponse struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Error   struct {
+		Type    string `xml:"Type"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+	RequestId string `xml:"RequestId"`
+}
+
+// queryError writes an error in the format used by the AWS query protocol (STS, IAM and SQS).
+func queryError(w http.ResponseWriter, status int, code, msg string) {
+	resp := queryErrorResponse{RequestId: "00000000-0000-0000-0000-000000000000"}
+	resp.Error.Type = "Sender"
+	resp.Error.Code = code
+	resp.Error.Message = msg
+	writeXML(w, status, resp)
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write(append([]byte(xml.Header), b...))
+}
+
+type responseMetadata struct {
+	RequestId string `xml:"RequestId"`
+}
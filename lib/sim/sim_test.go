@@ -0,0 +1,262 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ctx = context.Background()
+
+var testFixture = []byte(`{
+  "Accounts": [
+    {
+      "Id": "111111111111",
+      "Users": [
+        {"Name": "alice", "AccessKeyId": "AKIAALICE", "SecretAccessKey": "secret"},
+        {"Name": "bob", "AccessKeyId": "AKIABOB", "SecretAccessKey": "secret", "Actions": ["sqs:*"]}
+      ],
+      "Roles": [
+        {
+          "Name": "a",
+          "TrustPolicy": {
+            "Version": "2012-10-17",
+            "Statement": [
+              {"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111111111111:user/alice"}, "Action": "sts:AssumeRole"}
+            ]
+          }
+        },
+        {
+          "Name": "b",
+          "Path": "/service/",
+          "TrustPolicy": {
+            "Version": "2012-10-17",
+            "Statement": [
+              {"Effect": "Allow", "Principal": {"AWS": ["arn:aws:iam::111111111111:role/a"]}, "Action": "sts:AssumeRole"}
+            ]
+          }
+        },
+        {
+          "Name": "vendor",
+          "TrustPolicy": {
+            "Version": "2012-10-17",
+            "Statement": [
+              {
+                "Effect": "Allow",
+                "Principal": {"AWS": "111111111111"},
+                "Action": "sts:AssumeRole",
+                "Condition": {"StringEquals": {"sts:ExternalId": "secret-id"}}
+              }
+            ]
+          }
+        }
+      ],
+      "Events": [
+        {
+          "EventName": "AssumeRole",
+          "EventTime": "2022-01-01T00:00:00Z",
+          "CloudTrailEvent": {"requestParameters": {"roleArn": "arn:aws:iam::111111111111:role/a"}}
+        }
+      ],
+      "Queues": [
+        {"Name": "events", "Messages": ["hello"]}
+      ]
+    }
+  ]
+}`)
+
+func NewTestSimulator(t *testing.T) *Simulator {
+	var fixture Fixture
+	if err := json.Unmarshal(testFixture, &fixture); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func NewTestConfig(s *Simulator, key string) aws.Config {
+	return aws.Config{
+		Region:                      "us-east-1",
+		Credentials:                 credentials.NewStaticCredentialsProvider(key, "secret", ""),
+		EndpointResolverWithOptions: s.EndpointResolver(),
+	}
+}
+
+func TestSimulator_AssumeRole(t *testing.T) {
+	s := NewTestSimulator(t)
+	alice := sts.NewFromConfig(NewTestConfig(s, "AKIAALICE"))
+
+	identity, err := alice.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *identity.Arn, "arn:aws:iam::111111111111:user/alice"; got != want {
+		t.Errorf("GetCallerIdentity() Arn: got %s, want %s", got, want)
+	}
+
+	// alice can't assume b directly, only through a.
+	_, err = alice.AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String("arn:aws:iam::111111111111:role/service/b"),
+		RoleSessionName: aws.String("test"),
+	})
+	assertErrorCode(t, err, "AccessDenied")
+
+	resp, err := alice.AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String("arn:aws:iam::111111111111:role/a"),
+		RoleSessionName: aws.String("test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *resp.AssumedRoleUser.Arn, "arn:aws:sts::111111111111:assumed-role/a/test"; got != want {
+		t.Errorf("AssumeRole() Arn: got %s, want %s", got, want)
+	}
+	if resp.Credentials.Expiration.Before(time.Now()) {
+		t.Errorf("AssumeRole() returned expired credentials: %s", resp.Credentials.Expiration)
+	}
+
+	roleA := NewTestConfig(s, *resp.Credentials.AccessKeyId)
+	if _, err = sts.NewFromConfig(roleA).AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String("arn:aws:iam::111111111111:role/service/b"),
+		RoleSessionName: aws.String("test"),
+	}); err != nil {
+		t.Errorf("AssumeRole() b from a: %s", err)
+	}
+
+	s.RevokeSessions("arn:aws:iam::111111111111:role/a")
+	_, err = sts.NewFromConfig(roleA).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	assertErrorCode(t, err, "InvalidClientTokenId")
+}
+
+func TestSimulator_ExternalId(t *testing.T) {
+	s := NewTestSimulator(t)
+	alice := sts.NewFromConfig(NewTestConfig(s, "AKIAALICE"))
+
+	for _, tt := range []struct {
+		externalId *string
+		wantCode   string
+	}{
+		{externalId: nil, wantCode: "AccessDenied"},
+		{externalId: aws.String("wrong"), wantCode: "AccessDenied"},
+		{externalId: aws.String("secret-id")},
+	} {
+		_, err := alice.AssumeRole(ctx, &sts.AssumeRoleInput{
+			RoleArn:         aws.String("arn:aws:iam::111111111111:role/vendor"),
+			RoleSessionName: aws.String("test"),
+			ExternalId:      tt.externalId,
+		})
+		if tt.wantCode == "" && err != nil {
+			t.Errorf("AssumeRole() with external id %v: %s", aws.ToString(tt.externalId), err)
+		} else if tt.wantCode != "" {
+			assertErrorCode(t, err, tt.wantCode)
+		}
+	}
+}
+
+func TestSimulator_ListRoles(t *testing.T) {
+	s := NewTestSimulator(t)
+
+	resp, err := iam.NewFromConfig(NewTestConfig(s, "AKIAALICE")).ListRoles(ctx, &iam.ListRolesInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, role := range resp.Roles {
+		got = append(got, *role.Arn)
+	}
+	want := []string{
+		"arn:aws:iam::111111111111:role/a",
+		"arn:aws:iam::111111111111:role/service/b",
+		"arn:aws:iam::111111111111:role/vendor",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ListRoles() (-got +want):\n%s", diff)
+	}
+	if !strings.Contains(*resp.Roles[0].AssumeRolePolicyDocument, "sts%3AAssumeRole") {
+		t.Errorf("expected url encoded trust policy, got: %s", *resp.Roles[0].AssumeRolePolicyDocument)
+	}
+
+	// bob is only allowed to use SQS.
+	_, err = iam.NewFromConfig(NewTestConfig(s, "AKIABOB")).ListRoles(ctx, &iam.ListRolesInput{})
+	assertErrorCode(t, err, "AccessDenied")
+}
+
+func TestSimulator_Sqs(t *testing.T) {
+	s := NewTestSimulator(t)
+	svc := sqs.NewFromConfig(NewTestConfig(s, "AKIABOB"))
+	queueUrl := aws.String("https://sqs.us-east-1.amazonaws.com/111111111111/events")
+
+	resp, err := svc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: queueUrl, MaxNumberOfMessages: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Messages) != 1 || *resp.Messages[0].Body != "hello" {
+		t.Fatalf("ReceiveMessage(): unexpected messages: %+v", resp.Messages)
+	}
+
+	if _, err := svc.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      queueUrl,
+		ReceiptHandle: resp.Messages[0].ReceiptHandle,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.SendMessage("111111111111", "events", "world")
+	resp, err = svc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: queueUrl, WaitTimeSeconds: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Messages) != 1 || *resp.Messages[0].Body != "world" {
+		t.Fatalf("ReceiveMessage(): unexpected messages: %+v", resp.Messages)
+	}
+}
+
+func TestSimulator_LookupEvents(t *testing.T) {
+	s := NewTestSimulator(t)
+
+	body := `{"StartTime": 1640995100, "EndTime": 1640995300, "LookupAttributes": [{"AttributeKey": "EventName", "AttributeValue": "AssumeRole"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIAALICE/20220101/us-east-1/cloudtrail/aws4_request, SignedHeaders=host, Signature=abcd")
+	req.Header.Set("X-Amz-Target", "com.amazonaws.cloudtrail.v20131101.CloudTrail_20131101.LookupEvents")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	var out lookupEventsOutput
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Events) != 1 || !strings.Contains(out.Events[0].CloudTrailEvent, "role/a") {
+		t.Errorf("LookupEvents(): unexpected events: %+v", out.Events)
+	}
+}
+
+func assertErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		t.Errorf("expected api error with code %s, got: %v", code, err)
+	} else if apiErr.ErrorCode() != code {
+		t.Errorf("error code: got %s, want %s", apiErr.ErrorCode(), code)
+	}
+}
@@ -0,0 +1,152 @@
+package sim
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// longPollInterval is how often a long polling ReceiveMessage call checks for new messages.
+const longPollInterval = 50 * time.Millisecond
+
+type sqsMessage struct {
+	MessageId     string `xml:"MessageId"`
+	ReceiptHandle string `xml:"ReceiptHandle"`
+	Body          string `xml:"Body"`
+}
+
+type receiveMessageResponse struct {
+	XMLName xml.Name `xml:"ReceiveMessageResponse"`
+	Result  struct {
+		Messages []sqsMessage `xml:"Message"`
+	} `xml:"ReceiveMessageResult"`
+	ResponseMetadata responseMetadata `xml:"ResponseMetadata"`
+}
+
+type deleteMessageResponse struct {
+	XMLName          xml.Name         `xml:"DeleteMessageResponse"`
+	ResponseMetadata responseMetadata `xml:"ResponseMetadata"`
+}
+
+// SendMessage adds a message to the queue in the given account, the queue is created if it doesn't exist.
+func (s *Simulator) SendMessage(account, queue, body string) {
+	msg := &message{
+		id:     s.nextId("msg-"),
+		handle: s.nextId("handle-"),
+		body:   body,
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	key := queueKey(account, queue)
+	s.queues[key] = append(s.queues[key], msg)
+}
+
+func queueKey(account, queue string) string {
+	return account + "/" + queue
+}
+
+// queueFromUrl returns the key of the queue referenced by a URL like https://sqs.us-east-1.amazonaws.com/<account>/<name>.
+func queueFromUrl(queueUrl string) string {
+	parts := strings.Split(strings.TrimSuffix(queueUrl, "/"), "/")
+	if len(parts) < 2 {
+		return queueUrl
+	}
+	return queueKey(parts[len(parts)-2], parts[len(parts)-1])
+}
+
+func (s *Simulator) serveSqs(w http.ResponseWriter, r *http.Request, caller *principal) {
+	if err := r.ParseForm(); err != nil {
+		queryError(w, http.StatusBadRequest, "MalformedQueryString", err.Error())
+		return
+	}
+
+	action := r.PostForm.Get("Action")
+	s.record("sqs", action)
+
+	if !allowed(caller.Actions, "sqs:"+action) {
+		msg := fmt.Sprintf("User: %s is not authorized to perform: sqs:%s", caller.Arn, action)
+		queryError(w, http.StatusForbidden, "AccessDenied", msg)
+		return
+	}
+
+	key := queueFromUrl(r.PostForm.Get("QueueUrl"))
+
+	switch action {
+	case "ReceiveMessage":
+		s.receiveMessage(w, r, key)
+	case "DeleteMessage":
+		s.deleteMessage(w, r, key)
+	default:
+		queryError(w, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("sqs:%s is not simulated", action))
+	}
+}
+
+func (s *Simulator) receiveMessage(w http.ResponseWriter, r *http.Request, key string) {
+	max := formInt(r, "MaxNumberOfMessages", 1)
+	wait := time.Duration(formInt(r, "WaitTimeSeconds", 0)) * time.Second
+	visibility := time.Duration(formInt(r, "VisibilityTimeout", 30)) * time.Second
+
+	deadline := time.Now().Add(wait)
+	resp := receiveMessageResponse{}
+	for {
+		now := time.Now()
+
+		s.m.Lock()
+		for _, msg := range s.queues[key] {
+			if len(resp.Result.Messages) >= max {
+				break
+			}
+			if msg.visible.After(now) {
+				continue
+			}
+			msg.visible = now.Add(visibility)
+			resp.Result.Messages = append(resp.Result.Messages, sqsMessage{
+				MessageId:     msg.id,
+				ReceiptHandle: msg.handle,
+				Body:          msg.body,
+			})
+		}
+		s.m.Unlock()
+
+		if len(resp.Result.Messages) != 0 || !now.Before(deadline) {
+			break
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(longPollInterval):
+		}
+	}
+
+	writeXML(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) deleteMessage(w http.ResponseWriter, r *http.Request, key string) {
+	handle := r.PostForm.Get("ReceiptHandle")
+
+	s.m.Lock()
+	msgs := s.queues[key]
+	for i, msg := range msgs {
+		if msg.handle == handle {
+			s.queues[key] = append(msgs[:i:i], msgs[i+1:]...)
+			break
+		}
+	}
+	s.m.Unlock()
+
+	writeXML(w, http.StatusOK, deleteMessageResponse{})
+}
+
+func formInt(r *http.Request, key string, def int) int {
+	v, err := strconv.Atoi(r.PostForm.Get(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
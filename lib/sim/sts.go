@@ -0,0 +1,138 @@
+package sim
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionDuration is how long credentials returned by sts:AssumeRole are valid for.
+var SessionDuration = time.Hour
+
+type stsCredentials struct {
+	AccessKeyId     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+type assumeRoleResponse struct {
+	XMLName xml.Name `xml:"AssumeRoleResponse"`
+	Result  struct {
+		Credentials     stsCredentials `xml:"Credentials"`
+		AssumedRoleUser struct {
+			Arn           string `xml:"Arn"`
+			AssumedRoleId string `xml:"AssumedRoleId"`
+		} `xml:"AssumedRoleUser"`
+	} `xml:"AssumeRoleResult"`
+	ResponseMetadata responseMetadata `xml:"ResponseMetadata"`
+}
+
+type getCallerIdentityResponse struct {
+	XMLName xml.Name `xml:"GetCallerIdentityResponse"`
+	Result  struct {
+		Arn     string `xml:"Arn"`
+		UserId  string `xml:"UserId"`
+		Account string `xml:"Account"`
+	} `xml:"GetCallerIdentityResult"`
+	ResponseMetadata responseMetadata `xml:"ResponseMetadata"`
+}
+
+func (s *Simulator) serveSts(w http.ResponseWriter, r *http.Request, caller *principal) {
+	if err := r.ParseForm(); err != nil {
+		queryError(w, http.StatusBadRequest, "MalformedQueryString", err.Error())
+		return
+	}
+
+	action := r.PostForm.Get("Action")
+	s.record("sts", action)
+
+	switch action {
+	case "GetCallerIdentity":
+		resp := getCallerIdentityResponse{}
+		resp.Result.Arn = caller.Arn
+		resp.Result.UserId = caller.UserId
+		resp.Result.Account = caller.Account
+		writeXML(w, http.StatusOK, resp)
+	case "AssumeRole":
+		s.assumeRole(w, r, caller)
+	default:
+		queryError(w, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("sts:%s is not simulated", action))
+	}
+}
+
+func (s *Simulator) assumeRole(w http.ResponseWriter, r *http.Request, caller *principal) {
+	arn := r.PostForm.Get("RoleArn")
+	session := r.PostForm.Get("RoleSessionName")
+
+	s.m.Lock()
+	s.AssumeRoleCalls = append(s.AssumeRoleCalls, arn)
+	s.m.Unlock()
+
+	var externalId *string
+	if _, ok := r.PostForm["ExternalId"]; ok {
+		v := r.PostForm.Get("ExternalId")
+		externalId = &v
+	}
+
+	denied := fmt.Sprintf("User: %s is not authorized to perform: sts:AssumeRole on resource: %s", caller.Arn, arn)
+
+	s.m.Lock()
+	role, ok := s.roles[arn]
+	s.m.Unlock()
+	if !ok || !allowed(caller.Actions, "sts:AssumeRole") {
+		queryError(w, http.StatusForbidden, "AccessDenied", denied)
+		return
+	}
+
+	trusted, err := trusts(role.TrustPolicy, caller, externalId)
+	if err != nil {
+		queryError(w, http.StatusInternalServerError, "InternalFailure", err.Error())
+		return
+	} else if !trusted {
+		queryError(w, http.StatusForbidden, "AccessDenied", denied)
+		return
+	}
+
+	parts := strings.Split(arn, ":")
+	account := parts[4]
+	roleId := "AROA" + strings.ToUpper(role.Name)
+
+	accessKey := s.nextId("ASIA")
+	p := &principal{
+		Arn:     fmt.Sprintf("arn:aws:sts::%s:assumed-role/%s/%s", account, role.Name, session),
+		Id:      arn,
+		Account: account,
+		UserId:  roleId + ":" + session,
+		Actions: role.Actions,
+	}
+	s.m.Lock()
+	s.keys[accessKey] = p
+	s.m.Unlock()
+
+	resp := assumeRoleResponse{}
+	resp.Result.Credentials = stsCredentials{
+		AccessKeyId:     accessKey,
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(SessionDuration).UTC().Format(time.RFC3339),
+	}
+	resp.Result.AssumedRoleUser.Arn = p.Arn
+	resp.Result.AssumedRoleUser.AssumedRoleId = p.UserId
+	writeXML(w, http.StatusOK, resp)
+}
+
+// RevokeSessions invalidates all credentials previously issued for the role, similar to revoking sessions in the
+// IAM console.
+func (s *Simulator) RevokeSessions(roleArn string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for key, p := range s.keys {
+		if p.Id == roleArn && strings.Contains(p.Arn, ":assumed-role/") {
+			delete(s.keys, key)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package snapshot
+
+import (
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"io"
+	"sort"
+)
+
+// Edge is an sts:AssumeRole call from Source to Target that succeeded in one of the scans.
+type Edge struct {
+	Source string
+	Target string
+}
+
+// Diff is the access that was gained or lost between two scans.
+type Diff struct {
+	AddedNodes   []string
+	RemovedNodes []string
+	AddedEdges   []Edge
+	RemovedEdges []Edge
+}
+
+// Compare returns the nodes and edges that are in new but not old and the other way around.
+func Compare[T graph.Value](old, new *graph.Graph[T]) Diff {
+	oldNodes, oldEdges := contents(old)
+	newNodes, newEdges := contents(new)
+
+	d := Diff{
+		AddedNodes:   missing(newNodes, oldNodes),
+		RemovedNodes: missing(oldNodes, newNodes),
+		AddedEdges:   missing(newEdges, oldEdges),
+		RemovedEdges: missing(oldEdges, newEdges),
+	}
+	sort.Strings(d.AddedNodes)
+	sort.Strings(d.RemovedNodes)
+	sortEdges(d.AddedEdges)
+	sortEdges(d.RemovedEdges)
+	return d
+}
+
+// Filter returns the part of the diff that involves the given account. Edges are kept if either side is in the
+// account, so access gained into or out of it is shown.
+func (d Diff) Filter(account string) Diff {
+	inAccount := func(arn string) bool {
+		id, err := utils.AccountIdFromArn(arn)
+		return err == nil && id == account
+	}
+	nodes := func(ids []string) []string {
+		var resp []string
+		for _, id := range ids {
+			if inAccount(id) {
+				resp = append(resp, id)
+			}
+		}
+		return resp
+	}
+	edges := func(edges []Edge) []Edge {
+		var resp []Edge
+		for _, e := range edges {
+			if inAccount(e.Source) || inAccount(e.Target) {
+				resp = append(resp, e)
+			}
+		}
+		return resp
+	}
+
+	return Diff{
+		AddedNodes:   nodes(d.AddedNodes),
+		RemovedNodes: nodes(d.RemovedNodes),
+		AddedEdges:   edges(d.AddedEdges),
+		RemovedEdges: edges(d.RemovedEdges),
+	}
+}
+
+// Empty returns true if nothing changed.
+func (d Diff) Empty() bool {
+	return len(d.AddedNodes)+len(d.RemovedNodes)+len(d.AddedEdges)+len(d.RemovedEdges) == 0
+}
+
+// Print writes the diff to w, additions in green and removals in red.
+func (d Diff) Print(w io.Writer) {
+	nodes := func(title, sign string, color utils.Color, ids []string) {
+		if len(ids) == 0 {
+			return
+		}
+		utils.Must(fmt.Fprintf(w, "\n%s\n", color.Color(title)))
+		for _, id := range ids {
+			utils.Must(fmt.Fprintf(w, " %s %s\n", color.Color(sign), id))
+		}
+	}
+	edges := func(title, sign string, color utils.Color, edges []Edge) {
+		if len(edges) == 0 {
+			return
+		}
+		utils.Must(fmt.Fprintf(w, "\n%s\n", color.Color(title)))
+		for _, e := range edges {
+			utils.Must(fmt.Fprintf(w, " %s %s%s%s\n", color.Color(sign), e.Source, utils.Arrow, e.Target))
+		}
+	}
+
+	nodes("New principals:", "+", utils.Green, d.AddedNodes)
+	nodes("Removed principals:", "-", utils.Red, d.RemovedNodes)
+	edges("Access gained:", "+", utils.Green, d.AddedEdges)
+	edges("Access lost:", "-", utils.Red, d.RemovedEdges)
+
+	utils.Must(fmt.Fprintf(w, "\n%d new principals, %d removed principals, %d edges gained, %d edges lost\n",
+		len(d.AddedNodes), len(d.RemovedNodes), len(d.AddedEdges), len(d.RemovedEdges)))
+}
+
+func contents[T graph.Value](g *graph.Graph[T]) (map[string]bool, map[Edge]bool) {
+	nodes := map[string]bool{}
+	edges := map[Edge]bool{}
+	for id, n := range g.Nodes() {
+		nodes[id] = true
+		for target := range n.Outbound() {
+			edges[Edge{Source: id, Target: target}] = true
+		}
+	}
+	return nodes, edges
+}
+
+// missing returns the keys of a that aren't in b.
+func missing[K comparable](a, b map[K]bool) []K {
+	var resp []K
+	for k := range a {
+		if !b[k] {
+			resp = append(resp, k)
+		}
+	}
+	return resp
+}
+
+func sortEdges(edges []Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Source != edges[j].Source {
+			return edges[i].Source < edges[j].Source
+		}
+		return edges[i].Target < edges[j].Target
+	})
+}
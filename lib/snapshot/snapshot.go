@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the format of the snapshot names, these sort in the order the scans finished. Nanoseconds are included
+// so scans that finish in the same second don't overwrite each other.
+const TimeFormat = "20060102T150405.000000000Z"
+
+// Dir returns the directory the snapshots of a named environment are stored in.
+func Dir(programDir string) string {
+	return filepath.Join(programDir, "snapshots")
+}
+
+// Save writes the graph to a new snapshot named after the time it was taken and returns the path. Unlike nodes.json
+// the snapshots are never overwritten so scans can be compared later.
+func Save[T graph.Value](programDir string, g *graph.Graph[T], at time.Time) (string, error) {
+	if err := os.MkdirAll(Dir(programDir), 0750); err != nil {
+		return "", fmt.Errorf("Save(): %w", err)
+	}
+
+	path := filepath.Join(Dir(programDir), at.UTC().Format(TimeFormat)+".json")
+	if err := g.Save(path); err != nil {
+		return "", fmt.Errorf("Save(): %w", err)
+	}
+	return path, nil
+}
+
+// List returns the names of the saved snapshots, oldest first.
+func List(programDir string) ([]string, error) {
+	entries, err := os.ReadDir(Dir(programDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("List(): %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(e.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Resolve returns the path of the snapshot ref refers to. This can be the name of a snapshot, a unique prefix of one,
+// like the date, or the path to a saved graph.
+func Resolve(programDir, ref string) (string, error) {
+	names, err := List(programDir)
+	if err != nil {
+		return "", fmt.Errorf("Resolve(): %w", err)
+	}
+
+	var matches []string
+	for _, name := range names {
+		if name == ref {
+			return filepath.Join(Dir(programDir), name+".json"), nil
+		} else if strings.HasPrefix(name, ref) {
+			matches = append(matches, name)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		if _, err := os.Stat(ref); err == nil {
+			return ref, nil
+		}
+		return "", fmt.Errorf("Resolve(): snapshot %s not found", ref)
+	case 1:
+		return filepath.Join(Dir(programDir), matches[0]+".json"), nil
+	default:
+		return "", fmt.Errorf("Resolve(): %s is ambiguous, matches: %s", ref, strings.Join(matches, ", "))
+	}
+}
@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"bytes"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// S is a Value identified by the string itself.
+type S string
+
+func (s S) Id() string                   { return string(s) }
+func (s S) SetGraph(_ interface{})       {}
+func (s S) MarshalJSON() ([]byte, error) { return []byte(`"` + s + `"`), nil }
+
+const (
+	user  = "arn:aws:iam::111111111111:user/u"
+	a     = "arn:aws:iam::111111111111:role/a"
+	b     = "arn:aws:iam::222222222222:role/b"
+	c     = "arn:aws:iam::333333333333:role/c"
+	other = "arn:aws:iam::333333333333:role/other"
+)
+
+func NewTestGraph(nodes []S, edges [][2]S) *graph.Graph[S] {
+	g := graph.NewDirectedGraph[S]()
+	for _, n := range nodes {
+		g.AddNode(n)
+	}
+	for _, e := range edges {
+		g.AddEdge(e[0], e[1])
+	}
+	return g
+}
+
+func TestCompare(t *testing.T) {
+	old := NewTestGraph([]S{user, a, b, other}, [][2]S{{user, a}, {a, b}, {user, other}})
+	new := NewTestGraph([]S{user, a, b, c}, [][2]S{{user, a}, {a, c}, {b, c}})
+
+	want := Diff{
+		AddedNodes:   []string{c},
+		RemovedNodes: []string{other},
+		AddedEdges:   []Edge{{Source: a, Target: c}, {Source: b, Target: c}},
+		RemovedEdges: []Edge{{Source: a, Target: b}, {Source: user, Target: other}},
+	}
+	d := Compare(old, new)
+	if diff := cmp.Diff(d, want); diff != "" {
+		t.Errorf("Compare() (-got +want):\n%s", diff)
+	}
+
+	wantFiltered := Diff{
+		AddedEdges:   []Edge{{Source: b, Target: c}},
+		RemovedEdges: []Edge{{Source: a, Target: b}},
+	}
+	if diff := cmp.Diff(d.Filter("222222222222"), wantFiltered); diff != "" {
+		t.Errorf("Filter() (-got +want):\n%s", diff)
+	}
+
+	if !Compare(old, old).Empty() {
+		t.Error("expected comparing a graph to itself to be empty")
+	}
+
+	var buf bytes.Buffer
+	d.Print(&buf)
+	if !strings.Contains(buf.String(), "1 new principals, 1 removed principals, 2 edges gained, 2 edges lost") {
+		t.Errorf("unexpected Print() output:\n%s", buf.String())
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	if names, err := List(dir); err != nil || names != nil {
+		t.Fatalf("List() with no snapshots: got %v, %v", names, err)
+	}
+
+	g := NewTestGraph([]S{user}, nil)
+	for _, at := range []time.Time{
+		time.Date(2022, 2, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2022, 1, 31, 9, 30, 0, 0, time.UTC),
+		time.Date(2022, 2, 1, 11, 0, 0, 0, time.UTC),
+		time.Date(2022, 2, 1, 11, 0, 0, 5e8, time.UTC),
+	} {
+		if _, err := Save(dir, g, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(names, []string{
+		"20220131T093000.000000000Z", "20220201T100000.000000000Z", "20220201T110000.000000000Z", "20220201T110000.500000000Z",
+	}); diff != "" {
+		t.Errorf("List() (-got +want):\n%s", diff)
+	}
+
+	if got, err := Resolve(dir, "20220131"); err != nil || got != filepath.Join(Dir(dir), "20220131T093000.000000000Z.json") {
+		t.Errorf("Resolve(20220131): got %s, %v", got, err)
+	}
+	if _, err := Resolve(dir, "20220201"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("Resolve(20220201): expected an ambiguous error, got %v", err)
+	}
+	if _, err := Resolve(dir, "2021"); err == nil {
+		t.Error("Resolve(2021): expected an error")
+	}
+
+	path := filepath.Join(dir, "nodes.json")
+	if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Resolve(dir, path); err != nil || got != path {
+		t.Errorf("Resolve(%s): got %s, %v", path, got, err)
+	}
+}
@@ -0,0 +1,152 @@
+package trail
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Source is a location CloudTrail log files can be read from.
+type Source interface {
+	// Walk calls fn with each log file in the source that may have events in the time range.
+	Walk(ctx context.Context, r TimeRange, fn func(name string, body io.Reader) error) error
+}
+
+// TimeRange limits the events that are read, a zero Start or End leaves that side open.
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains returns true if t is in the range.
+func (r TimeRange) Contains(t time.Time) bool {
+	return (r.Start.IsZero() || !t.Before(r.Start)) && (r.End.IsZero() || t.Before(r.End))
+}
+
+// datePathRe matches the date in the keys CloudTrail delivers logs to:
+//
+//	AWSLogs/<account>/CloudTrail/<region>/<yyyy>/<mm>/<dd>/<file>.json.gz
+var datePathRe = regexp.MustCompile(`/([0-9]{4})/([0-9]{2})/([0-9]{2})/[^/]*$`)
+
+// mayContain returns false if the date in the path of a log file is outside of r. Files are delivered within about 15
+// minutes, so a day of slack is allowed on each side. Files without a date in their path are always read.
+func (r TimeRange) mayContain(path string) bool {
+	m := datePathRe.FindStringSubmatch(filepath.ToSlash(path))
+	if m == nil {
+		return true
+	}
+	day, err := time.Parse("2006-01-02", fmt.Sprintf("%s-%s-%s", m[1], m[2], m[3]))
+	if err != nil {
+		return true
+	}
+	return (r.Start.IsZero() || !day.Before(r.Start.AddDate(0, 0, -1))) && (r.End.IsZero() || day.Before(r.End.AddDate(0, 0, 1)))
+}
+
+func isLogFile(name string) bool {
+	return strings.HasSuffix(name, ".json.gz") || strings.HasSuffix(name, ".json")
+}
+
+// NewSource returns a source for location, which is either s3://<bucket>/<prefix> or a local directory.
+func NewSource(location string, cfg aws.Config) (Source, error) {
+	if strings.HasPrefix(location, "s3://") {
+		p := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+		if p[0] == "" {
+			return nil, fmt.Errorf("NewSource(): no bucket in %s", location)
+		}
+		src := &S3Source{Client: s3.NewFromConfig(cfg), Bucket: p[0]}
+		if len(p) == 2 {
+			src.Prefix = p[1]
+		}
+		return src, nil
+	}
+
+	if info, err := os.Stat(location); err != nil {
+		return nil, fmt.Errorf("NewSource(): %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("NewSource(): %s is not a directory", location)
+	}
+	return DirSource{Path: location}, nil
+}
+
+// DirSource reads logs from a local directory, like one synced from the trail's bucket.
+type DirSource struct {
+	Path string
+}
+
+func (d DirSource) Walk(ctx context.Context, r TimeRange, fn func(name string, body io.Reader) error) error {
+	err := filepath.WalkDir(d.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		} else if entry.IsDir() || !isLogFile(path) || !r.mayContain(path) {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fn(path, f)
+	})
+	if err != nil {
+		return fmt.Errorf("Walk(): %w", err)
+	}
+	return nil
+}
+
+// S3API is the part of the S3 client used by S3Source.
+type S3API interface {
+	s3.ListObjectsV2APIClient
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// S3Source reads logs from the bucket a trail delivers to.
+type S3Source struct {
+	Client S3API
+	Bucket string
+	Prefix string
+}
+
+func (s *S3Source) Walk(ctx context.Context, r TimeRange, fn func(name string, body io.Reader) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(s.Prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("Walk(): %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if !isLogFile(key) || !r.mayContain(key) {
+				continue
+			}
+			if err := s.read(ctx, key, fn); err != nil {
+				return fmt.Errorf("Walk(): %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *S3Source) read(ctx context.Context, key string, fn func(name string, body io.Reader) error) error {
+	resp, err := s.Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("s3://%s/%s: %w", s.Bucket, key, err)
+	}
+	defer resp.Body.Close()
+	return fn(fmt.Sprintf("s3://%s/%s", s.Bucket, key), resp.Body)
+}
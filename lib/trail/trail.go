@@ -0,0 +1,177 @@
+// Package trail reads sts:AssumeRole events from CloudTrail log files, as delivered to S3 by a trail.
+package trail
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Event is an sts:AssumeRole call found in the logs.
+type Event struct {
+	// Id is the CloudTrail event ID, it's unique for each event.
+	Id     string
+	Time   time.Time
+	Region string
+
+	// Source is the ARN of the principal that made the call, assumed role sessions are converted to the role ARN. For
+	// calls made by AWS services this is the service principal, and for calls from another account it's the account's
+	// root ARN since CloudTrail doesn't include the caller's ARN in the target account's logs.
+	Source string
+
+	// Target is the ARN of the role that was assumed.
+	Target string
+
+	// ExternalId and SourceIdentity are the sts:ExternalId and sts:SourceIdentity passed to the call, if any.
+	ExternalId     string
+	SourceIdentity string
+
+	// ErrorCode is set if the call failed.
+	ErrorCode string
+}
+
+// record is the part of a CloudTrail record that is needed to build an Event.
+type record struct {
+	EventId      string    `json:"eventID"`
+	EventTime    time.Time `json:"eventTime"`
+	EventSource  string    `json:"eventSource"`
+	EventName    string    `json:"eventName"`
+	AwsRegion    string    `json:"awsRegion"`
+	ErrorCode    string    `json:"errorCode"`
+	UserIdentity struct {
+		Type      string `json:"type"`
+		Arn       string `json:"arn"`
+		AccountId string `json:"accountId"`
+		InvokedBy string `json:"invokedBy"`
+	} `json:"userIdentity"`
+	RequestParameters struct {
+		RoleArn        string `json:"roleArn"`
+		ExternalId     string `json:"externalId"`
+		SourceIdentity string `json:"sourceIdentity"`
+	} `json:"requestParameters"`
+}
+
+// event converts the record to an Event, false is returned if it isn't an sts:AssumeRole call.
+func (r record) event() (Event, bool) {
+	if r.EventSource != "sts.amazonaws.com" || r.EventName != "AssumeRole" || r.RequestParameters.RoleArn == "" {
+		return Event{}, false
+	}
+
+	source := PrincipalArn(r.UserIdentity.Arn)
+	if source == "" && r.UserIdentity.InvokedBy != "" {
+		source = r.UserIdentity.InvokedBy
+	} else if source == "" && r.UserIdentity.AccountId != "" {
+		source = fmt.Sprintf("arn:%s:iam::%s:root", partition(r.RequestParameters.RoleArn), r.UserIdentity.AccountId)
+	}
+
+	return Event{
+		Id:             r.EventId,
+		Time:           r.EventTime,
+		Region:         r.AwsRegion,
+		Source:         source,
+		Target:         r.RequestParameters.RoleArn,
+		ExternalId:     r.RequestParameters.ExternalId,
+		SourceIdentity: r.RequestParameters.SourceIdentity,
+		ErrorCode:      r.ErrorCode,
+	}, true
+}
+
+// ParseEvent parses a single CloudTrail record, like the CloudTrailEvent returned by cloudtrail:LookupEvents. False is
+// returned if the record isn't an sts:AssumeRole call.
+func ParseEvent(b []byte) (Event, bool, error) {
+	var rec record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return Event{}, false, fmt.Errorf("ParseEvent(): %w", err)
+	}
+	e, ok := rec.event()
+	return e, ok, nil
+}
+
+var assumedRoleRe = regexp.MustCompile(`^arn:(aws[a-z-]*):sts::([0-9]{12}):assumed-role/(.+)/[^/]+$`)
+
+// PrincipalArn returns the IAM ARN of the principal, for assumed role sessions this is the role ARN. Roles with a
+// path can't be converted since the path isn't part of the session ARN, the role name is used on its own in this case.
+func PrincipalArn(arn string) string {
+	m := assumedRoleRe.FindStringSubmatch(arn)
+	if m == nil {
+		return arn
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", m[1], m[2], m[3])
+}
+
+// partition returns the partition of the ARN, aws if it can't be parsed.
+func partition(arn string) string {
+	if p := strings.Split(arn, ":"); len(p) > 1 && p[1] != "" {
+		return p[1]
+	}
+	return "aws"
+}
+
+// Parse streams the records in a CloudTrail log file and calls fn for each sts:AssumeRole event. Files may be gzipped,
+// records are decoded one at a time so large files aren't loaded into memory.
+func Parse(r io.Reader, fn func(Event)) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("Parse(): %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return fmt.Errorf("Parse(): %w", err)
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("Parse(): %w", err)
+		}
+		if key != "Records" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return fmt.Errorf("Parse(): %w", err)
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return fmt.Errorf("Parse(): %w", err)
+		}
+		for dec.More() {
+			var rec record
+			if err := dec.Decode(&rec); err != nil {
+				return fmt.Errorf("Parse(): %w", err)
+			}
+			if e, ok := rec.event(); ok {
+				fn(e)
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return fmt.Errorf("Parse(): %w", err)
+		}
+	}
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != want {
+		return fmt.Errorf("expected %s, got %v", want, t)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package trail
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+const logFile = `{"Records": [
+	{
+		"eventID": "event-1",
+		"eventTime": "2021-03-04T05:06:07Z",
+		"eventSource": "sts.amazonaws.com",
+		"eventName": "AssumeRole",
+		"awsRegion": "eu-west-1",
+		"userIdentity": {"type": "AssumedRole", "arn": "arn:aws:sts::111111111111:assumed-role/ci/session-1"},
+		"requestParameters": {
+			"roleArn": "arn:aws:iam::222222222222:role/deploy",
+			"roleSessionName": "deploy",
+			"externalId": "ext-1",
+			"sourceIdentity": "alice"
+		}
+	},
+	{
+		"eventTime": "2021-03-04T05:07:00Z",
+		"eventSource": "s3.amazonaws.com",
+		"eventName": "GetObject",
+		"requestParameters": {"bucketName": "test"}
+	},
+	{
+		"eventTime": "2021-03-04T05:08:00Z",
+		"eventSource": "sts.amazonaws.com",
+		"eventName": "AssumeRole",
+		"awsRegion": "us-east-1",
+		"errorCode": "AccessDenied",
+		"userIdentity": {"type": "IAMUser", "arn": "arn:aws:iam::111111111111:user/alice"},
+		"requestParameters": null
+	},
+	{
+		"eventTime": "2021-03-04T05:09:00Z",
+		"eventSource": "sts.amazonaws.com",
+		"eventName": "AssumeRole",
+		"awsRegion": "us-east-1",
+		"errorCode": "AccessDenied",
+		"userIdentity": {"type": "IAMUser", "arn": "arn:aws:iam::111111111111:user/alice"},
+		"requestParameters": {"roleArn": "arn:aws:iam::222222222222:role/admin"}
+	},
+	{
+		"eventID": "event-2",
+		"eventTime": "2021-03-04T05:10:00Z",
+		"eventSource": "sts.amazonaws.com",
+		"eventName": "AssumeRole",
+		"awsRegion": "us-east-1",
+		"userIdentity": {"type": "AWSAccount", "principalId": "AIDAEXAMPLE", "accountId": "333333333333"},
+		"requestParameters": {"roleArn": "arn:aws:iam::222222222222:role/vendor"}
+	},
+	{
+		"eventID": "event-3",
+		"eventTime": "2021-03-04T05:11:00Z",
+		"eventSource": "sts.amazonaws.com",
+		"eventName": "AssumeRole",
+		"awsRegion": "us-east-1",
+		"userIdentity": {"type": "AWSService", "invokedBy": "ec2.amazonaws.com"},
+		"requestParameters": {"roleArn": "arn:aws:iam::222222222222:role/instance"}
+	}
+]}`
+
+var wantEvents = []Event{
+	{
+		Id:             "event-1",
+		Time:           time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Region:         "eu-west-1",
+		Source:         "arn:aws:iam::111111111111:role/ci",
+		Target:         "arn:aws:iam::222222222222:role/deploy",
+		ExternalId:     "ext-1",
+		SourceIdentity: "alice",
+	},
+	{
+		Time:      time.Date(2021, 3, 4, 5, 9, 0, 0, time.UTC),
+		Region:    "us-east-1",
+		Source:    "arn:aws:iam::111111111111:user/alice",
+		Target:    "arn:aws:iam::222222222222:role/admin",
+		ErrorCode: "AccessDenied",
+	},
+	{
+		Id:     "event-2",
+		Time:   time.Date(2021, 3, 4, 5, 10, 0, 0, time.UTC),
+		Region: "us-east-1",
+		Source: "arn:aws:iam::333333333333:root",
+		Target: "arn:aws:iam::222222222222:role/vendor",
+	},
+	{
+		Id:     "event-3",
+		Time:   time.Date(2021, 3, 4, 5, 11, 0, 0, time.UTC),
+		Region: "us-east-1",
+		Source: "ec2.amazonaws.com",
+		Target: "arn:aws:iam::222222222222:role/instance",
+	},
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	for name, body := range map[string][]byte{
+		"plain":   []byte(logFile),
+		"gzipped": gzipped(t, logFile),
+	} {
+		var got []Event
+		if err := Parse(bytes.NewReader(body), func(e Event) { got = append(got, e) }); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if diff := cmp.Diff(got, wantEvents); diff != "" {
+			t.Errorf("%s: Parse() (-got +want):\n%s", name, diff)
+		}
+	}
+
+	if err := Parse(bytes.NewReader([]byte(`{"Records": [{"eventName": `)), func(Event) {}); err == nil {
+		t.Error("expected an error for a truncated file")
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	got, ok, err := ParseEvent([]byte(`{
+		"eventID": "event-1",
+		"eventTime": "2021-03-04T05:06:07Z",
+		"eventSource": "sts.amazonaws.com",
+		"eventName": "AssumeRole",
+		"userIdentity": {"arn": "arn:aws:iam::111111111111:user/alice"},
+		"requestParameters": {"roleArn": "arn:aws:iam::222222222222:role/deploy"}
+	}`))
+	if err != nil || !ok {
+		t.Fatalf("ParseEvent(): %t, %v", ok, err)
+	}
+	want := Event{
+		Id:     "event-1",
+		Time:   time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Source: "arn:aws:iam::111111111111:user/alice",
+		Target: "arn:aws:iam::222222222222:role/deploy",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ParseEvent() (-got +want):\n%s", diff)
+	}
+
+	if _, ok, err := ParseEvent([]byte(`{"eventSource": "sts.amazonaws.com", "eventName": "GetCallerIdentity"}`)); err != nil || ok {
+		t.Errorf("expected GetCallerIdentity to be skipped: %t, %v", ok, err)
+	}
+}
+
+func TestPrincipalArn(t *testing.T) {
+	for in, want := range map[string]string{
+		"arn:aws:sts::111111111111:assumed-role/ci/session":  "arn:aws:iam::111111111111:role/ci",
+		"arn:aws-us-gov:sts::111111111111:assumed-role/ci/s": "arn:aws-us-gov:iam::111111111111:role/ci",
+		"arn:aws:iam::111111111111:user/alice":               "arn:aws:iam::111111111111:user/alice",
+	} {
+		if got := PrincipalArn(in); got != want {
+			t.Errorf("PrincipalArn(%s): got %s, want %s", in, got, want)
+		}
+	}
+}
+
+const logKey = "AWSLogs/111111111111/CloudTrail/%s/%s/111111111111_CloudTrail_%s_file.json.gz"
+
+func TestDirSource(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{
+		fmt.Sprintf(logKey, "eu-west-1", "2021/03/04", "eu-west-1"),
+		fmt.Sprintf(logKey, "us-east-1", "2021/03/04", "us-east-1"),
+		fmt.Sprintf(logKey, "us-east-1", "2020/01/01", "us-east-1"),
+		"AWSLogs/111111111111/CloudTrail-Digest/us-east-1/2021/03/04/digest.txt",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, gzipped(t, logFile), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var files []string
+	r := TimeRange{Start: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	err := DirSource{Path: dir}.Walk(context.Background(), r, func(name string, body io.Reader) error {
+		files = append(files, filepath.ToSlash(name[len(dir)+1:]))
+		return Parse(body, func(Event) {})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(files)
+	want := []string{
+		fmt.Sprintf(logKey, "eu-west-1", "2021/03/04", "eu-west-1"),
+		fmt.Sprintf(logKey, "us-east-1", "2021/03/04", "us-east-1"),
+	}
+	if diff := cmp.Diff(files, want); diff != "" {
+		t.Errorf("Walk() files (-got +want):\n%s", diff)
+	}
+}
+
+// MockS3 serves objects from a map and returns one object per page.
+type MockS3 struct {
+	Objects map[string][]byte
+}
+
+func (m MockS3) ListObjectsV2(_ context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	var keys []string
+	for k := range m.Objects {
+		if k > aws.ToString(in.ContinuationToken) && len(k) >= len(aws.ToString(in.Prefix)) && k[:len(aws.ToString(in.Prefix))] == aws.ToString(in.Prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		return &s3.ListObjectsV2Output{}, nil
+	}
+	return &s3.ListObjectsV2Output{
+		Contents:              []s3Types.Object{{Key: aws.String(keys[0])}},
+		IsTruncated:           len(keys) > 1,
+		NextContinuationToken: aws.String(keys[0]),
+	}, nil
+}
+
+func (m MockS3) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(m.Objects[aws.ToString(in.Key)]))}, nil
+}
+
+func TestS3Source(t *testing.T) {
+	client := MockS3{Objects: map[string][]byte{
+		"trail/" + fmt.Sprintf(logKey, "eu-west-1", "2021/03/04", "eu-west-1"): gzipped(t, logFile),
+		"trail/" + fmt.Sprintf(logKey, "us-east-1", "2021/03/05", "us-east-1"): gzipped(t, logFile),
+		"other/" + fmt.Sprintf(logKey, "us-east-1", "2021/03/04", "us-east-1"): gzipped(t, logFile),
+	}}
+
+	var got []Event
+	src := &S3Source{Client: client, Bucket: "bucket", Prefix: "trail/"}
+	r := TimeRange{End: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)}
+	err := src.Walk(context.Background(), r, func(name string, body io.Reader) error {
+		return Parse(body, func(e Event) { got = append(got, e) })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file from the 5th is skipped since it's more than a day after the end, the one from the 4th is still read.
+	if len(got) != len(wantEvents) {
+		t.Errorf("got %d events, want %d", len(got), len(wantEvents))
+	}
+}
+
+func TestTimeRange_Contains(t *testing.T) {
+	r := TimeRange{Start: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)}
+	for _, tt := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{r.Start, true},
+		{r.Start.Add(time.Hour), true},
+		{r.End, false},
+		{r.Start.Add(-time.Second), false},
+	} {
+		if got := r.Contains(tt.t); got != tt.want {
+			t.Errorf("Contains(%s): got %t, want %t", tt.t, got, tt.want)
+		}
+	}
+}
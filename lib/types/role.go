@@ -1,12 +1,11 @@
 package types
 
 import (
-	"encoding/json"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/policy"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
 	"log"
-	"net/url"
-	"strings"
 )
 
 func NewRole(arn string) Role {
@@ -26,80 +25,42 @@ func (r Role) Id() string {
 	return *r.Arn
 }
 
-// AssumeRolePolicyDocument may look like this:
-//
-// TODO: Check for other variations of this.
-//
-//	{
-//	    "Version": "2012-10-17",
-//	    "Statement": [
-//	        {
-//	            "Effect": "Allow",
-//	            "Principal": {
-//	                "AWS": "arn:aws:iam::336983520827:root"
-//	            },
-//	            "Action": "sts:AssumeRole",
-//	            "Condition": {}
-//	        }
-//	    ]
-//	}
-type AssumeRolePolicyDocument struct {
-	Statement []struct {
-		Principal struct {
-			// AWS can be a string or a list, RawMessage will leave it as []byte.
-			AWS json.RawMessage
-		}
-		Condition *struct {
-			StringEqual *struct {
-				ExternalId *string `json:"sts:ExternalId"`
-			}
-		}
+// TrustPolicy parses the role's AssumeRolePolicyDocument, nil is returned for roles discovered outside of
+// iam:ListRoles since we don't know their trust policy.
+func (r *Role) TrustPolicy() (*policy.Document, error) {
+	if r.AssumeRolePolicyDocument == nil {
+		return nil, nil
 	}
+
+	doc, err := policy.Parse(*r.AssumeRolePolicyDocument)
+	if err != nil {
+		return nil, fmt.Errorf("TrustPolicy(): %s: %w", *r.Arn, err)
+	}
+	return doc, nil
 }
 
+// ExternalID returns the first external ID candidate for the role, or nil if it doesn't appear to need one.
 func (r *Role) ExternalID() *string {
-	// Return the manually set ExternalID if it exists.
-	if r.externalID != nil {
-		return r.externalID
+	if ids := r.ExternalIDs(); len(ids) != 0 {
+		return aws.String(ids[0])
 	}
-
-	return findExternalId(*r.AssumeRolePolicyDocument, *r.Arn)
+	return nil
 }
 
-// TODO: Add caching
-func findExternalId(doc string, arn string) *string {
-	decoded, err := url.QueryUnescape(doc)
-	if err != nil {
-		log.Printf("error url decoding role trust policy: %s\n", err)
-		return nil
+// ExternalIDs returns every sts:ExternalId referenced in the role's trust policy. We don't know which statement
+// applies to the principal assuming the role, so these are all candidates to try.
+func (r *Role) ExternalIDs() []string {
+	// Return the manually set ExternalID if it exists.
+	if r.externalID != nil {
+		return []string{*r.externalID}
 	}
 
-	var trust AssumeRolePolicyDocument
-	err = json.Unmarshal([]byte(decoded), &trust)
+	doc, err := r.TrustPolicy()
 	if err != nil {
 		log.Printf("error parsing role trust policy: %s\n", err)
-		// TODO: This is probably error prone, need testing
+		return nil
+	} else if doc == nil {
 		return nil
 	}
-
-	// Multiple statements can exist, not all of them are relevant.
-	for _, stmt := range trust.Statement {
-
-		// Does this statement apply to us?
-		if strings.Contains(string(stmt.Principal.AWS), arn) {
-
-			// TODO: This may not catch all cases.
-			// Check if Condition.StringEquals.ExternalId is missing.
-			if stmt.Condition == nil || stmt.Condition.StringEqual == nil || stmt.Condition.StringEqual.ExternalId == nil {
-				// No external ID is needed
-				return nil
-			}
-
-			// Return the externalId found in the trust policy.
-			return stmt.Condition.StringEqual.ExternalId
-		}
-	}
-
-	// Assume no externalId
-	return aws.String("")
+	return doc.ExternalIDs()
 }
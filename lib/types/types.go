@@ -1,8 +1,11 @@
 package types
 
 import (
+	"github.com/RyanJarv/liquidswards/lib/checkpoint"
 	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/engine"
 	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/scheduler"
 	"github.com/RyanJarv/liquidswards/lib/utils"
 	"github.com/aws/aws-sdk-go-v2/aws"
 )
@@ -13,6 +16,7 @@ type Plugin interface {
 	Enabled() (enabled bool, reason string)
 }
 
+// Waitable plugins keep running after discovery has converged, like Refresh, the scan waits for them before exiting.
 type Waitable interface {
 	Wait()
 }
@@ -22,10 +26,20 @@ type GlobalPluginArgs struct {
 	FoundRoles       *utils.Iterator[Role]
 	Access           *utils.Iterator[*creds.Config]
 	Graph            *graph.Graph[*creds.Config]
-	Scope            []string
+	Scope            *utils.Scope
 	PrimaryAwsConfig aws.Config
 	ProgramDir       string
 	AwsConfigs       []*creds.Config
+
+	// Checkpoint records scan progress so it can be resumed later, it may be nil.
+	Checkpoint *checkpoint.Checkpoint
+
+	// Engine runs the sts:AssumeRole tests, if it is nil they're run inline.
+	Engine *engine.Engine
+
+	// Scheduler refreshes the credentials of accessed principals, plugins use it to refresh them early or stop
+	// refreshing them.
+	Scheduler *scheduler.Scheduler
 }
 
 type NewPluginFunc func(utils.Context, GlobalPluginArgs) Plugin
@@ -14,6 +14,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
@@ -25,10 +26,11 @@ var Arrow = fmt.Sprintf(" %s ", Cyan.Color(" --assumes--> "))
 const (
 	colorScheme = "pastel28"
 
-	Red   Color = "\033[31m"
-	Green Color = "\033[32m"
-	Cyan  Color = "\033[36m"
-	Gray  Color = "\033[37m"
+	Red    Color = "\033[31m"
+	Green  Color = "\033[32m"
+	Yellow Color = "\033[33m"
+	Cyan   Color = "\033[36m"
+	Gray   Color = "\033[37m"
 
 	ErrorLogLevel LogLevel = iota
 	InfoLogLevel
@@ -124,19 +126,48 @@ func ColorFromArn() colorFromArn {
 	return colorFromArn{}
 }
 
+// ColorFromArns returns colors with the accounts of arns numbered in the given order, accounts seen later are numbered
+// after them.
+func ColorFromArns(arns []string) Colors {
+	c := colorFromArn{}
+	for _, arn := range arns {
+		c.index(arn)
+	}
+	return c
+}
+
+// Colors assigns a color to each account, see ColorFromArn.
+type Colors = colorFromArn
+
 type colorFromArn []*string
 
+// colorSchemeHex is the colorScheme brewer palette in hex for outputs other than Graphviz.
+var colorSchemeHex = []string{"#b3e2cd", "#fdcdac", "#cbd5e8", "#f4cae4", "#e6f5c9", "#fff2ae", "#f1e2cc", "#cccccc"}
+
+// Get returns the Graphviz color for the account of arn, each new account gets the next color in the scheme.
 func (c *colorFromArn) Get(arn string) string {
-	accountId := strings.Split(arn, ":")[4]
+	return "/" + colorScheme + "/" + strconv.Itoa(c.index(arn))
+}
+
+// Hex returns the same color as Get as a hex string.
+func (c *colorFromArn) Hex(arn string) string {
+	return colorSchemeHex[(c.index(arn)-1)%len(colorSchemeHex)]
+}
+
+// index returns the one based index of the account of arn in the color scheme. Values that aren't ARNs, like service
+// principals, share the color of the empty account.
+func (c *colorFromArn) index(arn string) int {
+	var accountId string
+	if p := strings.Split(arn, ":"); len(p) > 4 {
+		accountId = p[4]
+	}
 	for i, prev := range *c {
 		if *prev == accountId {
-			resp := "/" + colorScheme + "/" + strconv.Itoa(i+1)
-			return resp
+			return i + 1
 		}
 	}
 	*c = append(*c, &accountId)
-	resp := "/" + colorScheme + "/" + strconv.Itoa(len(*c))
-	return resp
+	return len(*c)
 }
 
 func In[T comparable](haystack []T, needle T) bool {
@@ -231,10 +262,11 @@ func SplitCommas(s string) []string {
 }
 
 func FilterDuplicates[T comparable](slice []T) []T {
-	var found map[T]bool
+	found := map[T]bool{}
 	var resp []T
 	for _, v := range slice {
-		if _, ok := found[v]; !ok {
+		if !found[v] {
+			found[v] = true
 			resp = append(resp, v)
 		}
 	}
@@ -261,12 +293,31 @@ func AccountIdFromArn(arn string) (string, error) {
 	return p[4], nil
 }
 
-func ArnInScope(scope []string, arn string) bool {
-	accountId := Must(AccountIdFromArn(arn))
-	if In(scope, accountId) {
-		return true
+// PartitionFromArn returns the partition of arn, aws is returned if it can't be parsed.
+func PartitionFromArn(arn string) string {
+	if p := strings.Split(arn, ":"); len(p) == 6 && p[1] != "" {
+		return p[1]
 	}
-	return false
+	return "aws"
+}
+
+// WildcardMatch returns true if value matches pattern, where * in pattern matches any number of characters
+// (including none) and ? matches a single character. Matching is case-insensitive like it is for IAM actions.
+func WildcardMatch(pattern, value string) bool {
+	return wildcardRegexp(pattern, "(?is)").MatchString(value)
+}
+
+// WildcardMatchCase is the case-sensitive version of WildcardMatch, used for the StringLike and ArnLike condition
+// operators.
+func WildcardMatchCase(pattern, value string) bool {
+	return wildcardRegexp(pattern, "(?s)").MatchString(value)
+}
+
+func wildcardRegexp(pattern, flags string) *regexp.Regexp {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	re = strings.ReplaceAll(re, `\?`, ".")
+	return regexp.MustCompile(flags + "^" + re + "$")
 }
 
 func ExpandPath(path string) (string, error) {
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+)
+
+// NewScope returns a Scope containing accounts.
+func NewScope(accounts []string) *Scope {
+	s := &Scope{m: &sync.RWMutex{}, accounts: map[string]bool{}}
+	for _, a := range accounts {
+		s.accounts[a] = true
+	}
+	return s
+}
+
+// Scope is the set of account IDs that are in scope. Accounts may be added while the scan is running, for example when
+// the accounts in an organization are discovered. A nil Scope means scope is disabled and every account is in scope.
+type Scope struct {
+	m        *sync.RWMutex
+	accounts map[string]bool
+}
+
+// Contains returns true if the account of arn is in scope. Values that aren't ARNs, like service principals, are never
+// in scope unless scope is disabled.
+func (s *Scope) Contains(arn string) bool {
+	if s == nil {
+		return true
+	}
+
+	accountId, err := AccountIdFromArn(arn)
+	if err != nil {
+		return false
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.accounts[accountId]
+}
+
+// Add adds the account to the scope, false is returned if it was already in scope or scope is disabled.
+func (s *Scope) Add(accountId string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.accounts[accountId] {
+		return false
+	}
+	s.accounts[accountId] = true
+	return true
+}
+
+// Accounts returns the accounts in scope sorted by ID.
+func (s *Scope) Accounts() []string {
+	if s == nil {
+		return nil
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+	accounts := Keys(s.accounts)
+	sort.Strings(accounts)
+	return accounts
+}
@@ -2,21 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/checkpoint"
 	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/engine"
+	"github.com/RyanJarv/liquidswards/lib/export"
 	"github.com/RyanJarv/liquidswards/lib/graph"
 	"github.com/RyanJarv/liquidswards/lib/plugins"
+	"github.com/RyanJarv/liquidswards/lib/predict"
+	"github.com/RyanJarv/liquidswards/lib/secret"
+	"github.com/RyanJarv/liquidswards/lib/snapshot"
 	"github.com/RyanJarv/liquidswards/lib/types"
 	"github.com/RyanJarv/liquidswards/lib/utils"
-	"github.com/alitto/pond"
+	"golang.org/x/term"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 const MaxWorkers = 100
-const MaxCapacity = 1000
 
 var (
 	ctx = utils.NewContext(context.Background())
@@ -24,7 +32,7 @@ var (
 	region   = flag.String("region", "us-east-1", "The AWS Region to use")
 	scopeStr = flag.String("scope", "", `
 List of AWS account ID's (seperated by comma's) that are in scope. Accounts associated with any profiles used are 
-always in scope regardless of this value.
+always in scope regardless of this value, accounts discovered with -org are added to it during the scan.
 `)
 	noScope = flag.Bool("no-scope", false, `
 Disable scope, all discovered role ARN's belonging to ANY account will be enumerated for access and additional file 
@@ -35,6 +43,31 @@ recursively.
 	noSave      = flag.Bool("no-save", false, "Do not save scan results to disk.")
 	load        = flag.Bool("load", false, "Load results from previous scans.")
 	debug       = flag.Bool("debug", false, "Enable debug output")
+	showDenied  = flag.Bool("show-denied", false, "Print the sts:AssumeRole attempts that were denied after the scan.")
+	workers     = flag.Int("workers", MaxWorkers, "Maximum number of sts:AssumeRole tests to run at once.")
+	perAccount  = flag.Int("account-workers", 10, `
+Maximum number of sts:AssumeRole tests to run at once from principals in the same account, STS throttles calls per 
+account. Zero or less means only -workers applies.
+`)
+	resume = flag.Bool("resume", false, `
+Resume an interrupted scan. Roles discovered and sts:AssumeRole attempts that were denied in the previous scan are 
+read from the checkpoint saved in ~/.liquidswards/<name>/checkpoint.jsonl and are not tested again.
+`)
+
+	encrypt = flag.Bool("encrypt", false, fmt.Sprintf(`
+Prompt for a passphrase to encrypt the credentials saved in ~/.liquidswards/<name>/ with, and decrypt them when they 
+are loaded. The passphrase is read from the %s environment variable instead when it is set.
+`, secret.PassphraseEnv))
+	ageRecipients = flag.String("age-recipients", "", `
+Encrypt the saved credentials for the age X25519 recipients (public keys) in this file instead of a passphrase.
+`)
+	ageIdentity = flag.String("age-identity", "", `
+Decrypt the saved credentials with the age identities (private keys) in this file.
+`)
+	redact = flag.Bool("redact", false, `
+Save the graph without any credentials. The results can still be queried, compared and exported but credentials 
+can not be printed or refreshed from the saved graph.
+`)
 
 	help = strings.Replace(`
 liquidswards discovers and enumerates access to IAM Roles via sts:SourceAssumeRole API call's. For each account \
@@ -56,6 +89,12 @@ sessions.
 
 	allPlugins = []types.NewPluginFunc{
 		plugins.NewCloudTrail,
+		plugins.NewCloudTrailLogs,
+		plugins.NewOrg,
+		plugins.NewWordlist,
+		plugins.NewReferences,
+		plugins.NewUsers,
+		plugins.NewPassRole,
 		plugins.NewSqs,
 		plugins.NewFile,
 		plugins.NewRefresh,
@@ -83,10 +122,17 @@ func main() {
 		ctx.Debug.Printf("using region %s\n", *region)
 	}
 
-	if len(flag.Args()) > 1 {
+	if len(flag.Args()) > 1 && !isSubcommand(flag.Arg(0)) {
 		ctx.Error.Fatalln("extra arguments detected, did you mean to pass a comma seperated list to -profiles instead?")
 	}
 
+	keyring, err := NewKeyring()
+	if err != nil {
+		ctx.Error.Fatalln(err)
+	}
+	creds.Keyring = keyring
+	creds.Redact = *redact
+
 	if err := Run(); err != nil {
 		ctx.Error.Fatalln(err)
 	}
@@ -98,18 +144,53 @@ func Run() error {
 
 	programDir := utils.Must(GetProgramDir(*name))
 	graphPath := filepath.Join(programDir, "nodes.json")
-
-	if *load {
+	checkpointPath := filepath.Join(programDir, "checkpoint.jsonl")
+	rolesPath := filepath.Join(programDir, "roles.json")
+	observedPath := filepath.Join(programDir, "observed.json")
+	accountsPath := filepath.Join(programDir, "accounts.json")
+	referencesPath := filepath.Join(programDir, "references.json")
+	potentialPath := filepath.Join(programDir, "potential.json")
+
+	// diff only reads the snapshots, so it works before nodes.json is saved.
+	if *load || len(flag.Args()) != 0 && flag.Arg(0) != "diff" {
 		if err := graph.Load(graphPath); err != nil {
 			return fmt.Errorf("error loading graph: %w", err)
 		}
+		if err := graph.LoadObserved(observedPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error loading observed edges: %w", err)
+		}
+		if err := graph.LoadAccounts(accountsPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error loading accounts: %w", err)
+		}
+		if err := graph.LoadReferences(referencesPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error loading references: %w", err)
+		}
+		if err := graph.LoadPotential(potentialPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error loading potential edges: %w", err)
+		}
 	}
 
-	if len(flag.Args()) == 1 {
-		if err := graph.Load(graphPath); err != nil {
-			return fmt.Errorf("error loading graph: %w", err)
+	if len(flag.Args()) != 0 {
+		switch flag.Arg(0) {
+		case "compare":
+			return Compare(graph, rolesPath)
+		case "path":
+			return Path(graph, flag.Args()[1:], os.Stdout)
+		case "export":
+			return Export(graph, programDir, flag.Args()[1:], os.Stderr)
+		case "diff":
+			return Diff(programDir, flag.Args()[1:], os.Stdout)
+		case "credential-process":
+			// Only the credentials can be written to stdout.
+			ctx.Info.SetOutput(os.Stderr)
+			return CredentialProcess(graph, flag.Args()[1:], os.Stdout)
+		case "aws-config":
+			return AwsConfig(graph, flag.Args()[1:], os.Stderr)
+		case "serve":
+			return Serve(graph, flag.Args()[1:], os.Stderr)
+		default:
+			return PrintCreds(graph, flag.Arg(0))
 		}
-		return PrintCreds(graph, flag.Args()[0])
 	}
 
 	// TODO: Move to assumeroles?
@@ -118,19 +199,33 @@ func Run() error {
 		ctx.Error.Fatalf("parsing profiles: %s\n", err)
 	}
 
-	var scope []string
+	var scope *utils.Scope
 	if !*noScope {
-		scope = creds.ParseScope(*scopeStr, cfgs)
-		ctx.Info.Printf("scope is currently set to: %s\n", strings.Join(scope, ", "))
+		scope = utils.NewScope(creds.ParseScope(*scopeStr, cfgs))
+		ctx.Info.Printf("scope is currently set to: %s\n", strings.Join(scope.Accounts(), ", "))
 	} else {
 		ctx.Info.Printf("scope is not currently set!!!")
 	}
 
 	scanCtx := ScanContext(ctx)
 
-	pool := pond.New(MaxWorkers, MaxCapacity, pond.Strategy(pond.Eager()))
+	eng := engine.New(scanCtx, *workers, *perAccount)
+	defer eng.Stop()
 	if *debug {
-		utils.MonitorPoolStats(scanCtx, "assumeRole worker pool:", pool)
+		eng.Monitor(scanCtx, "assumeRole engine:")
+	}
+
+	var cp *checkpoint.Checkpoint
+	if !*noSave || *resume {
+		cp, err = checkpoint.Open(checkpointPath, *resume)
+		if err != nil {
+			return fmt.Errorf("error opening checkpoint: %w", err)
+		}
+		defer func() {
+			if err := cp.Close(); err != nil {
+				ctx.Error.Printf("error closing checkpoint: %s\n", err)
+			}
+		}()
 	}
 
 	args := types.GlobalPluginArgs{
@@ -142,6 +237,34 @@ func Run() error {
 		ProgramDir:       programDir,
 		PrimaryAwsConfig: cfgs[0].Config,
 		AwsConfigs:       cfgs,
+		Checkpoint:       cp,
+		Engine:           eng,
+		Scheduler:        plugins.NewScheduler(scanCtx),
+	}
+
+	// Trust policies of discovered roles are saved for the compare command.
+	var rolesMu sync.Mutex
+	var roles []types.Role
+	if *load || *resume {
+		if saved, err := predict.LoadRoles(rolesPath); err == nil {
+			roles = saved
+		} else if !errors.Is(err, os.ErrNotExist) {
+			ctx.Error.Println(err)
+		}
+	}
+
+	if !*noSave {
+		args.FoundRoles.Walk(func(role types.Role) {
+			if err := cp.AddRole(role.Id()); err != nil {
+				ctx.Error.Println(err)
+			}
+
+			if role.AssumeRolePolicyDocument != nil {
+				rolesMu.Lock()
+				roles = append(roles, role)
+				rolesMu.Unlock()
+			}
+		})
 	}
 
 	var waitable []types.Waitable
@@ -161,20 +284,60 @@ func Run() error {
 		}
 	}
 
+	if *resume {
+		roles := cp.Roles()
+		ctx.Info.Printf("resuming scan with %d previously discovered roles\n", len(roles))
+		for _, arn := range roles {
+			if !scope.Contains(arn) {
+				continue
+			}
+			args.FoundRoles.Add(types.NewRole(arn))
+		}
+	}
+
 	for _, cfg := range cfgs {
 		args.FoundRoles.Add(types.NewRole(cfg.Arn()))
 		args.Access.Add(cfg)
 	}
 
-	for _, w := range waitable {
-		w.Wait()
-	}
+	waitForDiscovery(scanCtx, eng, waitable)
+	_, _, completed, panicked := eng.Stats()
+	ctx.Info.Printf("discovery finished after %d tests (%d panicked)\n", completed, panicked)
 
 	if !*noSave {
 		err := graph.Save(graphPath)
 		if err != nil {
 			ctx.Error.Fatalf("error saving report: %s\n", err)
 		}
+
+		snapshotPath, err := snapshot.Save(programDir, graph, time.Now())
+		if err != nil {
+			ctx.Error.Fatalf("error saving snapshot: %s\n", err)
+		}
+		ctx.Info.Printf("snapshot saved to %s\n", snapshotPath)
+
+		if err := graph.SaveObserved(observedPath); err != nil {
+			ctx.Error.Fatalf("error saving observed edges: %s\n", err)
+		}
+
+		if err := graph.SaveAccounts(accountsPath); err != nil {
+			ctx.Error.Fatalf("error saving accounts: %s\n", err)
+		}
+
+		if err := graph.SaveReferences(referencesPath); err != nil {
+			ctx.Error.Fatalf("error saving references: %s\n", err)
+		}
+
+		if err := graph.SavePotential(potentialPath); err != nil {
+			ctx.Error.Fatalf("error saving potential edges: %s\n", err)
+		}
+
+		rolesMu.Lock()
+		err = predict.SaveRoles(rolesPath, dedupeRoles(roles))
+		rolesMu.Unlock()
+		if err != nil {
+			ctx.Error.Fatalf("error saving roles: %s\n", err)
+		}
 	}
 
 	if len(graph.Nodes()) != 0 {
@@ -190,11 +353,52 @@ func Run() error {
 		fmt.Println("\n\tOr if the graph is to complex you can simplify it by removing redundant paths first:")
 		fmt.Printf("\t\ttred %s | dot -Tpng /dev/stdin -o graph.png\n", graphVizPath)
 		fmt.Printf("\t\ttred %s | circo -Tpng /dev/stdin -o graph.png\n", graphVizPath)
+
+		reportPath := filepath.Join(args.ProgramDir, "report.html")
+		err = export.Write(export.FormatHTML, reportPath, export.FromGraph(graph))
+		if err != nil {
+			ctx.Error.Fatalf("generating html report failed: %s\n", err)
+		}
+		fmt.Printf("\n\tFor large graphs the interactive report may be easier to explore, open it in a browser:\n\t\t%s\n", reportPath)
+	}
+
+	if *showDenied {
+		graph.PrintDenied()
 	}
 
 	return nil
 }
 
+// Compare prints the edges where the trust policies of the roles saved in the last scan disagree with the graph.
+func Compare(g *graph.Graph[*creds.Config], rolesPath string) error {
+	roles, err := predict.LoadRoles(rolesPath)
+	if err != nil {
+		return fmt.Errorf("error loading roles, was the last scan run with -no-save?: %w", err)
+	}
+
+	predict.Compare(g, roles).Print(os.Stdout)
+	return nil
+}
+
+// dedupeRoles keeps the last role seen for each ARN, so roles found in this scan replace ones that were loaded.
+func dedupeRoles(roles []types.Role) []types.Role {
+	byArn := map[string]types.Role{}
+	for _, role := range roles {
+		byArn[role.Id()] = role
+	}
+
+	var resp []types.Role
+	for _, role := range byArn {
+		resp = append(resp, role)
+	}
+	return resp
+}
+
+// isSubcommand returns true if arg is the name of a command rather than the ARN of a role to print credentials for.
+func isSubcommand(arg string) bool {
+	return utils.In([]string{"aws-config", "compare", "credential-process", "diff", "export", "path", "serve"}, arg)
+}
+
 func PrintCreds(g *graph.Graph[*creds.Config], arn string) error {
 	node, ok := g.GetNode(arn)
 	if !ok {
@@ -213,6 +417,38 @@ func PrintCreds(g *graph.Graph[*creds.Config], arn string) error {
 	return nil
 }
 
+// NewKeyring returns the keyring used to encrypt saved credentials, or nil if encryption isn't enabled.
+func NewKeyring() (*secret.Keyring, error) {
+	if *ageRecipients != "" || *ageIdentity != "" {
+		keyring, err := secret.NewAgeKeyring(*ageRecipients, *ageIdentity)
+		if err != nil {
+			return nil, fmt.Errorf("NewKeyring(): %w", err)
+		} else if !keyring.CanSeal() && !*noSave && !*redact && len(flag.Args()) == 0 {
+			return nil, fmt.Errorf("NewKeyring(): -age-recipients is required to save encrypted credentials")
+		}
+		return keyring, nil
+	}
+
+	passphrase := os.Getenv(secret.PassphraseEnv)
+	if passphrase == "" && *encrypt {
+		utils.Must(fmt.Fprint(os.Stderr, "Passphrase: "))
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		utils.Must(fmt.Fprintln(os.Stderr))
+		if err != nil {
+			return nil, fmt.Errorf("NewKeyring(): reading passphrase: %w", err)
+		}
+		passphrase = string(b)
+	}
+
+	if passphrase == "" {
+		if *encrypt {
+			return nil, fmt.Errorf("NewKeyring(): the passphrase is empty")
+		}
+		return nil, nil
+	}
+	return secret.NewPassphraseKeyring(passphrase)
+}
+
 func GetProgramDir(name string) (string, error) {
 	path, err := utils.ExpandPath(fmt.Sprintf("~/.liquidswards/%s", name))
 	if err != nil {
@@ -226,6 +462,22 @@ func GetProgramDir(name string) (string, error) {
 	return path, err
 }
 
+// waitForDiscovery blocks until the engine and the plugins are idle at the same time, or the scan is cancelled. Every
+// sts:AssumeRole test runs on the engine, but some plugins like cloudtrail find roles from their own goroutines, so
+// more tests can be queued after the engine goes idle.
+func waitForDiscovery(ctx utils.Context, eng *engine.Engine, waitable []types.Waitable) {
+	for {
+		eng.Wait()
+		for _, w := range waitable {
+			w.Wait()
+		}
+
+		if queued, running, _, _ := eng.Stats(); queued+running == 0 || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
 func ScanContext(ctx utils.Context) utils.Context {
 	sigs := utils.SigTermChan()
 	scanCtx, cancelScan := ctx.WithCancel()
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/engine"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/predict"
+	"github.com/RyanJarv/liquidswards/lib/sim"
+	"github.com/RyanJarv/liquidswards/lib/snapshot"
+	"github.com/RyanJarv/liquidswards/lib/types"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/google/go-cmp/cmp"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const simFixture = `{
+  "Accounts": [
+    {
+      "Id": "111111111111",
+      "Users": [
+        {"Name": "alice", "AccessKeyId": "AKIAALICE", "SecretAccessKey": "secret"}
+      ],
+      "Roles": [
+        {"Name": "a", "TrustPolicy": %[1]s},
+        {"Name": "b", "TrustPolicy": %[2]s},
+        {"Name": "c", "TrustPolicy": %[3]s}
+      ]
+    },
+    {
+      "Id": "222222222222",
+      "Roles": [
+        {"Name": "x", "TrustPolicy": %[4]s}
+      ]
+    }
+  ]
+}`
+
+func trustPolicy(principal string) string {
+	return fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [{"Effect": "Allow", "Principal": {"AWS": "%s"}, "Action": "sts:AssumeRole"}]
+	}`, principal)
+}
+
+// NewTestSimulator starts a simulator and points the SDK and the AWS config files at it.
+func NewTestSimulator(t *testing.T) *sim.Simulator {
+	fixture := fmt.Sprintf(simFixture,
+		trustPolicy("arn:aws:iam::111111111111:user/alice"),
+		trustPolicy("arn:aws:iam::111111111111:role/a"),
+		trustPolicy("arn:aws:iam::111111111111:role/b"),
+		trustPolicy("111111111111"),
+	)
+
+	dir := t.TempDir()
+	fixturePath := filepath.Join(dir, "fixture.json")
+	if err := os.WriteFile(fixturePath, []byte(fixture), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := sim.LoadFixture(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sim.New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+
+	creds.EndpointResolver = s.EndpointResolver()
+	t.Cleanup(func() { creds.EndpointResolver = nil })
+
+	credsPath := filepath.Join(dir, "credentials")
+	if err := os.WriteFile(credsPath, []byte("[alice]\naws_access_key_id = AKIAALICE\naws_secret_access_key = secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credsPath)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("HOME", dir)
+
+	return s
+}
+
+func setFlags(t *testing.T, flags map[string]string) {
+	for k, v := range flags {
+		prev := flag.Lookup(k).Value.String()
+		if err := flag.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = flag.Set(k, prev) })
+	}
+}
+
+// TestRun_Simulator runs a full scan against the simulator.
+func TestRun_Simulator(t *testing.T) {
+	s := NewTestSimulator(t)
+
+	// x is trusted by alice's account but is out of scope, it should never be tested.
+	roleFile := filepath.Join(t.TempDir(), "roles.txt")
+	if err := os.WriteFile(roleFile, []byte("arn:aws:iam::222222222222:role/x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	setFlags(t, map[string]string{
+		"profiles": "alice",
+		"name":     "sim",
+		"file":     roleFile,
+	})
+
+	if err := Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	g := graph.NewDirectedGraph[*creds.Config]()
+	if err := g.Load(filepath.Join(os.Getenv("HOME"), ".liquidswards", "sim", "nodes.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(os.Getenv("HOME"), ".liquidswards", "sim", "report.html")); err != nil {
+		t.Errorf("html report: %s", err)
+	}
+
+	got := map[string][]string{}
+	for id, node := range g.Nodes() {
+		got[id] = []string{}
+		for out := range node.Outbound() {
+			got[id] = append(got[id], out)
+		}
+		sort.Strings(got[id])
+	}
+
+	// c only trusts b, which is reached through recursion from alice -> a -> b.
+	want := map[string][]string{
+		"arn:aws:iam::111111111111:user/alice": {"arn:aws:iam::111111111111:role/a"},
+		"arn:aws:iam::111111111111:role/a":     {"arn:aws:iam::111111111111:role/b"},
+		"arn:aws:iam::111111111111:role/b":     {"arn:aws:iam::111111111111:role/c"},
+		"arn:aws:iam::111111111111:role/c":     {},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("graph edges (-got +want):\n%s", diff)
+	}
+
+	if !utils.In(s.AssumeRoleCalls, "arn:aws:iam::111111111111:role/a") {
+		t.Errorf("expected role/a to be tested, got %v", s.AssumeRoleCalls)
+	}
+	if utils.In(s.AssumeRoleCalls, "arn:aws:iam::222222222222:role/x") {
+		t.Error("out of scope role/x was tested")
+	}
+
+	if status := g.EdgeStatus("arn:aws:iam::111111111111:user/alice", "arn:aws:iam::111111111111:role/b"); status != graph.EdgeDenied {
+		t.Errorf("EdgeStatus(alice, b): got %d, want %d", status, graph.EdgeDenied)
+	}
+
+	// alice is the name of the profile, c is resolved by the role name.
+	var buf bytes.Buffer
+	if err := Path(g, []string{"-json", "alice", "c"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var paths [][]string
+	if err := json.Unmarshal(buf.Bytes(), &paths); err != nil {
+		t.Fatal(err)
+	}
+	wantPath := [][]string{{
+		"arn:aws:iam::111111111111:user/alice",
+		"arn:aws:iam::111111111111:role/a",
+		"arn:aws:iam::111111111111:role/b",
+		"arn:aws:iam::111111111111:role/c",
+	}}
+	if diff := cmp.Diff(paths, wantPath); diff != "" {
+		t.Errorf("path alice c (-got +want):\n%s", diff)
+	}
+
+	buf.Reset()
+	if err := Path(g, []string{"-reachers", "arn:aws:iam::111111111111:role/b"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "arn:aws:iam::111111111111:role/a\narn:aws:iam::111111111111:user/alice\n"; got != want {
+		t.Errorf("path -reachers b: got %q, want %q", got, want)
+	}
+
+	exportDir := filepath.Join(t.TempDir(), "neo4j")
+	if err := Export(g, "", []string{"-format", "neo4j-csv", "-o", exportDir}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(exportDir, "relationships.csv")); err != nil {
+		t.Errorf("export -format neo4j-csv: %s", err)
+	}
+
+	// The scan is saved as a snapshot, comparing it with an empty graph shows everything as gained.
+	emptyPath := filepath.Join(t.TempDir(), "empty.json")
+	if err := graph.NewDirectedGraph[*creds.Config]().Save(emptyPath); err != nil {
+		t.Fatal(err)
+	}
+	programDir := filepath.Join(os.Getenv("HOME"), ".liquidswards", "sim")
+	buf.Reset()
+	if err := Diff(programDir, []string{"-json", "-account", "111111111111", emptyPath}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var d snapshot.Diff
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.AddedNodes) != 4 || len(d.AddedEdges) != 3 || len(d.RemovedNodes) != 0 {
+		t.Errorf("diff against an empty graph: %+v", d)
+	}
+
+	// Trust policies from iam:ListRoles are saved for the compare command.
+	roles, err := predict.LoadRoles(filepath.Join(os.Getenv("HOME"), ".liquidswards", "sim", "roles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := predict.Compare(g, roles)
+	if len(report.Unexpected) != 0 || len(report.Missing) != 0 || len(report.Errors) != 0 {
+		t.Errorf("the simulator evaluates trust policies the same way, expected no disagreements: %+v", report)
+	}
+
+	// credential-process refreshes c through the graph once the saved credentials expire.
+	c, _ := g.GetNode("arn:aws:iam::111111111111:role/c")
+	c.Value().SetProvider(aws.NewCredentialsCache(credentials.StaticCredentialsProvider{Value: aws.Credentials{
+		AccessKeyID: "AKIAEXPIRED", SecretAccessKey: "expired", CanExpire: true, Expires: time.Now().Add(-time.Minute),
+	}}))
+	buf.Reset()
+	if err := CredentialProcess(g, []string{"c"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var processCreds creds.ProcessCredentials
+	if err := json.Unmarshal(buf.Bytes(), &processCreds); err != nil {
+		t.Fatal(err)
+	}
+	if processCreds.Version != 1 || processCreds.AccessKeyId == "" || processCreds.AccessKeyId == "AKIAEXPIRED" ||
+		processCreds.Expiration == nil || processCreds.Expiration.Before(time.Now()) {
+		t.Errorf("credential-process c: unexpected credentials %+v", processCreds)
+	}
+
+	// Refreshing c goes through the graph, b's saved credentials are used to assume c again.
+	provider := creds.NewGraphProvider(ctx, g, "arn:aws:iam::111111111111:role/c")
+	if _, err := provider.Retrieve(ctx); err != nil {
+		t.Errorf("refreshing c: %s", err)
+	}
+
+	// Once b's sessions are revoked c can no longer be refreshed from the saved graph.
+	s.RevokeSessions("arn:aws:iam::111111111111:role/b")
+	provider = creds.NewGraphProvider(ctx, g, "arn:aws:iam::111111111111:role/c")
+	if _, err := provider.Retrieve(ctx); err == nil {
+		t.Error("expected refreshing c to fail after revoking b's sessions")
+	}
+}
+
+func TestAwsConfigProfiles(t *testing.T) {
+	g := graph.NewDirectedGraph[*creds.Config]()
+	for _, arn := range []string{
+		"arn:aws:iam::111111111111:user/alice",
+		"arn:aws:iam::111111111111:role/admin",
+		"arn:aws:iam::222222222222:role/admin",
+		"arn:aws:iam::222222222222:role/path/deploy",
+	} {
+		cfg, err := creds.NewConfig(ctx, "us-west-2", creds.Identity{Type: creds.SourceAssumeRole, Name: arn, Arn: arn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.AddNode(cfg)
+	}
+
+	got := AwsConfigProfiles(g, "test-", []string{"/opt/my tools/liquidswards", "-name", "test"})
+	for _, want := range []string{
+		"[profile test-admin-111111111111]\ncredential_process = \"/opt/my tools/liquidswards\" -name test credential-process arn:aws:iam::111111111111:role/admin\nregion = us-west-2\n",
+		"[profile test-admin-222222222222]\n",
+		"[profile test-deploy]\n",
+		"[profile test-alice]\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AwsConfigProfiles() is missing %q:\n%s", want, got)
+		}
+	}
+
+	current := []byte("[default]\nregion = us-east-1\n# BEGIN liquidswards test\nold\n# END liquidswards test\n[profile other]\n")
+	want := "[default]\nregion = us-east-1\n# BEGIN liquidswards test\nnew\n# END liquidswards test\n[profile other]\n"
+	if got := string(replaceBlock(current, "test", "new\n")); got != want {
+		t.Errorf("replaceBlock(): got %q, want %q", got, want)
+	}
+	if got := string(replaceBlock([]byte("[default]"), "test", "new\n")); got != "[default]\n# BEGIN liquidswards test\nnew\n# END liquidswards test\n" {
+		t.Errorf("replaceBlock() without an existing block: got %q", got)
+	}
+}
+
+// TestWaitForDiscovery ensures tests queued by plugins after the engine goes idle finish before discovery does.
+func TestWaitForDiscovery(t *testing.T) {
+	eng := engine.New(ctx, 2, 0)
+	defer eng.Stop()
+
+	// The plugin queues a test from its own goroutine after the engine goes idle, like the cloudtrail plugins do.
+	var done atomic.Bool
+	w := &sync.WaitGroup{}
+	w.Add(1)
+	go func() {
+		defer w.Done()
+		time.Sleep(10 * time.Millisecond)
+		eng.Submit("111111111111", func() {
+			time.Sleep(10 * time.Millisecond)
+			done.Store(true)
+		})
+	}()
+
+	waitForDiscovery(ctx, eng, []types.Waitable{w})
+	if !done.Load() {
+		t.Error("discovery finished before the test queued by the plugin")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"io"
+	"sort"
+	"strings"
+)
+
+const pathUsage = `usage: liquidswards [-name <name>] path [-all] [-max-depth <n>] [-json] <from> <to>
+       liquidswards [-name <name>] path -reachers [-json] <to>
+
+Query the graph saved by a previous scan. <from> and <to> are principal ARN's, profile names or role/user names.
+By default the shortest path is printed.
+
+`
+
+// Path runs the path command against the saved graph, the output is written to w.
+func Path(g *graph.Graph[*creds.Config], args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("path", flag.ContinueOnError)
+	fs.SetOutput(w)
+	all := fs.Bool("all", false, "List every path without repeating a principal rather than only the shortest.")
+	maxDepth := fs.Int("max-depth", 5, "Maximum number of sts:AssumeRole calls in a path when -all is used, 0 means no limit.")
+	reachers := fs.Bool("reachers", false, "List every principal that can reach <to>.")
+	asJson := fs.Bool("json", false, "Print the result as JSON.")
+	fs.Usage = func() {
+		utils.Must(fmt.Fprint(w, pathUsage))
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("Path(): %w", err)
+	}
+
+	if *reachers {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return fmt.Errorf("Path(): -reachers expects one principal, got %d", fs.NArg())
+		}
+		to, err := resolvePrincipal(g, fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("Path(): %w", err)
+		}
+
+		found := g.Reachers(to)
+		if *asJson {
+			return writeJson(w, found)
+		}
+		for _, id := range found {
+			utils.Must(fmt.Fprintln(w, id))
+		}
+		return nil
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("Path(): expected <from> and <to>, got %d arguments", fs.NArg())
+	}
+	from, err := resolvePrincipal(g, fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("Path(): %w", err)
+	}
+	to, err := resolvePrincipal(g, fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("Path(): %w", err)
+	}
+
+	var paths [][]string
+	if *all {
+		paths = g.AllPaths(from, to, *maxDepth)
+	} else if path, ok := g.ShortestPath(from, to); ok {
+		paths = [][]string{path}
+	}
+
+	if *asJson {
+		if paths == nil {
+			paths = [][]string{}
+		}
+		return writeJson(w, paths)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("Path(): no path found from %s to %s", from, to)
+	}
+	for _, path := range paths {
+		utils.Must(fmt.Fprintln(w, strings.Join(path, utils.Arrow)))
+	}
+	return nil
+}
+
+// resolvePrincipal returns the ID of the node name refers to. This can be the ARN, the name of a profile passed with
+// -profiles or the role/user name if only one principal has that name.
+func resolvePrincipal(g *graph.Graph[*creds.Config], name string) (string, error) {
+	if _, ok := g.GetNode(name); ok {
+		return name, nil
+	}
+
+	var matches []string
+	for id, node := range g.Nodes() {
+		cfg := node.Value()
+		if cfg.Identity.Type == creds.SourceProfile && cfg.Identity.Name == name {
+			return id, nil
+		} else if cfg.Name() == name {
+			matches = append(matches, id)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("principal %s not found in graph", name)
+	case 1:
+		return matches[0], nil
+	default:
+		sort.Strings(matches)
+		return "", fmt.Errorf("%s is ambiguous, use the ARN instead: %s", name, strings.Join(matches, ", "))
+	}
+}
+
+func writeJson(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("writeJson(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/RyanJarv/liquidswards/lib/creds"
+	"github.com/RyanJarv/liquidswards/lib/graph"
+	"github.com/RyanJarv/liquidswards/lib/serve"
+	"github.com/RyanJarv/liquidswards/lib/utils"
+	"io"
+	"net"
+	"net/http"
+)
+
+const serveUsage = `usage: liquidswards [-name <name>] serve [-listen <addr>] [-imds-listen <addr>] [-token <token>] <principal>
+
+Serve the credentials of a principal in the graph saved by a previous scan over endpoints compatible with the ECS
+container credentials provider and the EC2 instance metadata service (IMDSv2). Credentials are refreshed through the
+graph when they expire. <principal> is an ARN, profile name or role/user name.
+
+The IMDS endpoint can't require the token since SDKs don't send it, like EC2 any local process can reach it. Pass
+-imds-listen "" to disable it.
+
+The principal can be switched while running with:
+
+	curl -X PUT -H "Authorization: <token>" -d <principal> http://<addr>/admin/role
+
+`
+
+// Serve runs the serve command until interrupted, the environment variables to use it are written to w.
+func Serve(g *graph.Graph[*creds.Config], args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(w)
+	listen := fs.String("listen", "127.0.0.1:9911", "Address to listen on, this has to be a loopback address.")
+	imdsListen := fs.String("imds-listen", "127.0.0.1:9912", "Address to serve the IMDS endpoint on, this has to be a loopback address.")
+	token := fs.String("token", "", "Token required to access the endpoints, a random one is generated by default.")
+	fs.Usage = func() {
+		utils.Must(fmt.Fprint(w, serveUsage))
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("Serve(): %w", err)
+	} else if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("Serve(): expected one principal, got %d", fs.NArg())
+	}
+
+	if *token == "" {
+		var err error
+		if *token, err = serve.NewToken(); err != nil {
+			return fmt.Errorf("Serve(): %w", err)
+		}
+	}
+
+	arn, err := resolvePrincipal(g, fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("Serve(): %w", err)
+	}
+	s, err := serve.New(ctx, g, arn, *token)
+	if err != nil {
+		return fmt.Errorf("Serve(): %w", err)
+	}
+	s.Resolve = func(name string) (string, error) {
+		return resolvePrincipal(g, name)
+	}
+
+	l, err := serve.Listen(*listen)
+	if err != nil {
+		return fmt.Errorf("Serve(): %w", err)
+	}
+
+	utils.Must(fmt.Fprintf(w, "Serving credentials for %s, use one of the following to access them:\n\n", arn))
+	utils.Must(fmt.Fprintf(w, "\texport AWS_CONTAINER_CREDENTIALS_FULL_URI=http://%s/creds AWS_CONTAINER_AUTHORIZATION_TOKEN=%s\n", l.Addr(), *token))
+
+	servers := map[*http.Server]net.Listener{{Handler: s.Handler()}: l}
+	if *imdsListen != "" {
+		il, err := serve.Listen(*imdsListen)
+		if err != nil {
+			utils.Must0(l.Close())
+			return fmt.Errorf("Serve(): %w", err)
+		}
+		servers[&http.Server{Handler: s.IMDSHandler()}] = il
+		utils.Must(fmt.Fprintf(w, "\texport AWS_EC2_METADATA_SERVICE_ENDPOINT=http://%s/\n", il.Addr()))
+	}
+	utils.Must(fmt.Fprintln(w))
+
+	go func() {
+		<-utils.SigTermChan()
+		ctx.Info.Println("Received signal, shutting down...")
+		for server := range servers {
+			utils.Must0(server.Close())
+		}
+	}()
+
+	errs := make(chan error, len(servers))
+	for server, l := range servers {
+		go func() {
+			errs <- server.Serve(l)
+		}()
+	}
+
+	// The servers are closed together on a signal, otherwise the first one to fail ends the command.
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Serve(): %w", err)
+	}
+	return nil
+}